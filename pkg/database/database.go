@@ -1,68 +1,289 @@
 package database
 
 import (
+	"errors"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
-	"github.com/nalgeon/redka"
 	_ "modernc.org/sqlite"
-	"os"
-	"os/signal"
 	"sync"
-	"syscall"
+	"time"
 )
 
 var (
-	dbMap = make(map[string]*redka.DB)
-	dbMu  sync.RWMutex // Mutex to handle concurrent access
+	// ErrPoolClosed is returned by GetDB once the pool has been closed.
+	ErrPoolClosed = errors.New("database pool is closed")
+	// ErrPoolFull is returned by GetDB when MaxOpen handles are leased and
+	// none of them can be evicted to open another file.
+	ErrPoolFull = errors.New("too many open seed databases")
 )
 
-func init() {
-
-	// Handle termination signals to close all database connections
-	go func() {
-		sigChan := make(chan os.Signal, 1)
-		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-		<-sigChan // Block until a termination signal is received
-
-		// Close all database connections before exiting
-		dbMu.Lock()
-		defer dbMu.Unlock()
-		for filename, db := range dbMap {
-			if err := db.Close(); err != nil {
-				log.DefaultLogger.Error("Error closing database connection for", "filename", filename, "error", err)
-			}
-		}
-	}()
+// PoolOptions configures the handle cache of a Pool.
+type PoolOptions struct {
+	// IdleTimeout closes handles nobody has leased for this long.
+	// Zero keeps idle handles open until the pool is closed.
+	IdleTimeout time.Duration
+	// MaxOpen caps the number of open handles. Zero means no cap.
+	MaxOpen int
+	// SQLite is applied to every seed database the pool opens.
+	SQLite SQLiteOptions
+}
+
+// PoolStats is a snapshot of the handle cache.
+type PoolStats struct {
+	Open        int    `json:"open"`
+	Leased      int    `json:"leased"`
+	MaxOpen     int    `json:"maxOpen"`
+	IdleTimeout string `json:"idleTimeout"`
+	Hits        int64  `json:"hits"`
+	Misses      int64  `json:"misses"`
+	Evictions   int64  `json:"evictions"`
+}
+
+type poolEntry struct {
+	db       *Handle
+	refs     int
+	lastUsed time.Time
+
+	// ready is closed once db is open and migrated, or err is set.
+	ready chan struct{}
+	err   error
+}
+
+// Pool keeps the open seed databases of one app instance, keyed by filename.
+// Grafana creates a new instance whenever the app settings change, so every
+// instance owns its handles and closes them when it is disposed.
+//
+// Handles are reference counted: GetDB hands out a Lease that must be
+// released, and only handles without leases are evicted. Files are opened
+// and migrated outside the pool lock, so that a long migration only holds
+// up the requests for its own file.
+type Pool struct {
+	opts   PoolOptions
+	dbMap  map[string]*poolEntry
+	dbMu   sync.Mutex // Mutex to handle concurrent access
+	closed bool
+	done   chan struct{}
+
+	hits, misses, evictions int64
+}
+
+// NewPool creates an empty pool and starts its idle eviction when enabled.
+func NewPool(opts PoolOptions) *Pool {
+	p := &Pool{
+		opts:  opts,
+		dbMap: make(map[string]*poolEntry),
+		done:  make(chan struct{}),
+	}
+	if opts.IdleTimeout > 0 {
+		go p.evictLoop()
+	}
+	return p
+}
+
+// Lease is a seed database borrowed from a Pool. It stays open at least
+// until Release is called.
+type Lease struct {
+	*Handle
+	pool     *Pool
+	filename string
+	once     sync.Once
+}
+
+// Release returns the handle to the pool. Further calls are no-ops.
+func (l *Lease) Release() {
+	l.once.Do(func() {
+		l.pool.release(l.filename)
+	})
 }
 
 // Retrieves the database connection for a given filename, opening it if necessary.
-func GetDB(filename string) (*redka.DB, error) {
-	dbMu.RLock()
-	db, exists := dbMap[filename]
-	dbMu.RUnlock()
+// Requests for a file that is being opened wait for it.
+func (p *Pool) GetDB(filename string) (*Lease, error) {
+	p.dbMu.Lock()
+	if p.closed {
+		p.dbMu.Unlock()
+		return nil, ErrPoolClosed
+	}
 
+	e, exists := p.dbMap[filename]
 	if exists {
-		return db, nil // Return existing connection
+		p.hits++
+	} else {
+		// Open a new connection if it doesn't exist
+		if p.opts.MaxOpen > 0 && len(p.dbMap) >= p.opts.MaxOpen && !p.evictLRU() {
+			p.dbMu.Unlock()
+			return nil, ErrPoolFull
+		}
+		p.misses++
+		e = &poolEntry{ready: make(chan struct{})}
+		p.dbMap[filename] = e
 	}
+	e.refs++
+	e.lastUsed = time.Now()
+	p.dbMu.Unlock()
 
-	// Open a new connection if it doesn't exist
-	dbMu.Lock()
-	defer dbMu.Unlock()
-
-	// Check again in case another goroutine opened it
-	if db, exists := dbMap[filename]; exists {
-		return db, nil
+	if !exists {
+		p.open(filename, e)
 	}
+	<-e.ready
+	if e.err != nil {
+		return nil, e.err
+	}
+	return &Lease{Handle: e.db, pool: p, filename: filename}, nil
+}
 
-	opts := redka.Options{
-		DriverName: "sqlite",
+// open opens and migrates the file of a new entry, then wakes up the
+// requests waiting for it. Entries that failed to open are dropped, so that
+// the next request tries again.
+func (p *Pool) open(filename string, e *poolEntry) {
+	db, err := Open(filename, p.opts.SQLite)
+	if err == nil {
+		if err = Migrate(db, filename); err != nil {
+			db.Close()
+		}
 	}
 
-	var err error
-	db, err = redka.Open(filename, &opts)
+	p.dbMu.Lock()
+	defer p.dbMu.Unlock()
+	if err == nil && p.closed {
+		db.Close()
+		err = ErrPoolClosed
+	}
 	if err != nil {
-		return nil, err
+		e.err = err
+		if p.dbMap[filename] == e {
+			delete(p.dbMap, filename)
+		}
+	} else {
+		e.db = db
+	}
+	close(e.ready)
+}
+
+func (p *Pool) release(filename string) {
+	p.dbMu.Lock()
+	defer p.dbMu.Unlock()
+
+	if e, ok := p.dbMap[filename]; ok {
+		e.refs--
+		e.lastUsed = time.Now()
+	}
+}
+
+// evictLRU closes the least recently used handle without leases.
+// The caller holds dbMu.
+func (p *Pool) evictLRU() bool {
+	var lruName string
+	var lru *poolEntry
+	for filename, e := range p.dbMap {
+		if e.refs == 0 && (lru == nil || e.lastUsed.Before(lru.lastUsed)) {
+			lruName, lru = filename, e
+		}
+	}
+	if lru == nil {
+		return false
+	}
+	p.closeEntry(lruName, lru)
+	return true
+}
+
+// EvictIdle closes the handles nobody has leased for at least idle and
+// returns how many were closed.
+func (p *Pool) EvictIdle(idle time.Duration) int {
+	p.dbMu.Lock()
+	defer p.dbMu.Unlock()
+
+	cutoff := time.Now().Add(-idle)
+	n := 0
+	for filename, e := range p.dbMap {
+		if e.refs == 0 && !e.lastUsed.After(cutoff) {
+			p.closeEntry(filename, e)
+			n++
+		}
+	}
+	return n
+}
+
+// closeEntry closes and forgets a handle. The caller holds dbMu.
+func (p *Pool) closeEntry(filename string, e *poolEntry) {
+	if err := e.db.Close(); err != nil {
+		log.DefaultLogger.Error("Error closing database connection for", "filename", filename, "error", err)
+	}
+	delete(p.dbMap, filename)
+	p.evictions++
+	log.DefaultLogger.Debug("Evicted seed database", "filename", filename)
+}
+
+func (p *Pool) evictLoop() {
+	interval := p.opts.IdleTimeout / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			p.EvictIdle(p.opts.IdleTimeout)
+		}
+	}
+}
+
+// Len returns the number of open databases.
+func (p *Pool) Len() int {
+	p.dbMu.Lock()
+	defer p.dbMu.Unlock()
+	return len(p.dbMap)
+}
+
+// Stats returns a snapshot of the cache counters.
+func (p *Pool) Stats() PoolStats {
+	p.dbMu.Lock()
+	defer p.dbMu.Unlock()
+
+	stats := PoolStats{
+		Open:        len(p.dbMap),
+		MaxOpen:     p.opts.MaxOpen,
+		IdleTimeout: p.opts.IdleTimeout.String(),
+		Hits:        p.hits,
+		Misses:      p.misses,
+		Evictions:   p.evictions,
+	}
+	for _, e := range p.dbMap {
+		if e.refs > 0 {
+			stats.Leased++
+		}
+	}
+	return stats
+}
+
+// Close closes every open database and makes further GetDB calls fail.
+// Closing the last connection of a file checkpoints its WAL, so everything
+// written through the pool is flushed to the database file.
+func (p *Pool) Close() error {
+	p.dbMu.Lock()
+	defer p.dbMu.Unlock()
+
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	close(p.done)
+
+	var errs []error
+	for filename, e := range p.dbMap {
+		delete(p.dbMap, filename)
+		if e.db == nil {
+			// Still opening; open closes it when it is done.
+			continue
+		}
+		if err := e.db.Close(); err != nil {
+			log.DefaultLogger.Error("Error closing database connection for", "filename", filename, "error", err)
+			errs = append(errs, err)
+		}
 	}
 
-	dbMap[filename] = db
-	return db, nil
+	return errors.Join(errs...)
 }
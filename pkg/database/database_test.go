@@ -0,0 +1,110 @@
+package database
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestPoolLeases checks that leased handles are shared and never evicted,
+// that idle ones are, and that MaxOpen caps the cache.
+func TestPoolLeases(t *testing.T) {
+	dir := t.TempDir()
+	p := NewPool(PoolOptions{MaxOpen: 2})
+	defer p.Close()
+
+	a1, err := p.GetDB(filepath.Join(dir, "a.db"))
+	if err != nil {
+		t.Fatalf("get a: %s", err)
+	}
+	a2, err := p.GetDB(filepath.Join(dir, "a.db"))
+	if err != nil {
+		t.Fatalf("get a again: %s", err)
+	}
+	if a1.Handle != a2.Handle {
+		t.Error("leases of the same file should share the handle")
+	}
+
+	b, err := p.GetDB(filepath.Join(dir, "b.db"))
+	if err != nil {
+		t.Fatalf("get b: %s", err)
+	}
+	if _, err := p.GetDB(filepath.Join(dir, "c.db")); !errors.Is(err, ErrPoolFull) {
+		t.Fatalf("third file with every handle leased should fail with ErrPoolFull, got %v", err)
+	}
+
+	// Releasing b makes it the only evictable handle.
+	b.Release()
+	b.Release()
+	c, err := p.GetDB(filepath.Join(dir, "c.db"))
+	if err != nil {
+		t.Fatalf("get c after releasing b: %s", err)
+	}
+	c.Release()
+
+	if n := p.EvictIdle(0); n != 1 {
+		t.Errorf("only c should be idle, evicted %d", n)
+	}
+	a1.Release()
+	if n := p.EvictIdle(time.Hour); n != 0 {
+		t.Errorf("a was just used and should stay open, evicted %d", n)
+	}
+	a2.Release()
+	if n := p.EvictIdle(0); n != 1 {
+		t.Errorf("a should be evicted once all leases are released, evicted %d", n)
+	}
+
+	stats := p.Stats()
+	if stats.Open != 0 || stats.Leased != 0 {
+		t.Errorf("pool should be empty, got %+v", stats)
+	}
+	if stats.Hits != 1 || stats.Misses != 3 || stats.Evictions != 3 {
+		t.Errorf("unexpected counters %+v", stats)
+	}
+}
+
+// TestPoolOpening checks that a file being opened holds up the requests for
+// it, and only those.
+func TestPoolOpening(t *testing.T) {
+	dir := t.TempDir()
+	p := NewPool(PoolOptions{})
+	defer p.Close()
+
+	// A file whose migration is still running.
+	slow := filepath.Join(dir, "slow.db")
+	opening := &poolEntry{refs: 1, ready: make(chan struct{})}
+	p.dbMap[slow] = opening
+
+	got := make(chan *Lease)
+	go func() {
+		l, err := p.GetDB(slow)
+		if err != nil {
+			t.Errorf("get slow: %s", err)
+		}
+		got <- l
+	}()
+
+	other, err := p.GetDB(filepath.Join(dir, "other.db"))
+	if err != nil {
+		t.Fatalf("other files should open meanwhile: %s", err)
+	}
+	other.Release()
+	select {
+	case <-got:
+		t.Fatal("slow should not be handed out before it is open")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	h, err := Open(slow, SQLiteOptions{})
+	if err != nil {
+		t.Fatalf("open: %s", err)
+	}
+	p.dbMu.Lock()
+	opening.db = h
+	close(opening.ready)
+	p.dbMu.Unlock()
+	if l := <-got; l == nil || l.Handle != h {
+		t.Errorf("waiting request should get the opened handle, got %v", l)
+	}
+}
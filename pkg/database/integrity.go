@@ -0,0 +1,213 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/nalgeon/redka"
+)
+
+// Kinds of integrity issues.
+const (
+	IssueSQLite         = "sqlite"           // PRAGMA integrity_check finding
+	IssueMissingHash    = "missingHash"      // lastEdges member without an edge hash
+	IssueUnindexedHash  = "unindexedHash"    // edge hash not indexed in lastEdges
+	IssueMalformedPath  = "malformedParPath" // parPath is not a JSON array
+	IssueInvalidDeleted = "invalidDeleted"   // deleted is missing or not a boolean
+	IssueInvalidProps   = "invalidProps"     // props is not a valid properties object
+)
+
+const integrityScanPageLen = 1000
+
+// IntegrityIssue is one inconsistency found in a seed database.
+type IntegrityIssue struct {
+	Kind     string `json:"kind"`
+	Key      string `json:"key,omitempty"`
+	Detail   string `json:"detail"`
+	Repaired bool   `json:"repaired"`
+}
+
+// IntegrityReport is the result of CheckIntegrity.
+type IntegrityReport struct {
+	Edges      int              `json:"edges"`
+	Indexed    int              `json:"indexed"`
+	Repaired   int              `json:"repaired"`
+	Unrepaired int              `json:"unrepaired"`
+	Issues     []IntegrityIssue `json:"issues"`
+}
+
+func (r *IntegrityReport) add(issue IntegrityIssue) {
+	if issue.Repaired {
+		r.Repaired++
+	} else {
+		r.Unrepaired++
+	}
+	r.Issues = append(r.Issues, issue)
+}
+
+// RepairFunc writes the fix of an edge found by CheckIntegrity: fields to
+// set in its hash, none when it only has to be indexed. It must re-score
+// the edge in lastEdges, so that replicating clients pick the fix up, and
+// update what is derived from it.
+type RepairFunc func(id string, fields map[string]any) error
+
+// CheckIntegrity runs SQLite's integrity_check and cross-checks the edge
+// hashes against the lastEdges index. With a repair func it fixes what has
+// an unambiguous fix, one repair call per edge:
+//   - index members without a hash become tombstones,
+//   - hashes missing from the index are indexed,
+//   - deleted values in a known non-canonical form are normalized, missing
+//     ones become false (what pullEdges already assumed),
+//   - tombstones with a malformed parPath get an empty one.
+//
+// Malformed paths of live edges, other deleted values and SQLite findings
+// are only reported.
+func CheckIntegrity(h *Handle, repair RepairFunc) (*IntegrityReport, error) {
+	report := &IntegrityReport{Issues: []IntegrityIssue{}}
+
+	if err := checkSQLite(h, report); err != nil {
+		return nil, err
+	}
+
+	indexed, err := h.ZSet().RangeWith("lastEdges").ByScore(math.Inf(-1), math.Inf(1)).Run()
+	if err != nil {
+		return nil, fmt.Errorf("read lastEdges: %w", err)
+	}
+	report.Indexed = len(indexed)
+	inIndex := make(map[string]bool, len(indexed))
+	for _, item := range indexed {
+		inIndex[item.Elem.String()] = true
+	}
+
+	hashes := make(map[string]bool)
+	sc := h.Key().Scanner("*", redka.TypeHash, integrityScanPageLen)
+	for sc.Scan() {
+		key := sc.Key().Key
+		if strings.HasPrefix(key, "_") {
+			continue
+		}
+		hashes[key] = true
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("scan edge hashes: %w", err)
+	}
+	report.Edges = len(hashes)
+
+	for _, item := range indexed {
+		id := item.Elem.String()
+		if hashes[id] {
+			continue
+		}
+		report.add(IntegrityIssue{
+			Kind: IssueMissingHash, Key: id, Detail: "indexed in lastEdges but has no edge hash", Repaired: repair != nil,
+		})
+		if repair != nil {
+			if err := repair(id, map[string]any{"deleted": true, "parPath": "[]"}); err != nil {
+				return nil, fmt.Errorf("repair %s: %w", id, err)
+			}
+		}
+	}
+
+	for id := range hashes {
+		fix := make(map[string]any)
+		unindexed := !inIndex[id]
+		if unindexed {
+			report.add(IntegrityIssue{
+				Kind: IssueUnindexedHash, Key: id, Detail: "edge hash is not indexed in lastEdges", Repaired: repair != nil,
+			})
+		}
+		if err := checkEdgeFields(h, id, repair != nil, fix, report); err != nil {
+			return nil, err
+		}
+		if repair != nil && (unindexed || len(fix) > 0) {
+			if err := repair(id, fix); err != nil {
+				return nil, fmt.Errorf("repair %s: %w", id, err)
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// checkSQLite adds the findings of PRAGMA integrity_check.
+func checkSQLite(h *Handle, report *IntegrityReport) error {
+	rows, err := h.RO.Query("pragma integrity_check")
+	if err != nil {
+		return fmt.Errorf("integrity_check: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var msg string
+		if err := rows.Scan(&msg); err != nil {
+			return fmt.Errorf("integrity_check: %w", err)
+		}
+		if msg != "ok" {
+			report.add(IntegrityIssue{Kind: IssueSQLite, Detail: msg})
+		}
+	}
+	return rows.Err()
+}
+
+// checkEdgeFields validates the deleted, parPath and props fields of one
+// edge hash. With repair set it adds the fixable fields to fix.
+func checkEdgeFields(h *Handle, id string, repair bool, fix map[string]any, report *IntegrityReport) error {
+	fields, err := h.Hash().Items(id)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", id, err)
+	}
+
+	deletedVal, hasDeleted := fields["deleted"]
+	deleted, deletedErr := parseDeleted(deletedVal.String())
+	if _, err := strconv.ParseBool(deletedVal.String()); !hasDeleted || err != nil {
+		issue := IntegrityIssue{Kind: IssueInvalidDeleted, Key: id}
+		switch {
+		case !hasDeleted:
+			issue.Detail = "deleted field is missing"
+		default:
+			issue.Detail = fmt.Sprintf("deleted is %q", deletedVal.String())
+		}
+		if repair && deletedErr == nil {
+			fix["deleted"] = deleted
+			issue.Repaired = true
+		}
+		report.add(issue)
+	}
+
+	var parPath []interface{}
+	if err := json.Unmarshal(fields["parPath"].Bytes(), &parPath); err != nil {
+		issue := IntegrityIssue{Kind: IssueMalformedPath, Key: id, Detail: err.Error()}
+		if repair && deletedErr == nil && deleted {
+			fix["parPath"] = "[]"
+			issue.Repaired = true
+		}
+		report.add(issue)
+	}
+
+	if val, ok := fields[PropsField]; ok {
+		var props map[string]interface{}
+		err := json.Unmarshal(val.Bytes(), &props)
+		if err == nil {
+			err = ValidateProps(props)
+		}
+		if err != nil {
+			report.add(IntegrityIssue{Kind: IssueInvalidProps, Key: id, Detail: err.Error()})
+		}
+	}
+	return nil
+}
+
+// parseDeleted reads the deleted spellings that have an unambiguous
+// meaning. An empty value is what pullEdges already reads as false.
+func parseDeleted(s string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "0", "false", "f", "no", "n", "off":
+		return false, nil
+	case "1", "true", "t", "yes", "y", "on":
+		return true, nil
+	}
+	return false, fmt.Errorf("not a boolean: %q", s)
+}
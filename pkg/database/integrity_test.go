@@ -0,0 +1,98 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// TestCheckIntegrity seeds every kind of inconsistency, checks they are
+// reported, and that a repair pass leaves only the unfixable ones.
+func TestCheckIntegrity(t *testing.T) {
+	h, err := Open(filepath.Join(t.TempDir(), "seed.db"), SQLiteOptions{})
+	if err != nil {
+		t.Fatalf("open: %s", err)
+	}
+	defer h.Close()
+
+	edges := map[string]map[string]any{
+		"ok":        {"deleted": false, "parPath": `["a",[1,2],"b"]`},
+		"unindexed": {"deleted": false, "parPath": `[]`},
+		"yes":       {"deleted": "yes", "parPath": `[]`},
+		"garbled":   {"deleted": "maybe", "parPath": `[]`},
+		"badPath":   {"deleted": false, "parPath": `[1,`},
+		"badTomb":   {"deleted": true, "parPath": `{}`},
+		"_metadata": {"some": "thing"},
+		"noDeleted": {"parPath": `[]`},
+		"badProps":  {"deleted": false, "parPath": `[]`, "props": `{"_x":1}`},
+	}
+	for id, fields := range edges {
+		if _, err := h.Hash().SetMany(id, fields); err != nil {
+			t.Fatalf("seed %s: %s", id, err)
+		}
+		if id != "unindexed" && id != "_metadata" {
+			if _, err := h.ZSet().Add("lastEdges", id, 1); err != nil {
+				t.Fatalf("seed %s: %s", id, err)
+			}
+		}
+	}
+	if _, err := h.ZSet().Add("lastEdges", "orphan", 1); err != nil {
+		t.Fatalf("seed orphan: %s", err)
+	}
+
+	report, err := CheckIntegrity(h, nil)
+	if err != nil {
+		t.Fatalf("check: %s", err)
+	}
+	expIssues := map[string]string{
+		"orphan":    IssueMissingHash,
+		"unindexed": IssueUnindexedHash,
+		"yes":       IssueInvalidDeleted,
+		"garbled":   IssueInvalidDeleted,
+		"noDeleted": IssueInvalidDeleted,
+		"badPath":   IssueMalformedPath,
+		"badTomb":   IssueMalformedPath,
+		"badProps":  IssueInvalidProps,
+	}
+	if len(report.Issues) != len(expIssues) || report.Repaired != 0 {
+		t.Fatalf("expected %d unrepaired issues, got %+v", len(expIssues), report.Issues)
+	}
+	for _, issue := range report.Issues {
+		if expIssues[issue.Key] != issue.Kind {
+			t.Errorf("unexpected issue %+v", issue)
+		}
+	}
+
+	// The plugin writes repairs like pushes; here they go straight to the
+	// hash and index.
+	repaired := map[string]map[string]any{}
+	report, err = CheckIntegrity(h, func(id string, fields map[string]any) error {
+		repaired[id] = fields
+		if len(fields) > 0 {
+			if _, err := h.Hash().SetMany(id, fields); err != nil {
+				return err
+			}
+		}
+		_, err := h.ZSet().Add("lastEdges", id, 2)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("repair: %s", err)
+	}
+	if report.Unrepaired != 3 {
+		t.Errorf("only garbled, badPath and badProps should stay unrepaired, got %+v", report.Issues)
+	}
+	if len(repaired) != 5 || repaired["yes"]["deleted"] != true || len(repaired["unindexed"]) != 0 {
+		t.Errorf("want one repair per fixable edge, got %v", repaired)
+	}
+
+	report, err = CheckIntegrity(h, nil)
+	if err != nil {
+		t.Fatalf("recheck: %s", err)
+	}
+	if len(report.Issues) != 3 {
+		t.Errorf("recheck should only find the unfixable issues, got %+v", report.Issues)
+	}
+	if deleted, _ := h.Hash().Get("orphan", "deleted"); deleted.String() != "1" {
+		t.Errorf("orphan should have become a tombstone, deleted=%q", deleted)
+	}
+}
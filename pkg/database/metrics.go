@@ -0,0 +1,80 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mapgl-app/pkg/geo"
+	"math"
+	"strconv"
+)
+
+// Edge hash fields the plugin derives from parPath on every write. Clients
+// read them but never write them.
+const (
+	// LengthField is the geodesic length of the edge in meters.
+	LengthField = "length"
+	// BBoxField is the bounding box of the edge as a JSON
+	// [minLon, minLat, maxLon, maxLat] array.
+	BBoxField = "bbox"
+)
+
+// EdgeMetrics returns the derived fields to store with an edge whose path
+// is parPath. Paths without coordinates, as sent for deleted edges, have a
+// zero length and no box, so that the previous box is kept.
+func EdgeMetrics(parPath []interface{}) map[string]any {
+	points := geo.PathPoints(parPath)
+	fields := map[string]any{LengthField: geo.Length(points)}
+	if b, ok := geo.Bounds(points); ok {
+		box, _ := json.Marshal([4]float64{b.MinLon, b.MinLat, b.MaxLon, b.MaxLat})
+		fields[BBoxField] = string(box)
+	}
+	return fields
+}
+
+// ParseEdgeMetrics reads the derived fields of an edge hash. ok is false
+// when the edge was written without them.
+func ParseEdgeMetrics(fields map[string]string) (length float64, bbox *geo.BBox, ok bool) {
+	length, err := strconv.ParseFloat(fields[LengthField], 64)
+	if err != nil {
+		return 0, nil, false
+	}
+	var box [4]float64
+	if s := fields[BBoxField]; s != "" && json.Unmarshal([]byte(s), &box) == nil {
+		bbox = &geo.BBox{MinLon: box[0], MinLat: box[1], MaxLon: box[2], MaxLat: box[3]}
+	}
+	return length, bbox, true
+}
+
+func init() {
+	// Version 3 stores the length and box of every edge in its hash.
+	registerMigration(Migration{
+		Version: 3,
+		Name:    "edge metrics",
+		Up:      backfillEdgeMetrics,
+	})
+}
+
+// backfillEdgeMetrics computes the derived fields of the edges written
+// before they existed.
+func backfillEdgeMetrics(h *Handle) error {
+	ctx := context.Background()
+	members, err := h.ZRangeByScore(ctx, EdgesIndex, math.Inf(-1), math.Inf(1))
+	if err != nil {
+		return err
+	}
+	for _, m := range members {
+		fields, err := h.HGetAll(ctx, m.Member)
+		if err != nil {
+			return err
+		}
+		var parPath []interface{}
+		if json.Unmarshal([]byte(fields["parPath"]), &parPath) != nil {
+			continue
+		}
+		if err := h.HSet(ctx, m.Member, EdgeMetrics(parPath)); err != nil {
+			return fmt.Errorf("metrics %s: %w", m.Member, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package database
+
+import (
+	"context"
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+// TestEdgeMetricsBackfill migrates a seed file written before edge metrics
+// and checks the fields computed for its edges.
+func TestEdgeMetricsBackfill(t *testing.T) {
+	ctx := context.Background()
+	filename := filepath.Join(t.TempDir(), "seed.db")
+	h, err := Open(filename, SQLiteOptions{})
+	if err != nil {
+		t.Fatalf("open: %s", err)
+	}
+	defer h.Close()
+
+	edges := map[string]string{
+		"equator": `["a",[0,0,0,"",""],[1,0,0,"",""],"b"]`,
+		"nopath":  `["c","d"]`,
+	}
+	for id, parPath := range edges {
+		if err := h.HSet(ctx, id, map[string]any{"deleted": false, "parPath": parPath}); err != nil {
+			t.Fatalf("seed %s: %s", id, err)
+		}
+		if err := h.ZAdd(ctx, EdgesIndex, id, 1); err != nil {
+			t.Fatalf("seed %s: %s", id, err)
+		}
+	}
+	if err := h.Str().Set(SchemaVersionKey, 2); err != nil {
+		t.Fatalf("seed version: %s", err)
+	}
+
+	if err := Migrate(h, filename); err != nil {
+		t.Fatalf("migrate: %s", err)
+	}
+
+	fields, err := h.HGetAll(ctx, "equator")
+	if err != nil {
+		t.Fatalf("read: %s", err)
+	}
+	length, bbox, ok := ParseEdgeMetrics(fields)
+	if !ok || math.Abs(length-111195) > 1 {
+		t.Errorf("equator length should be about 111195 m, got %v (%v)", length, ok)
+	}
+	if bbox == nil || bbox.MinLon != 0 || bbox.MaxLon != 1 || bbox.MinLat != 0 || bbox.MaxLat != 0 {
+		t.Errorf("equator bbox should be 0,0,1,0, got %+v", bbox)
+	}
+
+	fields, err = h.HGetAll(ctx, "nopath")
+	if err != nil {
+		t.Fatalf("read: %s", err)
+	}
+	if length, bbox, ok := ParseEdgeMetrics(fields); !ok || length != 0 || bbox != nil {
+		t.Errorf("nopath should have a zero length and no bbox, got %v %+v %v", length, bbox, ok)
+	}
+}
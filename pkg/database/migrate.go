@@ -0,0 +1,156 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/nalgeon/redka"
+)
+
+// SchemaVersionKey is the string key holding the layout version of a seed
+// database. Keys starting with an underscore are plugin metadata, never ids
+// or edges.
+const SchemaVersionKey = "_schemaVersion"
+
+// A Migration upgrades a seed database from Version-1 to Version.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(h *Handle) error
+}
+
+// migrations is the ordered list of layout changes, see registerMigration.
+var migrations []Migration
+
+// registerMigration adds a layout change. Features that need new keys or
+// tables register theirs from an init function next to the code using them;
+// versions must be unique and are applied in ascending order.
+func registerMigration(m Migration) {
+	for _, other := range migrations {
+		if other.Version == m.Version {
+			panic(fmt.Sprintf("duplicate seed db migration version %d (%s, %s)", m.Version, other.Name, m.Name))
+		}
+	}
+	migrations = append(migrations, m)
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+}
+
+func init() {
+	// Version 1 is the layout the plugin has always written: ids as string
+	// keys indexed by the lastIds zset, edges as hashes with deleted,
+	// parPath and isEph fields indexed by the lastEdges zset.
+	registerMigration(Migration{
+		Version: 1,
+		Name:    "baseline",
+		Up:      func(h *Handle) error { return nil },
+	})
+}
+
+// LatestSchemaVersion is the version Migrate brings seed databases to.
+func LatestSchemaVersion() int {
+	if len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].Version
+}
+
+// SchemaVersion returns the layout version of a seed database.
+// Files written before versions were tracked are version 0.
+func SchemaVersion(h *Handle) (int, error) {
+	val, err := h.Str().Get(SchemaVersionKey)
+	if errors.Is(err, redka.ErrNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(val.String())
+}
+
+// Migrate applies the pending migrations to the seed database at filename,
+// backing the file up before each step.
+func Migrate(h *Handle, filename string) error {
+	return migrate(h, filename, migrations)
+}
+
+func migrate(h *Handle, filename string, list []Migration) error {
+	version, err := SchemaVersion(h)
+	if err != nil {
+		return fmt.Errorf("read schema version: %w", err)
+	}
+	if len(list) > 0 && version > list[len(list)-1].Version {
+		return fmt.Errorf("seed db %s has schema version %d, newer than this plugin supports (%d)",
+			filename, version, list[len(list)-1].Version)
+	}
+
+	for _, m := range list {
+		if m.Version <= version {
+			continue
+		}
+
+		backup, err := backupBeforeMigration(h, filename, version)
+		if err != nil {
+			return fmt.Errorf("backup before migration %d (%s): %w", m.Version, m.Name, err)
+		}
+
+		log.DefaultLogger.Info("Migrating seed db", "filename", filename,
+			"from", version, "to", m.Version, "migration", m.Name, "backup", backup)
+		start := time.Now()
+
+		if err := m.Up(h); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		if err := h.Str().Set(SchemaVersionKey, m.Version); err != nil {
+			return fmt.Errorf("set schema version %d: %w", m.Version, err)
+		}
+
+		log.DefaultLogger.Info("Migrated seed db", "filename", filename,
+			"version", m.Version, "duration", time.Since(start).String())
+		version = m.Version
+	}
+	return nil
+}
+
+// backupBeforeMigration copies the database next to it as
+// <filename>.v<version>-<timestamp>.bak. Databases without any keys yet
+// are new files with nothing to lose and are not backed up.
+func backupBeforeMigration(h *Handle, filename string, version int) (string, error) {
+	n, err := h.Key().Len()
+	if err != nil {
+		return "", err
+	}
+	if n == 0 {
+		return "", nil
+	}
+	return h.backup(filename, fmt.Sprintf("v%d", version))
+}
+
+// Snapshot copies the database at filename next to it as
+// <filename>.snap-<timestamp>.bak, a backup that OpenBackup opens like those
+// taken before migrations.
+func (h *Handle) Snapshot(filename string) (string, error) {
+	return h.backup(filename, "snap")
+}
+
+// backup copies the database into <filename>.<tag>-<timestamp>.bak.
+func (h *Handle) backup(filename, tag string) (string, error) {
+	backup := fmt.Sprintf("%s.%s-%s.bak", filename, tag, time.Now().UTC().Format("20060102T150405.000"))
+	if _, err := h.RW.Exec("vacuum into ?", backup); err != nil {
+		return "", err
+	}
+	return backup, nil
+}
+
+// OpenBackup opens a backup left by backupBeforeMigration or Snapshot as it
+// was taken, read-only and without migrating it, e.g. to compare it with
+// the live database. Backups share the base layout of ids and edges with
+// every later version. The caller closes the handle.
+func OpenBackup(filename string) (*Handle, error) {
+	return OpenReadOnly(filename)
+}
@@ -0,0 +1,68 @@
+package database
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// TestMigrate runs an ordered list against a pre-versioning seed file and
+// checks versions, backups and that a failing step stops the run.
+func TestMigrate(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "seed.db")
+	h, err := Open(filename, SQLiteOptions{})
+	if err != nil {
+		t.Fatalf("open: %s", err)
+	}
+	defer h.Close()
+
+	// A file written before schema versions existed.
+	if _, err := h.Hash().SetMany("edge-1", map[string]any{"deleted": false, "parPath": "[]"}); err != nil {
+		t.Fatalf("seed: %s", err)
+	}
+
+	var applied []string
+	step := func(name string) func(*Handle) error {
+		return func(*Handle) error {
+			applied = append(applied, name)
+			return nil
+		}
+	}
+	list := []Migration{
+		{Version: 1, Name: "one", Up: step("one")},
+		{Version: 2, Name: "two", Up: step("two")},
+	}
+
+	if err := migrate(h, filename, list); err != nil {
+		t.Fatalf("migrate: %s", err)
+	}
+	if v, _ := SchemaVersion(h); v != 2 {
+		t.Errorf("schema version should be 2, got %d", v)
+	}
+	if len(applied) != 2 || applied[0] != "one" || applied[1] != "two" {
+		t.Errorf("migrations should run in order, got %v", applied)
+	}
+	backups, _ := filepath.Glob(filename + ".v*.bak")
+	if len(backups) != 2 {
+		t.Errorf("each step should leave a backup, got %v", backups)
+	}
+
+	// Re-running is a no-op, a failing step keeps the previous version.
+	boom := errors.New("boom")
+	list = append(list, Migration{Version: 3, Name: "three", Up: func(*Handle) error { return boom }})
+	if err := migrate(h, filename, list); !errors.Is(err, boom) {
+		t.Fatalf("migrate should fail with the step error, got %v", err)
+	}
+	if v, _ := SchemaVersion(h); v != 2 {
+		t.Errorf("failed migration should keep version 2, got %d", v)
+	}
+	if len(applied) != 2 {
+		t.Errorf("applied migrations should not run again, got %v", applied)
+	}
+
+	// A file from a newer plugin is refused.
+	if err := migrate(h, filename, list[:1]); err == nil {
+		t.Error("migrate should refuse a seed db newer than the known migrations")
+	}
+}
@@ -0,0 +1,131 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// PropsField is the edge hash field holding the edge's properties as a JSON
+// object, e.g. labels, capacity, owner, style or a vendor circuit id.
+const PropsField = "props"
+
+// Limits of ValidateProps.
+const (
+	maxProps         = 64
+	maxPropKeyLen    = 64
+	maxPropStringLen = 1024
+	maxPropsDepth    = 3
+	maxPropsSize     = 16 << 10
+)
+
+// ValidateProps checks edge properties: at most 64 keys of up to 64
+// characters, not starting with an underscore, whose values are strings,
+// finite numbers, booleans, null, or arrays and objects of those, nested at
+// most three levels deep counting props itself. The encoded object must fit
+// in 16 KiB.
+func ValidateProps(props map[string]interface{}) error {
+	if len(props) > maxProps {
+		return fmt.Errorf("props has %d keys, at most %d are allowed", len(props), maxProps)
+	}
+	if err := validatePropsObject(props, 1); err != nil {
+		return err
+	}
+	b, err := json.Marshal(props)
+	if err != nil {
+		return err
+	}
+	if len(b) > maxPropsSize {
+		return fmt.Errorf("props is %d bytes, at most %d are allowed", len(b), maxPropsSize)
+	}
+	return nil
+}
+
+func validatePropsObject(obj map[string]interface{}, depth int) error {
+	for _, key := range sortedKeys(obj) {
+		switch {
+		case key == "":
+			return errors.New("props keys must not be empty")
+		case len(key) > maxPropKeyLen:
+			return fmt.Errorf("props key %.16q... is longer than %d characters", key, maxPropKeyLen)
+		case depth == 1 && strings.HasPrefix(key, "_"):
+			return fmt.Errorf("props key %q: keys starting with an underscore are reserved", key)
+		}
+		if err := validatePropValue(obj[key], depth); err != nil {
+			return fmt.Errorf("props key %q: %w", key, err)
+		}
+	}
+	return nil
+}
+
+func validatePropValue(v interface{}, depth int) error {
+	switch v := v.(type) {
+	case nil, bool:
+		return nil
+	case string:
+		if len(v) > maxPropStringLen {
+			return fmt.Errorf("string is longer than %d bytes", maxPropStringLen)
+		}
+		return nil
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return errors.New("number is not finite")
+		}
+		return nil
+	case []interface{}:
+		if depth >= maxPropsDepth {
+			return fmt.Errorf("nested deeper than %d levels", maxPropsDepth)
+		}
+		for _, el := range v {
+			if err := validatePropValue(el, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	case map[string]interface{}:
+		if depth >= maxPropsDepth {
+			return fmt.Errorf("nested deeper than %d levels", maxPropsDepth)
+		}
+		return validatePropsObject(v, depth+1)
+	}
+	return fmt.Errorf("unsupported value of type %T", v)
+}
+
+// ParseProps reads the props field of an edge hash. Edges without props
+// have none; a malformed field is an error.
+func ParseProps(fields map[string]string) (map[string]interface{}, error) {
+	s := fields[PropsField]
+	if s == "" {
+		return nil, nil
+	}
+	var props map[string]interface{}
+	if err := json.Unmarshal([]byte(s), &props); err != nil {
+		return nil, err
+	}
+	return props, nil
+}
+
+// MatchProps reports whether props has every key of filter with an equal
+// value. Numbers compare as JSON numbers, so 10 matches 10.0.
+func MatchProps(props, filter map[string]interface{}) bool {
+	for key, want := range filter {
+		got, ok := props[key]
+		if !ok || !reflect.DeepEqual(got, want) {
+			return false
+		}
+	}
+	return true
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,61 @@
+package database
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestValidateProps(t *testing.T) {
+	for _, tc := range []struct {
+		props string
+		ok    bool
+	}{
+		{`{}`, true},
+		{`{"owner":"acme","capacity":96,"lit":true,"labels":["core","north"],"style":{"color":"#f00","dash":[2,4]}}`, true},
+		{`{"note":null}`, true},
+		{`{"":1}`, false},
+		{`{"_rev":1}`, false},
+		{`{"` + strings.Repeat("k", 65) + `":1}`, false},
+		{`{"name":"` + strings.Repeat("x", 1025) + `"}`, false},
+		{`{"a":{"b":{"c":1}}}`, true},
+		{`{"a":{"b":{"c":{"d":1}}}}`, false},
+		{`{"a":[[["deep"]]]}`, false},
+	} {
+		var props map[string]interface{}
+		if err := json.Unmarshal([]byte(tc.props), &props); err != nil {
+			t.Fatalf("%s: %s", tc.props, err)
+		}
+		if err := ValidateProps(props); (err == nil) != tc.ok {
+			t.Errorf("ValidateProps(%.40s) = %v, want ok %v", tc.props, err, tc.ok)
+		}
+	}
+
+	many := map[string]interface{}{}
+	for i := 0; i < 65; i++ {
+		many[strings.Repeat("k", i+1)] = i
+	}
+	if err := ValidateProps(many); err == nil {
+		t.Error("65 keys should be rejected")
+	}
+}
+
+func TestMatchProps(t *testing.T) {
+	var props map[string]interface{}
+	_ = json.Unmarshal([]byte(`{"owner":"acme","capacity":96,"labels":["core"]}`), &props)
+	for filter, want := range map[string]bool{
+		`{}`:                               true,
+		`{"owner":"acme"}`:                 true,
+		`{"owner":"acme","capacity":96.0}`: true,
+		`{"labels":["core"]}`:              true,
+		`{"owner":"other"}`:                false,
+		`{"vendor":"acme"}`:                false,
+		`{"owner":"acme","capacity":"96"}`: false,
+	} {
+		var f map[string]interface{}
+		_ = json.Unmarshal([]byte(filter), &f)
+		if got := MatchProps(props, f); got != want {
+			t.Errorf("MatchProps(%s) = %v, want %v", filter, got, want)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"mapgl-app/pkg/resp"
+	"math"
+	"strconv"
+	"time"
+)
+
+// RedisStore keeps one seed "file" in an external Redis server, so that
+// several Grafana replicas replicate through the same data. Every key of
+// the file is stored under prefix, e.g. "mapgl:<fileName>:".
+type RedisStore struct {
+	client *resp.Client
+	prefix string
+}
+
+var _ Store = (*RedisStore)(nil)
+
+// NewRedisStore creates a store for the keys under prefix. The client is
+// shared and owned by the caller.
+func NewRedisStore(client *resp.Client, prefix string) *RedisStore {
+	return &RedisStore{client: client, prefix: prefix}
+}
+
+// ZRangeByScore implements Store.
+func (s *RedisStore) ZRangeByScore(ctx context.Context, key string, min, max float64) ([]ScoredMember, error) {
+	v, err := s.client.Do(ctx, "ZRANGEBYSCORE", s.prefix+key, formatScore(min), formatScore(max), "WITHSCORES")
+	if err != nil {
+		return nil, err
+	}
+	arr, ok := v.([]interface{})
+	if !ok || len(arr)%2 != 0 {
+		return nil, fmt.Errorf("ZRANGEBYSCORE: unexpected reply %T", v)
+	}
+
+	members := make([]ScoredMember, 0, len(arr)/2)
+	for i := 0; i < len(arr); i += 2 {
+		member, _ := arr[i].([]byte)
+		scoreStr, _ := arr[i+1].([]byte)
+		score, err := strconv.ParseFloat(string(scoreStr), 64)
+		if err != nil {
+			return nil, fmt.Errorf("ZRANGEBYSCORE: score of %s: %w", member, err)
+		}
+		members = append(members, ScoredMember{Member: string(member), Score: score})
+	}
+	return members, nil
+}
+
+// ZAdd implements Store.
+func (s *RedisStore) ZAdd(ctx context.Context, key, member string, score float64) error {
+	_, err := s.client.Do(ctx, "ZADD", s.prefix+key, formatScore(score), member)
+	return err
+}
+
+// ZRem implements Store.
+func (s *RedisStore) ZRem(ctx context.Context, key, member string) error {
+	_, err := s.client.Do(ctx, "ZREM", s.prefix+key, member)
+	return err
+}
+
+// ZScore implements Store.
+func (s *RedisStore) ZScore(ctx context.Context, key, member string) (float64, error) {
+	v, err := s.client.Do(ctx, "ZSCORE", s.prefix+key, member)
+	if err != nil {
+		return 0, err
+	}
+	if v == nil {
+		return 0, ErrNotFound
+	}
+	b, ok := v.([]byte)
+	if !ok {
+		return 0, fmt.Errorf("ZSCORE: unexpected reply %T", v)
+	}
+	return strconv.ParseFloat(string(b), 64)
+}
+
+// Get implements Store.
+func (s *RedisStore) Get(ctx context.Context, key string) (string, error) {
+	v, err := s.client.Do(ctx, "GET", s.prefix+key)
+	if err != nil {
+		return "", err
+	}
+	if v == nil {
+		return "", ErrNotFound
+	}
+	b, ok := v.([]byte)
+	if !ok {
+		return "", fmt.Errorf("GET: unexpected reply %T", v)
+	}
+	return string(b), nil
+}
+
+// Set implements Store.
+func (s *RedisStore) Set(ctx context.Context, key, value string) error {
+	_, err := s.client.Do(ctx, "SET", s.prefix+key, value)
+	return err
+}
+
+// SetEx implements Store.
+func (s *RedisStore) SetEx(ctx context.Context, key, value string, ttl time.Duration) error {
+	_, err := s.client.Do(ctx, "SET", s.prefix+key, value, "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	return err
+}
+
+// HGetAll implements Store.
+func (s *RedisStore) HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	v, err := s.client.Do(ctx, "HGETALL", s.prefix+key)
+	if err != nil {
+		return nil, err
+	}
+	arr, ok := v.([]interface{})
+	if !ok || len(arr)%2 != 0 {
+		return nil, fmt.Errorf("HGETALL: unexpected reply %T", v)
+	}
+
+	fields := make(map[string]string, len(arr)/2)
+	for i := 0; i < len(arr); i += 2 {
+		field, _ := arr[i].([]byte)
+		val, _ := arr[i+1].([]byte)
+		fields[string(field)] = string(val)
+	}
+	return fields, nil
+}
+
+// HMGet implements Store.
+func (s *RedisStore) HMGet(ctx context.Context, key string, fields ...string) (map[string]string, error) {
+	if len(fields) == 0 {
+		return map[string]string{}, nil
+	}
+	args := make([]string, 0, 2+len(fields))
+	args = append(args, "HMGET", s.prefix+key)
+	args = append(args, fields...)
+	v, err := s.client.Do(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	arr, ok := v.([]interface{})
+	if !ok || len(arr) != len(fields) {
+		return nil, fmt.Errorf("HMGET: unexpected reply %T", v)
+	}
+
+	vals := make(map[string]string, len(fields))
+	for i, field := range fields {
+		if val, ok := arr[i].([]byte); ok {
+			vals[field] = string(val)
+		}
+	}
+	return vals, nil
+}
+
+// HSet implements Store.
+func (s *RedisStore) HSet(ctx context.Context, key string, fields map[string]any) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	args := make([]string, 0, 2+2*len(fields))
+	args = append(args, "HSET", s.prefix+key)
+	for field, val := range fields {
+		str, err := formatValue(val)
+		if err != nil {
+			return fmt.Errorf("HSET %s: %w", field, err)
+		}
+		args = append(args, field, str)
+	}
+	_, err := s.client.Do(ctx, args...)
+	return err
+}
+
+// HDel implements Store.
+func (s *RedisStore) HDel(ctx context.Context, key, field string) error {
+	_, err := s.client.Do(ctx, "HDEL", s.prefix+key, field)
+	return err
+}
+
+// HIncrBy implements Store.
+func (s *RedisStore) HIncrBy(ctx context.Context, key, field string, delta int64) (int64, error) {
+	v, err := s.client.Do(ctx, "HINCRBY", s.prefix+key, field, strconv.FormatInt(delta, 10))
+	if err != nil {
+		return 0, err
+	}
+	n, ok := v.(int64)
+	if !ok {
+		return 0, fmt.Errorf("HINCRBY: unexpected reply %T", v)
+	}
+	return n, nil
+}
+
+// formatScore spells infinite bounds the way Redis expects them.
+func formatScore(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// formatValue converts a hash value the same way redka does, so that both
+// backends hold the same strings.
+func formatValue(v any) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case []byte:
+		return string(v), nil
+	case bool:
+		if v {
+			return "1", nil
+		}
+		return "0", nil
+	case int:
+		return strconv.Itoa(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	}
+	return "", fmt.Errorf("unsupported value type %T", v)
+}
@@ -0,0 +1,231 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mapgl-app/pkg/geo"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Relational is implemented by stores that mirror ids and edges into plain
+// tables, so that SQL datasources can join map objects with other data.
+// Seed databases keep them next to redka's tables:
+//
+//	ids(ts_id, name, updated_at)
+//	edges(id, name, source, target, length, deleted, updated_at, geojson, props)
+//
+// source and target are the node names at the ends of parPath; updated_at
+// is the replication score in milliseconds; geojson is the geometry of the
+// path, null for edges without coordinates; props is the JSON object of the
+// edge's properties, null if it has none.
+type Relational interface {
+	// SyncId writes the row of an id.
+	SyncId(ctx context.Context, row IdRow) error
+	// SyncEdge writes the row of an edge.
+	SyncEdge(ctx context.Context, row EdgeRow) error
+}
+
+// IdRow is a row of the ids table.
+type IdRow struct {
+	TsId      string
+	Name      string
+	UpdatedAt float64
+}
+
+// EdgeRow is a row of the edges table.
+type EdgeRow struct {
+	ID        string
+	Name      string
+	Source    string
+	Target    string
+	Length    float64
+	Deleted   bool
+	UpdatedAt float64
+	GeoJSON   string
+	Props     string
+}
+
+var _ Relational = (*Handle)(nil)
+
+// EdgeName names an edge "source - target" after the nodes at the ends of
+// its parPath, or returns "" if neither end is named.
+func EdgeName(parPath []interface{}) string {
+	if len(parPath) == 0 {
+		return ""
+	}
+	target, _ := parPath[0].(string)
+	source, _ := parPath[len(parPath)-1].(string)
+	if source == "" && target == "" {
+		return ""
+	}
+	return source + " - " + target
+}
+
+// NewEdgeRow builds the row of an edge from its hash fields.
+func NewEdgeRow(id string, fields map[string]string, updatedAt float64) EdgeRow {
+	row := EdgeRow{ID: id, UpdatedAt: updatedAt}
+	row.Deleted, _ = strconv.ParseBool(fields["deleted"])
+
+	var parPath []interface{}
+	_ = json.Unmarshal([]byte(fields["parPath"]), &parPath)
+	row.Name = EdgeName(parPath)
+	if len(parPath) > 0 {
+		row.Target, _ = parPath[0].(string)
+		row.Source, _ = parPath[len(parPath)-1].(string)
+	}
+
+	points := geo.PathPoints(parPath)
+	if length, _, ok := ParseEdgeMetrics(fields); ok {
+		row.Length = length
+	} else {
+		row.Length = geo.Length(points)
+	}
+	if g := geo.Geometry(points); g != nil {
+		b, _ := json.Marshal(g)
+		row.GeoJSON = string(b)
+	}
+	if props, err := ParseProps(fields); err == nil && props != nil {
+		row.Props = fields[PropsField]
+	}
+	return row
+}
+
+func init() {
+	// Version 4 adds the relational tables and fills them from the
+	// existing ids and edges, without the columns of later versions.
+	registerMigration(Migration{
+		Version: 4,
+		Name:    "relational tables",
+		Up: func(h *Handle) error {
+			_, err := h.RW.Exec(`
+				create table if not exists ids (
+					ts_id      text primary key,
+					name       text,
+					updated_at integer
+				);
+				create table if not exists edges (
+					id         text primary key,
+					name       text,
+					source     text,
+					target     text,
+					length     real,
+					deleted    integer not null default 0,
+					updated_at integer,
+					geojson    text
+				);
+				create index if not exists edges_updated_at on edges (updated_at);
+				create index if not exists edges_source on edges (source);
+				create index if not exists edges_target on edges (target);`)
+			if err != nil {
+				return err
+			}
+			return backfillRelational(h, edgeColumns[:8])
+		},
+	})
+}
+
+func init() {
+	// Version 5 adds the props column and fills the tables from the
+	// existing ids and edges.
+	registerMigration(Migration{
+		Version: 5,
+		Name:    "edge props column",
+		Up: func(h *Handle) error {
+			if _, err := h.RW.Exec(`alter table edges add column props text`); err != nil {
+				return err
+			}
+			return backfillRelational(h, edgeColumns)
+		},
+	})
+}
+
+// edgeColumns are the columns of the edges table in the order migrations
+// added them. Backfills write those that existed at their version.
+var edgeColumns = []string{"id", "name", "source", "target", "length", "deleted", "updated_at", "geojson", "props"}
+
+// backfillRelational writes the rows of the ids and edges written before
+// the tables existed, with the given edge columns.
+func backfillRelational(h *Handle, columns []string) error {
+	ctx := context.Background()
+	ids, err := h.ZRangeByScore(ctx, IdsIndex, math.Inf(-1), math.Inf(1))
+	if err != nil {
+		return err
+	}
+	for _, m := range ids {
+		name, err := h.Get(ctx, m.Member)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := h.SyncId(ctx, IdRow{TsId: m.Member, Name: name, UpdatedAt: m.Score}); err != nil {
+			return fmt.Errorf("sync id %s: %w", m.Member, err)
+		}
+	}
+
+	edges, err := h.ZRangeByScore(ctx, EdgesIndex, math.Inf(-1), math.Inf(1))
+	if err != nil {
+		return err
+	}
+	for _, m := range edges {
+		fields, err := h.HGetAll(ctx, m.Member)
+		if err != nil {
+			return err
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		if err := h.upsertEdge(ctx, NewEdgeRow(m.Member, fields, m.Score), columns); err != nil {
+			return fmt.Errorf("sync edge %s: %w", m.Member, err)
+		}
+	}
+	return nil
+}
+
+// SyncId implements Relational.
+func (h *Handle) SyncId(ctx context.Context, row IdRow) error {
+	_, err := h.RW.ExecContext(ctx, `
+		insert into ids (ts_id, name, updated_at) values (?, ?, ?)
+		on conflict (ts_id) do update set name = excluded.name, updated_at = excluded.updated_at`,
+		row.TsId, row.Name, int64(row.UpdatedAt))
+	return err
+}
+
+// SyncEdge implements Relational.
+func (h *Handle) SyncEdge(ctx context.Context, row EdgeRow) error {
+	return h.upsertEdge(ctx, row, edgeColumns)
+}
+
+// upsertEdge writes columns of the row of an edge.
+func (h *Handle) upsertEdge(ctx context.Context, row EdgeRow, columns []string) error {
+	values := map[string]any{
+		"id":         row.ID,
+		"name":       row.Name,
+		"source":     row.Source,
+		"target":     row.Target,
+		"length":     row.Length,
+		"deleted":    row.Deleted,
+		"updated_at": int64(row.UpdatedAt),
+		"geojson":    sql.NullString{String: row.GeoJSON, Valid: row.GeoJSON != ""},
+		"props":      sql.NullString{String: row.Props, Valid: row.Props != ""},
+	}
+	args := make([]any, len(columns))
+	sets := make([]string, 0, len(columns)-1)
+	for i, column := range columns {
+		args[i] = values[column]
+		if column != "id" {
+			sets = append(sets, column+" = excluded."+column)
+		}
+	}
+	_, err := h.RW.ExecContext(ctx, fmt.Sprintf(
+		`insert into edges (%s) values (?%s) on conflict (id) do update set %s`,
+		strings.Join(columns, ", "), strings.Repeat(", ?", len(columns)-1), strings.Join(sets, ", ")),
+		args...)
+	return err
+}
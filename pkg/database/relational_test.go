@@ -0,0 +1,76 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+// TestRelationalTables fills the tables of a seed file written before they
+// existed through Migrate and checks that syncing a row replaces it.
+func TestRelationalTables(t *testing.T) {
+	ctx := context.Background()
+	filename := filepath.Join(t.TempDir(), "seed.db")
+	h, err := Open(filename, SQLiteOptions{})
+	if err != nil {
+		t.Fatalf("open: %s", err)
+	}
+	defer h.Close()
+
+	if err := h.Set(ctx, "ts1", "Paris"); err != nil {
+		t.Fatalf("seed: %s", err)
+	}
+	if err := h.ZAdd(ctx, IdsIndex, "ts1", 5); err != nil {
+		t.Fatalf("seed: %s", err)
+	}
+	if err := h.HSet(ctx, "e1", map[string]any{"deleted": false, "parPath": `["b",[2.35,48.85],[2.29,48.86],"a"]`}); err != nil {
+		t.Fatalf("seed: %s", err)
+	}
+	if err := h.ZAdd(ctx, EdgesIndex, "e1", 7); err != nil {
+		t.Fatalf("seed: %s", err)
+	}
+	if err := h.Str().Set(SchemaVersionKey, 3); err != nil {
+		t.Fatalf("seed version: %s", err)
+	}
+
+	if err := Migrate(h, filename); err != nil {
+		t.Fatalf("migrate: %s", err)
+	}
+
+	var name string
+	var updatedAt int64
+	if err := h.RO.QueryRow(`select name, updated_at from ids where ts_id = 'ts1'`).Scan(&name, &updatedAt); err != nil {
+		t.Fatalf("ids: %s", err)
+	}
+	if name != "Paris" || updatedAt != 5 {
+		t.Errorf("ids row should be Paris at 5, got %s at %d", name, updatedAt)
+	}
+
+	var source, target string
+	var length float64
+	var deleted bool
+	var geojson sql.NullString
+	row := h.RO.QueryRow(`select name, source, target, length, deleted, updated_at, geojson from edges where id = 'e1'`)
+	if err := row.Scan(&name, &source, &target, &length, &deleted, &updatedAt, &geojson); err != nil {
+		t.Fatalf("edges: %s", err)
+	}
+	if name != "a - b" || source != "a" || target != "b" || deleted || updatedAt != 7 || length < 4000 || length > 5000 {
+		t.Errorf("unexpected edges row: %s (%s, %s) %f %v %d", name, source, target, length, deleted, updatedAt)
+	}
+	if want := `{"coordinates":[[2.35,48.85],[2.29,48.86]],"type":"LineString"}`; geojson.String != want {
+		t.Errorf("geojson should be %s, got %v", want, geojson)
+	}
+
+	// A tombstone replaces the row and has no geometry.
+	if err := h.SyncEdge(ctx, NewEdgeRow("e1", map[string]string{"deleted": "1", "parPath": "[]"}, 9)); err != nil {
+		t.Fatalf("sync: %s", err)
+	}
+	row = h.RO.QueryRow(`select deleted, updated_at, geojson from edges where id = 'e1'`)
+	if err := row.Scan(&deleted, &updatedAt, &geojson); err != nil {
+		t.Fatalf("edges: %s", err)
+	}
+	if !deleted || updatedAt != 9 || geojson.Valid {
+		t.Errorf("tombstone row should be deleted at 9 without geojson, got %v %d %v", deleted, updatedAt, geojson)
+	}
+}
@@ -0,0 +1,280 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mapgl-app/pkg/geo"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Searcher is implemented by stores with a full-text index over id names,
+// edge ids and edge properties. Seed databases keep it in two FTS5 tables
+// that triggers fill from the relational tables: search_words for word
+// prefixes and search_trigrams for fuzzy matches.
+type Searcher interface {
+	// Search returns up to limit ids and live edges matching query, best
+	// first.
+	Search(ctx context.Context, query string, limit int) ([]SearchHit, error)
+}
+
+// SearchHit is one search result.
+type SearchHit struct {
+	Kind string `json:"kind"` // "id" or "edge"
+	Key  string `json:"key"`  // tsId or edge id
+	Name string `json:"name"`
+	// BBox is where to zoom to: the box of an edge, or of the edge ends
+	// at a node for an id. It is nil when there are no coordinates.
+	BBox *geo.BBox `json:"bbox"`
+	// Match is "prefix" when every query word starts a word of the
+	// result, "fuzzy" when the result only shares enough trigrams.
+	Match string `json:"match"`
+}
+
+var _ Searcher = (*Handle)(nil)
+
+// minTrigramShare is the share of the query's trigrams a fuzzy match must
+// contain.
+const minTrigramShare = 0.5
+
+func init() {
+	// Version 6 adds the search index. The indexed text of an id is its
+	// key and name, that of an edge its id, name and the scalar values of
+	// its props.
+	registerMigration(Migration{
+		Version: 6,
+		Name:    "search index",
+		Up: func(h *Handle) error {
+			stmts := []string{
+				`create virtual table if not exists search_words using fts5(
+					kind unindexed, key unindexed, text, prefix = '2 3', tokenize = 'unicode61 remove_diacritics 2')`,
+				`create virtual table if not exists search_trigrams using fts5(
+					kind unindexed, key unindexed, text, tokenize = 'trigram')`,
+			}
+			for _, table := range []string{"search_words", "search_trigrams"} {
+				stmts = append(stmts, searchTriggers(table)...)
+				stmts = append(stmts,
+					fmt.Sprintf(`insert into %s (kind, key, text) select 'id', ts_id, %s from ids`, table, idText("")),
+					fmt.Sprintf(`insert into %s (kind, key, text) select 'edge', id, %s from edges`, table, edgeText("")),
+				)
+			}
+			for _, stmt := range stmts {
+				if _, err := h.RW.Exec(stmt); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	})
+}
+
+// idText and edgeText are the SQL expressions of the indexed text of a row,
+// qualified by prefix ("new." in triggers).
+func idText(prefix string) string {
+	return fmt.Sprintf(`%[1]sts_id || ' ' || coalesce(%[1]sname, '')`, prefix)
+}
+
+func edgeText(prefix string) string {
+	return fmt.Sprintf(`%[1]sid || ' ' || coalesce(%[1]sname, '') || ' ' || coalesce(
+		(select group_concat(value, ' ') from json_tree(%[1]sprops) where atom is not null), '')`, prefix)
+}
+
+// searchTriggers keep a search table in sync with the ids and edges tables.
+func searchTriggers(table string) []string {
+	var stmts []string
+	for _, src := range []struct{ name, kind, key, text string }{
+		{"ids", "id", "ts_id", idText("new.")},
+		{"edges", "edge", "id", edgeText("new.")},
+	} {
+		trigger := table + "_" + src.name
+		stmts = append(stmts,
+			fmt.Sprintf(`create trigger if not exists %s_insert after insert on %s begin
+				insert into %s (kind, key, text) values ('%s', new.%s, %s);
+			end`, trigger, src.name, table, src.kind, src.key, src.text),
+			fmt.Sprintf(`create trigger if not exists %s_update after update on %s begin
+				delete from %s where kind = '%s' and key = old.%s;
+				insert into %s (kind, key, text) values ('%s', new.%s, %s);
+			end`, trigger, src.name, table, src.kind, src.key, table, src.kind, src.key, src.text),
+			fmt.Sprintf(`create trigger if not exists %s_delete after delete on %s begin
+				delete from %s where kind = '%s' and key = old.%s;
+			end`, trigger, src.name, table, src.kind, src.key),
+		)
+	}
+	return stmts
+}
+
+// Search implements Searcher. Prefix matches come first; fuzzy matches fill
+// the remaining places for queries of at least three characters.
+func (h *Handle) Search(ctx context.Context, query string, limit int) ([]SearchHit, error) {
+	words := strings.Fields(query)
+	if len(words) == 0 || limit <= 0 {
+		return nil, nil
+	}
+
+	var hits []SearchHit
+	seen := map[string]bool{}
+	collect := func(table, match string, keep func(text string) bool) error {
+		rows, err := h.RO.QueryContext(ctx, fmt.Sprintf(`
+			select s.kind, s.key, s.text, coalesce(i.name, e.name, '')
+			from %[1]s s
+			left join ids i on s.kind = 'id' and i.ts_id = s.key
+			left join edges e on s.kind = 'edge' and e.id = s.key
+			where %[1]s match ? and (s.kind = 'id' or e.deleted = 0)
+			order by rank
+			limit ?`, table), ftsQuery(words, match == "fuzzy"), limit*4)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() && len(hits) < limit {
+			var hit SearchHit
+			var text string
+			if err := rows.Scan(&hit.Kind, &hit.Key, &text, &hit.Name); err != nil {
+				return err
+			}
+			if seen[hit.Kind+"\x00"+hit.Key] || (keep != nil && !keep(text)) {
+				continue
+			}
+			seen[hit.Kind+"\x00"+hit.Key] = true
+			hit.Match = match
+			hits = append(hits, hit)
+		}
+		return rows.Err()
+	}
+
+	if err := collect("search_words", "prefix", nil); err != nil {
+		return nil, err
+	}
+	if len(hits) < limit && utf8.RuneCountInString(strings.Join(words, " ")) >= 3 {
+		grams := trigrams(strings.Join(words, " "))
+		err := collect("search_trigrams", "fuzzy", func(text string) bool {
+			return trigramShare(grams, text) >= minTrigramShare
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := h.searchBoxes(ctx, hits); err != nil {
+		return nil, err
+	}
+	return hits, nil
+}
+
+// ftsQuery builds an FTS5 query from the query words: all words as
+// prefixes, or any trigram of the query for fuzzy matching.
+func ftsQuery(words []string, fuzzy bool) string {
+	quote := func(s string) string { return `"` + strings.ReplaceAll(s, `"`, `""`) + `"` }
+	var terms []string
+	if fuzzy {
+		for _, g := range sortedGrams(trigrams(strings.Join(words, " "))) {
+			terms = append(terms, quote(g))
+		}
+		return strings.Join(terms, " OR ")
+	}
+	for _, w := range words {
+		terms = append(terms, quote(w)+"*")
+	}
+	return strings.Join(terms, " AND ")
+}
+
+// trigrams returns the lower-cased three-character substrings of s.
+func trigrams(s string) map[string]bool {
+	r := []rune(strings.ToLower(s))
+	grams := map[string]bool{}
+	for i := 0; i+3 <= len(r); i++ {
+		grams[string(r[i:i+3])] = true
+	}
+	return grams
+}
+
+func sortedGrams(grams map[string]bool) []string {
+	list := make([]string, 0, len(grams))
+	for g := range grams {
+		list = append(list, g)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// trigramShare is the share of grams found in text.
+func trigramShare(grams map[string]bool, text string) float64 {
+	if len(grams) == 0 {
+		return 0
+	}
+	have := trigrams(text)
+	n := 0
+	for g := range grams {
+		if have[g] {
+			n++
+		}
+	}
+	return float64(n) / float64(len(grams))
+}
+
+// searchBoxes sets the boxes of search hits: the geometry of an edge, or the
+// end coordinates of the live edges at a node for an id.
+func (h *Handle) searchBoxes(ctx context.Context, hits []SearchHit) error {
+	for i := range hits {
+		hit := &hits[i]
+		rows, err := h.RO.QueryContext(ctx, `
+			select geojson, source = ?1, target = ?1 from edges
+			where geojson is not null and (
+				(?2 = 'edge' and id = ?1) or
+				(?2 = 'id' and deleted = 0 and (source = ?1 or target = ?1)))`,
+			hit.Key, hit.Kind)
+		if err != nil {
+			return err
+		}
+		var points []geo.Point
+		for rows.Next() {
+			var geojson string
+			var isSource, isTarget bool
+			if err := rows.Scan(&geojson, &isSource, &isTarget); err != nil {
+				rows.Close()
+				return err
+			}
+			var g struct {
+				Type        string          `json:"type"`
+				Coordinates json.RawMessage `json:"coordinates"`
+			}
+			if json.Unmarshal([]byte(geojson), &g) != nil {
+				continue
+			}
+			var coords [][2]float64
+			if g.Type == "Point" {
+				var c [2]float64
+				if json.Unmarshal(g.Coordinates, &c) == nil {
+					coords = [][2]float64{c}
+				}
+			} else if json.Unmarshal(g.Coordinates, &coords) != nil {
+				continue
+			}
+			if len(coords) == 0 {
+				continue
+			}
+			// parPath runs from the target to the source.
+			switch {
+			case hit.Kind == "edge":
+				for _, c := range coords {
+					points = append(points, geo.Point{Lon: c[0], Lat: c[1]})
+				}
+			case isTarget:
+				points = append(points, geo.Point{Lon: coords[0][0], Lat: coords[0][1]})
+			case isSource:
+				last := coords[len(coords)-1]
+				points = append(points, geo.Point{Lon: last[0], Lat: last[1]})
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if b, ok := geo.Bounds(points); ok {
+			hit.BBox = &b
+		}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package database
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+// TestSearch indexes a few rows through the relational tables and checks
+// prefix, fuzzy and property matches and the boxes of the hits.
+func TestSearch(t *testing.T) {
+	ctx := context.Background()
+	filename := filepath.Join(t.TempDir(), "seed.db")
+	h, err := Open(filename, SQLiteOptions{})
+	if err != nil {
+		t.Fatalf("open: %s", err)
+	}
+	defer h.Close()
+	if err := Migrate(h, filename); err != nil {
+		t.Fatalf("migrate: %s", err)
+	}
+
+	for _, row := range []IdRow{{TsId: "ex1", Name: "Exchange Montparnasse"}, {TsId: "ex2", Name: "Exchange Bastille"}} {
+		if err := h.SyncId(ctx, row); err != nil {
+			t.Fatalf("sync: %s", err)
+		}
+	}
+	edges := map[string]map[string]string{
+		"c1":   {"deleted": "0", "parPath": `["ex2",[2.37,48.85],[2.32,48.84],"ex1"]`, "props": `{"circuit":"VC-40172","owner":"acme"}`},
+		"c2":   {"deleted": "0", "parPath": `["x",[0,0],[1,1],"y"]`, "props": `{"owner":"other"}`},
+		"gone": {"deleted": "1", "parPath": `["x",[0,0],[1,1],"y"]`, "props": `{"circuit":"VC-40173"}`},
+	}
+	for id, fields := range edges {
+		if err := h.SyncEdge(ctx, NewEdgeRow(id, fields, 1)); err != nil {
+			t.Fatalf("sync: %s", err)
+		}
+	}
+
+	hits, err := h.Search(ctx, "exch montp", 10)
+	if err != nil {
+		t.Fatalf("search: %s", err)
+	}
+	if len(hits) != 1 || hits[0].Key != "ex1" || hits[0].Match != "prefix" {
+		t.Fatalf("prefix search should find ex1, got %+v", hits)
+	}
+	// ex1 is the source of c1, the last coordinate of its path.
+	if b := hits[0].BBox; b == nil || b.MinLon != 2.32 || b.MaxLat != 48.84 {
+		t.Errorf("ex1 should be at 2.32,48.84, got %+v", b)
+	}
+
+	hits, err = h.Search(ctx, "vc-4017", 10)
+	if err != nil {
+		t.Fatalf("search: %s", err)
+	}
+	if len(hits) != 1 || hits[0].Key != "c1" || hits[0].Kind != "edge" {
+		t.Fatalf("props search should find c1 but not the deleted edge, got %+v", hits)
+	}
+	if b := hits[0].BBox; b == nil || b.MinLon != 2.32 || b.MaxLon != 2.37 {
+		t.Errorf("c1 box should span its path, got %+v", b)
+	}
+
+	hits, err = h.Search(ctx, "Bastile", 10)
+	if err != nil {
+		t.Fatalf("search: %s", err)
+	}
+	if len(hits) != 1 || hits[0].Key != "ex2" || hits[0].Match != "fuzzy" {
+		t.Errorf("misspelled search should find ex2 fuzzily, got %+v", hits)
+	}
+
+	// Renaming an id re-indexes it.
+	if err := h.SyncId(ctx, IdRow{TsId: "ex2", Name: "Central Office"}); err != nil {
+		t.Fatalf("sync: %s", err)
+	}
+	if hits, err = h.Search(ctx, "bastille", 10); err != nil || len(hits) != 0 {
+		t.Errorf("old name should not match anymore, got %+v, %v", hits, err)
+	}
+}
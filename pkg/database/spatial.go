@@ -0,0 +1,119 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mapgl-app/pkg/geo"
+	"math"
+)
+
+// SpatialIndex is implemented by stores that index the bounding boxes of
+// edges. Seed databases keep them in an SQLite R-tree next to redka's
+// tables; stores without it are filtered by parPath in memory.
+type SpatialIndex interface {
+	// IndexEdge sets the bounding box of an edge.
+	IndexEdge(ctx context.Context, id string, bbox geo.BBox) error
+	// EdgesInBBox returns the ids of the edges whose box intersects bbox.
+	EdgesInBBox(ctx context.Context, bbox geo.BBox) (map[string]bool, error)
+}
+
+var _ SpatialIndex = (*Handle)(nil)
+
+func init() {
+	// The R-tree holds one box per edge id; edge_rtree_ids maps its integer
+	// ids to edge keys. Boxes are kept when an edge is deleted, so that
+	// clients pulling an area still receive the tombstone.
+	registerMigration(Migration{
+		Version: 2,
+		Name:    "edge spatial index",
+		Up: func(h *Handle) error {
+			_, err := h.RW.Exec(`
+				create table if not exists edge_rtree_ids (
+					rid     integer primary key,
+					edge_id text not null unique
+				);
+				create virtual table if not exists edge_rtree using rtree(
+					rid, min_lon, max_lon, min_lat, max_lat
+				);`)
+			if err != nil {
+				return err
+			}
+			return backfillSpatialIndex(h)
+		},
+	})
+}
+
+// backfillSpatialIndex indexes the edges written before the index existed.
+func backfillSpatialIndex(h *Handle) error {
+	ctx := context.Background()
+	members, err := h.ZRangeByScore(ctx, EdgesIndex, math.Inf(-1), math.Inf(1))
+	if err != nil {
+		return err
+	}
+	for _, m := range members {
+		fields, err := h.HGetAll(ctx, m.Member)
+		if err != nil {
+			return err
+		}
+		var parPath []interface{}
+		if json.Unmarshal([]byte(fields["parPath"]), &parPath) != nil {
+			continue
+		}
+		if bbox, ok := geo.Bounds(geo.PathPoints(parPath)); ok {
+			if err := h.IndexEdge(ctx, m.Member, bbox); err != nil {
+				return fmt.Errorf("index %s: %w", m.Member, err)
+			}
+		}
+	}
+	return nil
+}
+
+// IndexEdge implements SpatialIndex. R-tree coordinates are 32-bit floats
+// rounded outwards, so lookups may return edges just outside the box.
+func (h *Handle) IndexEdge(ctx context.Context, id string, bbox geo.BBox) error {
+	tx, err := h.RW.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var rid int64
+	err = tx.QueryRowContext(ctx, `
+		insert into edge_rtree_ids (edge_id) values (?)
+		on conflict (edge_id) do update set edge_id = excluded.edge_id
+		returning rid`, id).Scan(&rid)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		`insert or replace into edge_rtree (rid, min_lon, max_lon, min_lat, max_lat) values (?, ?, ?, ?, ?)`,
+		rid, bbox.MinLon, bbox.MaxLon, bbox.MinLat, bbox.MaxLat)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// EdgesInBBox implements SpatialIndex.
+func (h *Handle) EdgesInBBox(ctx context.Context, bbox geo.BBox) (map[string]bool, error) {
+	rows, err := h.RO.QueryContext(ctx, `
+		select k.edge_id
+		from edge_rtree r join edge_rtree_ids k on k.rid = r.rid
+		where r.min_lon <= ? and r.max_lon >= ? and r.min_lat <= ? and r.max_lat >= ?`,
+		bbox.MaxLon, bbox.MinLon, bbox.MaxLat, bbox.MinLat)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make(map[string]bool)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids[id] = true
+	}
+	return ids, rows.Err()
+}
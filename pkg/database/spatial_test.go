@@ -0,0 +1,66 @@
+package database
+
+import (
+	"context"
+	"mapgl-app/pkg/geo"
+	"path/filepath"
+	"testing"
+)
+
+// TestSpatialIndex backfills the index of a pre-index seed file through
+// Migrate and checks box lookups and updates.
+func TestSpatialIndex(t *testing.T) {
+	ctx := context.Background()
+	filename := filepath.Join(t.TempDir(), "seed.db")
+	h, err := Open(filename, SQLiteOptions{})
+	if err != nil {
+		t.Fatalf("open: %s", err)
+	}
+	defer h.Close()
+
+	edges := map[string]string{
+		"paris":  `["a",[2.35,48.85,0,"",""],[2.29,48.86,0,"",""],"b"]`,
+		"berlin": `["c",[13.40,52.52],[13.37,52.51],"d"]`,
+		"nopath": `["e","f"]`,
+	}
+	for id, parPath := range edges {
+		if err := h.HSet(ctx, id, map[string]any{"deleted": false, "parPath": parPath}); err != nil {
+			t.Fatalf("seed %s: %s", id, err)
+		}
+		if err := h.ZAdd(ctx, EdgesIndex, id, 1); err != nil {
+			t.Fatalf("seed %s: %s", id, err)
+		}
+	}
+	if err := h.Str().Set(SchemaVersionKey, 1); err != nil {
+		t.Fatalf("seed version: %s", err)
+	}
+
+	if err := Migrate(h, filename); err != nil {
+		t.Fatalf("migrate: %s", err)
+	}
+
+	france := geo.BBox{MinLon: -5, MinLat: 42, MaxLon: 8, MaxLat: 51}
+	got, err := h.EdgesInBBox(ctx, france)
+	if err != nil {
+		t.Fatalf("query: %s", err)
+	}
+	if len(got) != 1 || !got["paris"] {
+		t.Errorf("backfilled index should find paris only, got %v", got)
+	}
+
+	// Moving berlin into the box replaces its entry.
+	if err := h.IndexEdge(ctx, "berlin", geo.BBox{MinLon: 5, MinLat: 45, MaxLon: 6, MaxLat: 46}); err != nil {
+		t.Fatalf("index: %s", err)
+	}
+	got, err = h.EdgesInBBox(ctx, france)
+	if err != nil {
+		t.Fatalf("query: %s", err)
+	}
+	if len(got) != 2 || !got["berlin"] {
+		t.Errorf("berlin should have moved into the box, got %v", got)
+	}
+	got, _ = h.EdgesInBBox(ctx, geo.BBox{MinLon: 13, MinLat: 52, MaxLon: 14, MaxLat: 53})
+	if len(got) != 0 {
+		t.Errorf("berlin's old box should be gone, got %v", got)
+	}
+}
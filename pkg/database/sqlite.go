@@ -0,0 +1,143 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/nalgeon/redka"
+)
+
+// SQLiteOptions tunes how a seed database is opened.
+type SQLiteOptions struct {
+	// JournalMode is the SQLite journal_mode, e.g. "wal" or "delete".
+	JournalMode string
+	// BusyTimeout is how long a connection waits on a locked database
+	// before failing with "database is locked".
+	BusyTimeout time.Duration
+	// Synchronous is the SQLite synchronous level, e.g. "normal" or "full".
+	Synchronous string
+	// CacheSize is the SQLite cache_size: pages if positive, KiB if
+	// negative. Zero keeps the SQLite default.
+	CacheSize int
+	// Readers is the size of the read-only connection pool.
+	// Zero keeps the redka default (2-8 depending on the CPUs).
+	Readers int
+	// Writers is the size of the read-write connection pool.
+	// Zero means 1, since SQLite allows only one writer at a time.
+	Writers int
+}
+
+// pragma returns the per-connection settings for a seed database.
+// foreign_keys must stay on: redka relies on cascading deletes.
+func (o SQLiteOptions) pragma() map[string]string {
+	pragma := map[string]string{
+		"foreign_keys": "on",
+		"temp_store":   "memory",
+		"mmap_size":    "268435456",
+	}
+	if o.JournalMode != "" {
+		pragma["journal_mode"] = o.JournalMode
+	}
+	if o.BusyTimeout > 0 {
+		pragma["busy_timeout"] = strconv.FormatInt(o.BusyTimeout.Milliseconds(), 10)
+	}
+	if o.Synchronous != "" {
+		pragma["synchronous"] = o.Synchronous
+	}
+	if o.CacheSize != 0 {
+		pragma["cache_size"] = strconv.Itoa(o.CacheSize)
+	}
+	return pragma
+}
+
+// dataSource returns the modernc connection string for a seed database.
+// The pragmas go into the DSN so that every pooled connection gets them,
+// not only the one redka happens to run them on.
+func (o SQLiteOptions) dataSource(filename string, readOnly bool) string {
+	params := url.Values{}
+	for name, val := range o.pragma() {
+		params.Add("_pragma", name+"="+val)
+	}
+	if readOnly {
+		params.Set("mode", "ro")
+	} else {
+		params.Set("_txlock", "immediate")
+	}
+	return "file:" + filename + "?" + params.Encode()
+}
+
+// Handle is an open seed database: the redka repository used by the
+// handlers plus the SQLite pools it runs on, for queries redka can't do.
+type Handle struct {
+	*redka.DB
+	RW *sql.DB // read-write pool
+	RO *sql.DB // read-only pool
+}
+
+// Open opens or creates the seed database at filename with the given
+// SQLite settings and connection pool sizes.
+func Open(filename string, opts SQLiteOptions) (*Handle, error) {
+	rw, err := sql.Open("sqlite", opts.dataSource(filename, false))
+	if err != nil {
+		return nil, err
+	}
+	ro, err := sql.Open("sqlite", opts.dataSource(filename, true))
+	if err != nil {
+		rw.Close()
+		return nil, err
+	}
+
+	db, err := redka.OpenDB(rw, ro, &redka.Options{
+		DriverName: "sqlite",
+		Pragma:     opts.pragma(),
+	})
+	if err != nil {
+		rw.Close()
+		ro.Close()
+		return nil, fmt.Errorf("open %s: %w", filename, err)
+	}
+
+	// redka sized the pools itself; apply the configured sizes on top.
+	writers := opts.Writers
+	if writers <= 0 {
+		writers = 1
+	}
+	rw.SetMaxOpenConns(writers)
+	rw.SetMaxIdleConns(writers)
+	if opts.Readers > 0 {
+		ro.SetMaxOpenConns(opts.Readers)
+		ro.SetMaxIdleConns(opts.Readers)
+	}
+
+	return &Handle{DB: db, RW: rw, RO: ro}, nil
+}
+
+// OpenReadOnly opens the existing seed database at filename for reading
+// only: it neither creates the file nor writes redka's schema, so it doesn't
+// migrate or back it up either. The handle has no RW pool; the caller
+// closes it.
+func OpenReadOnly(filename string) (*Handle, error) {
+	if _, err := os.Stat(filename); err != nil {
+		return nil, err
+	}
+	ro, err := sql.Open("sqlite", SQLiteOptions{}.dataSource(filename, true))
+	if err != nil {
+		return nil, err
+	}
+	// Without pragmas of our own, redka would set journal_mode, which a
+	// read-only connection can't do on files that aren't in WAL mode yet,
+	// such as backups.
+	db, err := redka.OpenReadDB(ro, &redka.Options{
+		DriverName: "sqlite",
+		Pragma:     SQLiteOptions{}.pragma(),
+	})
+	if err != nil {
+		ro.Close()
+		return nil, fmt.Errorf("open %s: %w", filename, err)
+	}
+	return &Handle{DB: db, RO: ro}, nil
+}
@@ -0,0 +1,175 @@
+package database
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nalgeon/redka"
+)
+
+var benchOptions = SQLiteOptions{
+	JournalMode: "wal",
+	BusyTimeout: 5 * time.Second,
+	Synchronous: "normal",
+	CacheSize:   -16000,
+	Readers:     8,
+	Writers:     1,
+}
+
+// TestOpenPragma checks that the settings reach both connection pools.
+func TestOpenPragma(t *testing.T) {
+	h, err := Open(filepath.Join(t.TempDir(), "pragma.db"), benchOptions)
+	if err != nil {
+		t.Fatalf("open: %s", err)
+	}
+	defer h.Close()
+
+	for name, want := range map[string]string{
+		"journal_mode": "wal",
+		"busy_timeout": "5000",
+		"synchronous":  "1",
+		"cache_size":   "-16000",
+		"foreign_keys": "1",
+	} {
+		var rw, ro string
+		if err := h.RW.QueryRow("pragma " + name).Scan(&rw); err != nil {
+			t.Fatalf("rw pragma %s: %s", name, err)
+		}
+		if err := h.RO.QueryRow("pragma " + name).Scan(&ro); err != nil {
+			t.Fatalf("ro pragma %s: %s", name, err)
+		}
+		if rw != want || ro != want {
+			t.Errorf("pragma %s should be %s, got rw=%s ro=%s", name, want, rw, ro)
+		}
+	}
+	if n := h.RO.Stats().MaxOpenConnections; n != benchOptions.Readers {
+		t.Errorf("readers pool should allow %d connections, got %d", benchOptions.Readers, n)
+	}
+}
+
+// TestOpenReadOnly checks that a read-only handle reads a seed file without
+// writing to it, and doesn't create missing ones.
+func TestOpenReadOnly(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "ro.db")
+	if _, err := OpenReadOnly(filename); err == nil {
+		t.Fatal("opening a missing file should fail")
+	}
+
+	h, err := Open(filename, benchOptions)
+	if err != nil {
+		t.Fatalf("open: %s", err)
+	}
+	defer h.Close()
+	if err := h.Str().Set("k", "v"); err != nil {
+		t.Fatalf("set: %s", err)
+	}
+
+	ro, err := OpenReadOnly(filename)
+	if err != nil {
+		t.Fatalf("open read-only: %s", err)
+	}
+	defer ro.Close()
+	if val, err := ro.Str().Get("k"); err != nil || val.String() != "v" {
+		t.Errorf("want v, got %v, %v", val, err)
+	}
+	if err := ro.Str().Set("k", "w"); err == nil {
+		t.Error("writes through a read-only handle should fail")
+	}
+}
+
+// BenchmarkConcurrentPushPull mixes pushEdges-style writes with
+// pullEdges-style reads from parallel clients, once with the redka defaults
+// GetDB used to open seed files with and once with the tuned settings.
+// The two-handles cases open the file twice, as an old and a new app
+// instance do while Grafana swaps them, which is where "database is
+// locked" shows up in errors/op.
+//
+//	go test ./pkg/database -run ^$ -bench ConcurrentPushPull -cpu 8
+func BenchmarkConcurrentPushPull(b *testing.B) {
+	openDefaults := func(b *testing.B, filename string) *redka.DB {
+		db, err := redka.Open(filename, &redka.Options{DriverName: "sqlite"})
+		if err != nil {
+			b.Fatalf("open: %s", err)
+		}
+		b.Cleanup(func() { db.Close() })
+		return db
+	}
+	openTuned := func(b *testing.B, filename string) *redka.DB {
+		h, err := Open(filename, benchOptions)
+		if err != nil {
+			b.Fatalf("open: %s", err)
+		}
+		b.Cleanup(func() { h.Close() })
+		return h.DB
+	}
+
+	for _, bc := range []struct {
+		name    string
+		open    func(*testing.B, string) *redka.DB
+		handles int
+	}{
+		{"redka-defaults", openDefaults, 1},
+		{"tuned", openTuned, 1},
+		{"redka-defaults-two-handles", openDefaults, 2},
+		{"tuned-two-handles", openTuned, 2},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			filename := filepath.Join(b.TempDir(), "bench.db")
+			dbs := make([]*redka.DB, bc.handles)
+			for i := range dbs {
+				dbs[i] = bc.open(b, filename)
+			}
+			benchPushPull(b, dbs)
+		})
+	}
+}
+
+func benchPushPull(b *testing.B, dbs []*redka.DB) {
+	db := dbs[0]
+	const edges = 200
+	parPath := `["a",[37.61,55.75],[37.62,55.76],"b"]`
+	for i := 0; i < edges; i++ {
+		id := fmt.Sprintf("edge-%d", i)
+		if _, err := db.Hash().SetMany(id, map[string]any{"deleted": false, "parPath": parPath}); err != nil {
+			b.Fatalf("seed: %s", err)
+		}
+		if _, err := db.ZSet().Add("lastEdges", id, float64(i)); err != nil {
+			b.Fatalf("seed: %s", err)
+		}
+	}
+
+	var seq, failed atomic.Int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			n := seq.Add(1)
+			db := dbs[int(n/2)%len(dbs)]
+			if n%2 == 0 {
+				id := fmt.Sprintf("edge-%d", n%edges)
+				_, err := db.Hash().SetMany(id, map[string]any{"deleted": false, "parPath": parPath})
+				if err == nil {
+					_, err = db.ZSet().Add("lastEdges", id, float64(edges+n))
+				}
+				if err != nil {
+					failed.Add(1)
+				}
+				continue
+			}
+
+			items, err := db.ZSet().RangeWith("lastEdges").ByRank(0, 19).Desc().Run()
+			if err != nil {
+				failed.Add(1)
+				continue
+			}
+			for _, item := range items {
+				if _, err := db.Hash().Items(item.Elem.String()); err != nil {
+					failed.Add(1)
+				}
+			}
+		}
+	})
+	b.ReportMetric(float64(failed.Load())/float64(b.N), "errors/op")
+}
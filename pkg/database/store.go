@@ -0,0 +1,173 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/nalgeon/redka"
+)
+
+// Replication indexes: sorted sets of ids and edges scored by their
+// client-side updatedAt, which the pull routes range over.
+const (
+	IdsIndex   = "lastIds"
+	EdgesIndex = "lastEdges"
+)
+
+// ExpiryIndex is the sorted set of ephemeral edges scored by the time in
+// milliseconds they expire at, which is also kept in their ExpiresAtField.
+const (
+	ExpiryIndex    = "_ephemeralExpiry"
+	ExpiresAtField = "expiresAt"
+)
+
+// ErrNotFound is returned by Store.Get for a missing key.
+var ErrNotFound = errors.New("key not found")
+
+// ScoredMember is a sorted set member with its score.
+type ScoredMember struct {
+	Member string
+	Score  float64
+}
+
+// Store is the key-value model the replication routes are written against:
+// ids are string keys, edges are hashes, and both are indexed by a sorted
+// set. Seed databases (Handle) and external Redis servers (RedisStore)
+// implement it. Features that need SQL work on a Handle and are only
+// available with seed databases.
+type Store interface {
+	// ZRangeByScore returns the members scored in [min, max] by ascending
+	// score. The bounds may be infinite.
+	ZRangeByScore(ctx context.Context, key string, min, max float64) ([]ScoredMember, error)
+	// ZAdd adds member or updates its score.
+	ZAdd(ctx context.Context, key, member string, score float64) error
+	// ZRem removes member, if present.
+	ZRem(ctx context.Context, key, member string) error
+	// ZScore returns the score of member or ErrNotFound.
+	ZScore(ctx context.Context, key, member string) (float64, error)
+	// Get returns a string value or ErrNotFound.
+	Get(ctx context.Context, key string) (string, error)
+	// Set stores a string value.
+	Set(ctx context.Context, key, value string) error
+	// SetEx stores a string value that expires after ttl.
+	SetEx(ctx context.Context, key, value string, ttl time.Duration) error
+	// HGetAll returns the fields of a hash, empty if it does not exist.
+	HGetAll(ctx context.Context, key string) (map[string]string, error)
+	// HMGet returns the given fields of a hash. Fields that don't exist are
+	// left out.
+	HMGet(ctx context.Context, key string, fields ...string) (map[string]string, error)
+	// HSet sets hash fields. Values are bool, int, float64, string or
+	// []byte; bools are stored as "1" and "0".
+	HSet(ctx context.Context, key string, fields map[string]any) error
+	// HDel removes a hash field, if present.
+	HDel(ctx context.Context, key, field string) error
+	// HIncrBy atomically adds delta to an integer hash field, missing
+	// fields counting as zero, and returns the new value.
+	HIncrBy(ctx context.Context, key, field string, delta int64) (int64, error)
+}
+
+var _ Store = (*Handle)(nil)
+
+// ZRangeByScore implements Store.
+func (h *Handle) ZRangeByScore(_ context.Context, key string, min, max float64) ([]ScoredMember, error) {
+	items, err := h.ZSet().RangeWith(key).ByScore(min, max).Run()
+	if err != nil {
+		return nil, err
+	}
+	members := make([]ScoredMember, len(items))
+	for i, item := range items {
+		members[i] = ScoredMember{Member: item.Elem.String(), Score: item.Score}
+	}
+	return members, nil
+}
+
+// ZAdd implements Store.
+func (h *Handle) ZAdd(_ context.Context, key, member string, score float64) error {
+	_, err := h.ZSet().Add(key, member, score)
+	return err
+}
+
+// ZRem implements Store.
+func (h *Handle) ZRem(_ context.Context, key, member string) error {
+	_, err := h.ZSet().Delete(key, member)
+	return err
+}
+
+// ZScore implements Store.
+func (h *Handle) ZScore(_ context.Context, key, member string) (float64, error) {
+	score, err := h.ZSet().GetScore(key, member)
+	if errors.Is(err, redka.ErrNotFound) {
+		return 0, ErrNotFound
+	}
+	return score, err
+}
+
+// Get implements Store.
+func (h *Handle) Get(_ context.Context, key string) (string, error) {
+	val, err := h.Str().Get(key)
+	if errors.Is(err, redka.ErrNotFound) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return val.String(), nil
+}
+
+// Set implements Store.
+func (h *Handle) Set(_ context.Context, key, value string) error {
+	return h.Str().Set(key, value)
+}
+
+// SetEx implements Store.
+func (h *Handle) SetEx(_ context.Context, key, value string, ttl time.Duration) error {
+	return h.Str().SetExpire(key, value, ttl)
+}
+
+// HGetAll implements Store.
+func (h *Handle) HGetAll(_ context.Context, key string) (map[string]string, error) {
+	items, err := h.Hash().Items(key)
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string]string, len(items))
+	for field, val := range items {
+		fields[field] = val.String()
+	}
+	return fields, nil
+}
+
+// HMGet implements Store.
+func (h *Handle) HMGet(_ context.Context, key string, fields ...string) (map[string]string, error) {
+	if len(fields) == 0 {
+		return map[string]string{}, nil
+	}
+	items, err := h.Hash().GetMany(key, fields...)
+	if err != nil {
+		return nil, err
+	}
+	vals := make(map[string]string, len(items))
+	for field, val := range items {
+		vals[field] = val.String()
+	}
+	return vals, nil
+}
+
+// HSet implements Store.
+func (h *Handle) HSet(_ context.Context, key string, fields map[string]any) error {
+	_, err := h.Hash().SetMany(key, fields)
+	return err
+}
+
+// HDel implements Store.
+func (h *Handle) HDel(_ context.Context, key, field string) error {
+	_, err := h.Hash().Delete(key, field)
+	return err
+}
+
+// HIncrBy implements Store.
+func (h *Handle) HIncrBy(_ context.Context, key, field string, delta int64) (int64, error) {
+	n, err := h.Hash().Incr(key, field, int(delta))
+	return int64(n), err
+}
@@ -0,0 +1,299 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"mapgl-app/pkg/resp"
+	"math"
+	"net"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestStores runs the replication round trip against both backends: a seed
+// database and Redis, the latter played by fakeRedis.
+func TestStores(t *testing.T) {
+	h, err := Open(filepath.Join(t.TempDir(), "seed.db"), SQLiteOptions{})
+	if err != nil {
+		t.Fatalf("open: %s", err)
+	}
+	defer h.Close()
+
+	fake := startFakeRedis(t, "secret")
+	client := resp.NewClient(resp.ClientOptions{Addr: fake.addr, Password: "secret"})
+	defer client.Close()
+
+	stores := map[string]Store{
+		"sqlite": h,
+		"redis":  NewRedisStore(client, "mapgl:seed:"),
+	}
+	for name, s := range stores {
+		t.Run(name, func(t *testing.T) {
+			testStore(t, s)
+		})
+	}
+
+	if _, ok := fake.strings["mapgl:seed:ts1"]; !ok {
+		t.Errorf("redis keys should carry the file prefix, got %v", fake.strings)
+	}
+
+	wrong := resp.NewClient(resp.ClientOptions{Addr: fake.addr, Password: "nope"})
+	defer wrong.Close()
+	if err := wrong.Ping(context.Background()); err == nil {
+		t.Error("a wrong password should be rejected")
+	}
+}
+
+func testStore(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	if _, err := s.Get(ctx, "ts1"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("missing key should be ErrNotFound, got %v", err)
+	}
+	if err := s.Set(ctx, "ts1", "Router 1"); err != nil {
+		t.Fatalf("set: %s", err)
+	}
+	if v, err := s.Get(ctx, "ts1"); err != nil || v != "Router 1" {
+		t.Fatalf("get = %q, %v", v, err)
+	}
+
+	if err := s.SetEx(ctx, "_tmp", "kept", time.Hour); err != nil {
+		t.Fatalf("setex: %s", err)
+	}
+	if v, err := s.Get(ctx, "_tmp"); err != nil || v != "kept" {
+		t.Fatalf("get after setex = %q, %v", v, err)
+	}
+
+	if err := s.HSet(ctx, "e1", map[string]any{"deleted": false, "parPath": `["a","b"]`, "isEph": true}); err != nil {
+		t.Fatalf("hset: %s", err)
+	}
+	fields, err := s.HGetAll(ctx, "e1")
+	if err != nil {
+		t.Fatalf("hgetall: %s", err)
+	}
+	want := map[string]string{"deleted": "0", "parPath": `["a","b"]`, "isEph": "1"}
+	for k, v := range want {
+		if fields[k] != v {
+			t.Errorf("field %s = %q, want %q", k, fields[k], v)
+		}
+	}
+	if fields, err := s.HMGet(ctx, "e1", "parPath", "props"); err != nil || len(fields) != 1 || fields["parPath"] != `["a","b"]` {
+		t.Errorf("hmget should return only the existing fields asked for, got %v, %v", fields, err)
+	}
+	if err := s.HDel(ctx, "e1", "isEph"); err != nil {
+		t.Fatalf("hdel: %s", err)
+	}
+	if fields, _ := s.HGetAll(ctx, "e1"); fields["isEph"] != "" || fields["deleted"] != "0" {
+		t.Errorf("hdel should remove only isEph, got %v", fields)
+	}
+	for want := int64(1); want <= 2; want++ {
+		if n, err := s.HIncrBy(ctx, "_revs", "e1", 1); err != nil || n != want {
+			t.Fatalf("hincrby = %d, %v, want %d", n, err, want)
+		}
+	}
+	if fields, err := s.HGetAll(ctx, "missing"); err != nil || len(fields) != 0 {
+		t.Errorf("missing hash should be empty, got %v, %v", fields, err)
+	}
+
+	for id, score := range map[string]float64{"e1": 100, "e2": 250.5, "e3": 300} {
+		if err := s.ZAdd(ctx, EdgesIndex, id, score); err != nil {
+			t.Fatalf("zadd %s: %s", id, err)
+		}
+	}
+	if err := s.ZAdd(ctx, EdgesIndex, "e1", 400); err != nil {
+		t.Fatalf("rescore: %s", err)
+	}
+	if err := s.ZAdd(ctx, EdgesIndex, "e4", 500); err != nil {
+		t.Fatalf("zadd e4: %s", err)
+	}
+	if err := s.ZRem(ctx, EdgesIndex, "e4"); err != nil {
+		t.Fatalf("zrem: %s", err)
+	}
+
+	if score, err := s.ZScore(ctx, EdgesIndex, "e1"); err != nil || score != 400 {
+		t.Errorf("zscore = %v, %v, want 400", score, err)
+	}
+	if _, err := s.ZScore(ctx, EdgesIndex, "e4"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("zscore of a removed member should be ErrNotFound, got %v", err)
+	}
+
+	got, err := s.ZRangeByScore(ctx, EdgesIndex, 250, math.Inf(1))
+	if err != nil {
+		t.Fatalf("range: %s", err)
+	}
+	wantRange := []ScoredMember{{"e2", 250.5}, {"e3", 300}, {"e1", 400}}
+	if len(got) != len(wantRange) {
+		t.Fatalf("range = %v, want %v", got, wantRange)
+	}
+	for i := range got {
+		if got[i] != wantRange[i] {
+			t.Errorf("range[%d] = %v, want %v", i, got[i], wantRange[i])
+		}
+	}
+}
+
+// fakeRedis is an in-process stand-in for the handful of commands
+// RedisStore sends.
+type fakeRedis struct {
+	addr     string
+	password string
+
+	mu      sync.Mutex
+	strings map[string]string
+	hashes  map[string]map[string]string
+	zsets   map[string]map[string]float64
+}
+
+func startFakeRedis(t *testing.T, password string) *fakeRedis {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	f := &fakeRedis{
+		addr:     ln.Addr().String(),
+		password: password,
+		strings:  map[string]string{},
+		hashes:   map[string]map[string]string{},
+		zsets:    map[string]map[string]float64{},
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r, w := resp.NewReader(conn), resp.NewWriter(conn)
+	authed := f.password == ""
+
+	for {
+		args, err := r.ReadCommand()
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(args[0])
+		switch {
+		case cmd == "AUTH":
+			authed = args[len(args)-1] == f.password
+			if authed {
+				w.WriteSimple("OK")
+			} else {
+				w.WriteError("WRONGPASS invalid password")
+			}
+		case !authed:
+			w.WriteError("NOAUTH Authentication required.")
+		default:
+			f.exec(w, cmd, args[1:])
+		}
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func (f *fakeRedis) exec(w *resp.Writer, cmd string, args []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch cmd {
+	case "PING":
+		w.WriteSimple("PONG")
+	case "SET":
+		f.strings[args[0]] = args[1]
+		w.WriteSimple("OK")
+	case "GET":
+		if v, ok := f.strings[args[0]]; ok {
+			w.WriteBulk(v)
+		} else {
+			w.WriteNull()
+		}
+	case "HSET":
+		h := f.hashes[args[0]]
+		if h == nil {
+			h = map[string]string{}
+			f.hashes[args[0]] = h
+		}
+		for i := 1; i+1 < len(args); i += 2 {
+			h[args[i]] = args[i+1]
+		}
+		w.WriteInt(int64(len(args) / 2))
+	case "HDEL":
+		delete(f.hashes[args[0]], args[1])
+		w.WriteInt(1)
+	case "HINCRBY":
+		h := f.hashes[args[0]]
+		if h == nil {
+			h = map[string]string{}
+			f.hashes[args[0]] = h
+		}
+		n, _ := strconv.ParseInt(h[args[1]], 10, 64)
+		delta, _ := strconv.ParseInt(args[2], 10, 64)
+		h[args[1]] = strconv.FormatInt(n+delta, 10)
+		w.WriteInt(n + delta)
+	case "HGETALL":
+		h := f.hashes[args[0]]
+		w.WriteArray(2 * len(h))
+		for k, v := range h {
+			w.WriteBulk(k)
+			w.WriteBulk(v)
+		}
+	case "HMGET":
+		w.WriteArray(len(args) - 1)
+		for _, field := range args[1:] {
+			if v, ok := f.hashes[args[0]][field]; ok {
+				w.WriteBulk(v)
+			} else {
+				w.WriteNull()
+			}
+		}
+	case "ZADD":
+		z := f.zsets[args[0]]
+		if z == nil {
+			z = map[string]float64{}
+			f.zsets[args[0]] = z
+		}
+		score, _ := strconv.ParseFloat(args[1], 64)
+		z[args[2]] = score
+		w.WriteInt(1)
+	case "ZREM":
+		delete(f.zsets[args[0]], args[1])
+		w.WriteInt(1)
+	case "ZSCORE":
+		if score, ok := f.zsets[args[0]][args[1]]; ok {
+			w.WriteBulk(strconv.FormatFloat(score, 'f', -1, 64))
+		} else {
+			w.WriteNull()
+		}
+	case "ZRANGEBYSCORE":
+		min, _ := strconv.ParseFloat(args[1], 64)
+		max, _ := strconv.ParseFloat(args[2], 64)
+		var members []ScoredMember
+		for m, s := range f.zsets[args[0]] {
+			if s >= min && s <= max {
+				members = append(members, ScoredMember{m, s})
+			}
+		}
+		sort.Slice(members, func(i, j int) bool { return members[i].Score < members[j].Score })
+		w.WriteArray(2 * len(members))
+		for _, m := range members {
+			w.WriteBulk(m.Member)
+			w.WriteBulk(strconv.FormatFloat(m.Score, 'f', -1, 64))
+		}
+	default:
+		w.WriteError("ERR unknown command '" + cmd + "'")
+	}
+}
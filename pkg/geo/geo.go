@@ -0,0 +1,145 @@
+// Package geo reads edge geometry from parPath arrays and provides the
+// planar and geodesic helpers the backend routes share.
+//
+// A parPath is what the panel stores for an edge: the first element names
+// the target node, the last the source node, and the arrays in between are
+// path points of the form [lon, lat, ...] with optional extra fields.
+package geo
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Point is a WGS84 position in degrees.
+type Point struct {
+	Lon float64 `json:"lon"`
+	Lat float64 `json:"lat"`
+}
+
+// BBox is a lon/lat bounding box in degrees.
+type BBox struct {
+	MinLon float64 `json:"minLon"`
+	MinLat float64 `json:"minLat"`
+	MaxLon float64 `json:"maxLon"`
+	MaxLat float64 `json:"maxLat"`
+}
+
+// PathPoints returns the coordinates of a parPath: every element that is an
+// array starting with two numbers. Node names and other elements are skipped.
+func PathPoints(parPath []interface{}) []Point {
+	var points []Point
+	for _, el := range parPath {
+		if p, ok := pointOf(el); ok {
+			points = append(points, p)
+		}
+	}
+	return points
+}
+
+func pointOf(el interface{}) (Point, bool) {
+	arr, ok := el.([]interface{})
+	if !ok || len(arr) < 2 {
+		return Point{}, false
+	}
+	lon, ok1 := arr[0].(float64)
+	lat, ok2 := arr[1].(float64)
+	if !ok1 || !ok2 || math.IsNaN(lon) || math.IsNaN(lat) {
+		return Point{}, false
+	}
+	return Point{Lon: lon, Lat: lat}, true
+}
+
+// Bounds returns the bounding box of points, or false if there are none.
+func Bounds(points []Point) (BBox, bool) {
+	if len(points) == 0 {
+		return BBox{}, false
+	}
+	b := BBox{MinLon: points[0].Lon, MinLat: points[0].Lat, MaxLon: points[0].Lon, MaxLat: points[0].Lat}
+	for _, p := range points[1:] {
+		b.MinLon = math.Min(b.MinLon, p.Lon)
+		b.MinLat = math.Min(b.MinLat, p.Lat)
+		b.MaxLon = math.Max(b.MaxLon, p.Lon)
+		b.MaxLat = math.Max(b.MaxLat, p.Lat)
+	}
+	return b, true
+}
+
+// Intersects reports whether the boxes overlap, edges included.
+func (b BBox) Intersects(o BBox) bool {
+	return b.MinLon <= o.MaxLon && o.MinLon <= b.MaxLon &&
+		b.MinLat <= o.MaxLat && o.MinLat <= b.MaxLat
+}
+
+// Validate checks that the box is ordered and within lon/lat range.
+func (b BBox) Validate() error {
+	switch {
+	case b.MinLon > b.MaxLon || b.MinLat > b.MaxLat:
+		return errors.New("bbox min must not exceed max")
+	case b.MinLon < -180 || b.MaxLon > 180 || b.MinLat < -90 || b.MaxLat > 90:
+		return errors.New("bbox is out of lon/lat range")
+	}
+	return nil
+}
+
+// ParseBBox reads "minLon,minLat,maxLon,maxLat".
+func ParseBBox(s string) (BBox, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return BBox{}, fmt.Errorf("bbox %q: want minLon,minLat,maxLon,maxLat", s)
+	}
+	var v [4]float64
+	for i, part := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return BBox{}, fmt.Errorf("bbox %q: %w", s, err)
+		}
+		v[i] = f
+	}
+	b := BBox{MinLon: v[0], MinLat: v[1], MaxLon: v[2], MaxLat: v[3]}
+	if err := b.Validate(); err != nil {
+		return BBox{}, fmt.Errorf("bbox %q: %w", s, err)
+	}
+	return b, nil
+}
+
+// earthRadius is the mean Earth radius in meters used by Distance.
+const earthRadius = 6371008.8
+
+// Distance returns the great-circle (haversine) distance between two
+// points in meters.
+func Distance(a, b Point) float64 {
+	lat1, lat2 := a.Lat*math.Pi/180, b.Lat*math.Pi/180
+	dLat := lat2 - lat1
+	dLon := (b.Lon - a.Lon) * math.Pi / 180
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// Length returns the geodesic length of a path in meters.
+func Length(points []Point) float64 {
+	var l float64
+	for i := 1; i < len(points); i++ {
+		l += Distance(points[i-1], points[i])
+	}
+	return l
+}
+
+// Geometry returns the GeoJSON geometry of a path: a LineString, a Point
+// for a single coordinate, or nil if there are none.
+func Geometry(points []Point) map[string]interface{} {
+	switch len(points) {
+	case 0:
+		return nil
+	case 1:
+		return map[string]interface{}{"type": "Point", "coordinates": []float64{points[0].Lon, points[0].Lat}}
+	}
+	coords := make([][]float64, len(points))
+	for i, p := range points {
+		coords[i] = []float64{p.Lon, p.Lat}
+	}
+	return map[string]interface{}{"type": "LineString", "coordinates": coords}
+}
@@ -0,0 +1,129 @@
+package geo
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestPathPoints(t *testing.T) {
+	parPath := []interface{}{
+		"target",
+		[]interface{}{2.35, 48.85, 0.0, "label", "#fff"},
+		[]interface{}{"not", "a point"},
+		[]interface{}{2.29},
+		[]interface{}{2.29, 48.86},
+		"source",
+	}
+	want := []Point{{2.35, 48.85}, {2.29, 48.86}}
+	if got := PathPoints(parPath); !reflect.DeepEqual(got, want) {
+		t.Errorf("PathPoints = %v, want %v", got, want)
+	}
+
+	b, ok := Bounds(want)
+	if !ok || b != (BBox{MinLon: 2.29, MinLat: 48.85, MaxLon: 2.35, MaxLat: 48.86}) {
+		t.Errorf("Bounds = %v, %v", b, ok)
+	}
+	if _, ok := Bounds(nil); ok {
+		t.Error("no points should have no bounds")
+	}
+}
+
+func TestParseBBox(t *testing.T) {
+	b, err := ParseBBox("-5, 42,8,51")
+	if err != nil || b != (BBox{-5, 42, 8, 51}) {
+		t.Errorf("ParseBBox = %v, %v", b, err)
+	}
+	for _, s := range []string{"", "1,2,3", "a,2,3,4", "8,42,-5,51", "-200,0,0,10"} {
+		if _, err := ParseBBox(s); err == nil {
+			t.Errorf("ParseBBox(%q) should fail", s)
+		}
+	}
+}
+
+func TestSimplifyXY(t *testing.T) {
+	// A near-straight line with one real corner.
+	points := [][2]float64{{0, 0}, {1, 0.01}, {2, -0.01}, {3, 0}, {3, 3}}
+	want := [][2]float64{{0, 0}, {3, 0}, {3, 3}}
+	if got := SimplifyXY(points, 0.1); !reflect.DeepEqual(got, want) {
+		t.Errorf("SimplifyXY = %v, want %v", got, want)
+	}
+	if got := SimplifyXY(points, 0); !reflect.DeepEqual(got, points) {
+		t.Errorf("zero tolerance should keep every point, got %v", got)
+	}
+	two := [][2]float64{{0, 0}, {1, 1}}
+	if got := SimplifyXY(two, 10); !reflect.DeepEqual(got, two) {
+		t.Errorf("endpoints must be kept, got %v", got)
+	}
+}
+
+func TestClipLine(t *testing.T) {
+	// Leaves and re-enters the square, so it is cut in two.
+	line := [][2]float64{{-5, 5}, {5, 5}, {15, 5}, {15, 8}, {5, 8}}
+	want := [][][2]float64{
+		{{0, 5}, {5, 5}, {10, 5}},
+		{{10, 8}, {5, 8}},
+	}
+	if got := ClipLine(line, 0, 10); !reflect.DeepEqual(got, want) {
+		t.Errorf("ClipLine = %v, want %v", got, want)
+	}
+	if got := ClipLine([][2]float64{{20, 20}, {30, 30}}, 0, 10); got != nil {
+		t.Errorf("line outside should be dropped, got %v", got)
+	}
+}
+
+func TestTile(t *testing.T) {
+	world := Tile{0, 0, 0}.Bounds(0)
+	if world.MinLon != -180 || world.MaxLon != 180 || math.Abs(world.MaxLat-maxMercatorLat) > 1e-6 {
+		t.Errorf("world tile bounds = %v", world)
+	}
+
+	tile := Tile{Z: 10, X: 512, Y: 340}
+	b := tile.Bounds(0)
+	nw := tile.Project(Point{b.MinLon, b.MaxLat}, 4096)
+	se := tile.Project(Point{b.MaxLon, b.MinLat}, 4096)
+	if math.Abs(nw[0]) > 1e-6 || math.Abs(nw[1]) > 1e-6 || math.Abs(se[0]-4096) > 1e-6 || math.Abs(se[1]-4096) > 1e-6 {
+		t.Errorf("corners project to %v and %v", nw, se)
+	}
+	if (Tile{Z: 2, X: 4, Y: 0}).Valid() {
+		t.Error("x out of range should be invalid")
+	}
+}
+
+func TestSimplifyPath(t *testing.T) {
+	parPath := []interface{}{
+		"target",
+		[]interface{}{0.0, 0.0, 0.0, "start"},
+		[]interface{}{1.0, 0.0001},
+		[]interface{}{2.0, 0.0, 0.0, "bend"},
+		[]interface{}{2.0001, 1.0},
+		[]interface{}{2.0, 2.0, 0.0, "end"},
+		"source",
+	}
+	want := []interface{}{parPath[0], parPath[1], parPath[3], parPath[5], parPath[6]}
+	if got := SimplifyPath(parPath, ZoomTolerance(10)); !reflect.DeepEqual(got, want) {
+		t.Errorf("SimplifyPath = %v, want %v", got, want)
+	}
+	if len(parPath) != 7 {
+		t.Error("SimplifyPath must not modify its input")
+	}
+	if got := SimplifyPath(parPath, ZoomTolerance(22)); len(got) != len(parPath) {
+		t.Errorf("detail zooms should keep every point, got %v", got)
+	}
+}
+
+func TestLength(t *testing.T) {
+	// Paris to London is about 343.5 km on the sphere.
+	d := Distance(Point{2.3522, 48.8566}, Point{-0.1276, 51.5072})
+	if math.Abs(d-343.5e3) > 1e3 {
+		t.Errorf("Paris-London = %.0f m", d)
+	}
+	// One degree along the equator, in two steps.
+	l := Length([]Point{{0, 0}, {0.5, 0}, {1, 0}})
+	if math.Abs(l-111195) > 1 {
+		t.Errorf("one degree of equator = %.1f m", l)
+	}
+	if Length([]Point{{1, 1}}) != 0 {
+		t.Error("a single point has no length")
+	}
+}
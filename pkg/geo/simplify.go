@@ -0,0 +1,108 @@
+package geo
+
+import "math"
+
+// SimplifyXY reduces a path of planar x/y pairs, such as tile coordinates,
+// with the Douglas-Peucker algorithm. Points closer than tolerance to the
+// simplified line are dropped; the first and last points are always kept
+// exactly.
+func SimplifyXY(points [][2]float64, tolerance float64) [][2]float64 {
+	keep := douglasPeucker(len(points), func(i int) (float64, float64) {
+		return points[i][0], points[i][1]
+	}, tolerance)
+	if keep == nil {
+		return points
+	}
+	out := make([][2]float64, 0, len(points))
+	for i, p := range points {
+		if keep[i] {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// douglasPeucker marks the points to keep, or returns nil when all are.
+func douglasPeucker(n int, at func(int) (float64, float64), tolerance float64) []bool {
+	if n <= 2 || tolerance <= 0 {
+		return nil
+	}
+	keep := make([]bool, n)
+	keep[0], keep[n-1] = true, true
+
+	// An explicit stack avoids deep recursion on long paths.
+	stack := [][2]int{{0, n - 1}}
+	for len(stack) > 0 {
+		first, last := stack[len(stack)-1][0], stack[len(stack)-1][1]
+		stack = stack[:len(stack)-1]
+
+		ax, ay := at(first)
+		bx, by := at(last)
+		maxDist, index := -1.0, -1
+		for i := first + 1; i < last; i++ {
+			px, py := at(i)
+			if d := segmentDistance(px, py, ax, ay, bx, by); d > maxDist {
+				maxDist, index = d, i
+			}
+		}
+		if index >= 0 && maxDist > tolerance {
+			keep[index] = true
+			stack = append(stack, [2]int{first, index}, [2]int{index, last})
+		}
+	}
+	return keep
+}
+
+// segmentDistance is the distance from p to the segment a-b.
+func segmentDistance(px, py, ax, ay, bx, by float64) float64 {
+	dx, dy := bx-ax, by-ay
+	if dx == 0 && dy == 0 {
+		return math.Hypot(px-ax, py-ay)
+	}
+	t := ((px-ax)*dx + (py-ay)*dy) / (dx*dx + dy*dy)
+	t = math.Max(0, math.Min(1, t))
+	return math.Hypot(px-(ax+t*dx), py-(ay+t*dy))
+}
+
+// SimplifyPath simplifies the coordinates of a parPath like SimplifyXY,
+// treating lon/lat as planar coordinates and tolerance as degrees, and
+// returns a new one. Node names and other non-coordinate elements are kept
+// in place, as are the first and last coordinates; the input is not
+// modified.
+func SimplifyPath(parPath []interface{}, tolerance float64) []interface{} {
+	var idx []int
+	var points []Point
+	for i, el := range parPath {
+		if p, ok := pointOf(el); ok {
+			idx = append(idx, i)
+			points = append(points, p)
+		}
+	}
+	keep := douglasPeucker(len(points), func(i int) (float64, float64) {
+		return points[i].Lon, points[i].Lat
+	}, tolerance)
+	if keep == nil {
+		return parPath
+	}
+
+	drop := make(map[int]bool, len(idx))
+	for j, i := range idx {
+		if !keep[j] {
+			drop[i] = true
+		}
+	}
+	out := make([]interface{}, 0, len(parPath)-len(drop))
+	for i, el := range parPath {
+		if !drop[i] {
+			out = append(out, el)
+		}
+	}
+	return out
+}
+
+// ZoomTolerance returns the size in degrees of one pixel of a 256 px Web
+// Mercator tile at zoom, a tolerance that keeps simplification invisible
+// at that zoom.
+func ZoomTolerance(zoom float64) float64 {
+	return 360 / (256 * math.Pow(2, zoom))
+}
@@ -0,0 +1,113 @@
+package geo
+
+import "math"
+
+// maxMercatorLat is the latitude where the Web Mercator square ends.
+const maxMercatorLat = 85.05112878
+
+// Tile is a z/x/y Web Mercator (XYZ) tile.
+type Tile struct {
+	Z, X, Y int
+}
+
+// Valid reports whether x and y exist at zoom z.
+func (t Tile) Valid() bool {
+	if t.Z < 0 || t.Z > 30 {
+		return false
+	}
+	n := 1 << t.Z
+	return t.X >= 0 && t.X < n && t.Y >= 0 && t.Y < n
+}
+
+// Bounds returns the lon/lat box of the tile, grown by buffer (a fraction
+// of the tile size) on every side.
+func (t Tile) Bounds(buffer float64) BBox {
+	n := float64(int(1) << t.Z)
+	lon := func(x float64) float64 { return x/n*360 - 180 }
+	lat := func(y float64) float64 { return math.Atan(math.Sinh(math.Pi*(1-2*y/n))) * 180 / math.Pi }
+
+	x0, x1 := float64(t.X)-buffer, float64(t.X+1)+buffer
+	y0, y1 := float64(t.Y)-buffer, float64(t.Y+1)+buffer
+	return BBox{
+		MinLon: math.Max(-180, lon(x0)),
+		MinLat: math.Max(-90, lat(y1)),
+		MaxLon: math.Min(180, lon(x1)),
+		MaxLat: math.Min(90, lat(y0)),
+	}
+}
+
+// Project returns the position of p in tile coordinates, where the tile
+// spans [0, extent] on both axes with y pointing down.
+func (t Tile) Project(p Point, extent float64) [2]float64 {
+	n := float64(int(1) << t.Z)
+	lat := math.Max(-maxMercatorLat, math.Min(maxMercatorLat, p.Lat)) * math.Pi / 180
+	x := (p.Lon + 180) / 360 * n
+	y := (1 - math.Log(math.Tan(lat)+1/math.Cos(lat))/math.Pi) / 2 * n
+	return [2]float64{(x - float64(t.X)) * extent, (y - float64(t.Y)) * extent}
+}
+
+// ClipLine cuts a polyline to the square [min, max] on both axes and returns
+// the pieces inside it.
+func ClipLine(line [][2]float64, min, max float64) [][][2]float64 {
+	var pieces [][][2]float64
+	var cur [][2]float64
+	for i := 0; i+1 < len(line); i++ {
+		a, b, ok := clipSegment(line[i], line[i+1], min, max)
+		if !ok {
+			if len(cur) > 1 {
+				pieces = append(pieces, cur)
+			}
+			cur = nil
+			continue
+		}
+		if len(cur) == 0 || cur[len(cur)-1] != a {
+			if len(cur) > 1 {
+				pieces = append(pieces, cur)
+			}
+			cur = [][2]float64{a}
+		}
+		cur = append(cur, b)
+	}
+	if len(cur) > 1 {
+		pieces = append(pieces, cur)
+	}
+	return pieces
+}
+
+// clipSegment is Liang-Barsky clipping of a-b to the square.
+func clipSegment(a, b [2]float64, min, max float64) ([2]float64, [2]float64, bool) {
+	t0, t1 := 0.0, 1.0
+	dx, dy := b[0]-a[0], b[1]-a[1]
+	for _, e := range [4][2]float64{
+		{-dx, a[0] - min}, {dx, max - a[0]},
+		{-dy, a[1] - min}, {dy, max - a[1]},
+	} {
+		p, q := e[0], e[1]
+		if p == 0 {
+			if q < 0 {
+				return a, b, false
+			}
+			continue
+		}
+		r := q / p
+		if p < 0 {
+			if r > t1 {
+				return a, b, false
+			}
+			t0 = math.Max(t0, r)
+		} else {
+			if r < t0 {
+				return a, b, false
+			}
+			t1 = math.Min(t1, r)
+		}
+	}
+	ca, cb := a, b
+	if t0 > 0 {
+		ca = [2]float64{a[0] + t0*dx, a[1] + t0*dy}
+	}
+	if t1 < 1 {
+		cb = [2]float64{a[0] + t1*dx, a[1] + t1*dy}
+	}
+	return ca, cb, true
+}
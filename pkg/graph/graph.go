@@ -0,0 +1,304 @@
+// Package graph answers connectivity questions about a network of edges:
+// connected components, shortest paths and what a failed edge cuts off.
+// Edges are undirected, parallel edges are allowed.
+package graph
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// Edge connects two nodes.
+type Edge struct {
+	ID     string
+	From   string
+	To     string
+	Length float64 // meters
+}
+
+// Graph is an immutable adjacency list built from edges.
+type Graph struct {
+	edges map[string]Edge
+	adj   map[string][]string // node -> ids of its edges
+}
+
+// New builds a graph. Later edges with the same id replace earlier ones.
+func New(edges []Edge) *Graph {
+	g := &Graph{edges: make(map[string]Edge, len(edges)), adj: make(map[string][]string)}
+	for _, e := range edges {
+		g.edges[e.ID] = e
+	}
+	for _, id := range sortedKeys(g.edges) {
+		e := g.edges[id]
+		g.adj[e.From] = append(g.adj[e.From], id)
+		if e.To != e.From {
+			g.adj[e.To] = append(g.adj[e.To], id)
+		}
+	}
+	return g
+}
+
+// HasNode reports whether a node is the end of some edge.
+func (g *Graph) HasNode(node string) bool {
+	_, ok := g.adj[node]
+	return ok
+}
+
+// Edge returns an edge by id.
+func (g *Graph) Edge(id string) (Edge, bool) {
+	e, ok := g.edges[id]
+	return e, ok
+}
+
+// NodeCount returns the number of nodes.
+func (g *Graph) NodeCount() int {
+	return len(g.adj)
+}
+
+// Component is a maximal set of connected nodes and the edges between them.
+type Component struct {
+	Nodes  []string `json:"nodes"`
+	Edges  []string `json:"edges"`
+	Length float64  `json:"length"`
+}
+
+// Components returns the connected components, largest first.
+func (g *Graph) Components() []Component {
+	seen := make(map[string]bool, len(g.adj))
+	var comps []Component
+	for _, start := range sortedKeys(g.adj) {
+		if seen[start] {
+			continue
+		}
+		nodes := g.reach(start, "", seen)
+		comp := Component{Nodes: nodes}
+		edgeSeen := map[string]bool{}
+		for _, n := range nodes {
+			for _, id := range g.adj[n] {
+				if !edgeSeen[id] {
+					edgeSeen[id] = true
+					comp.Edges = append(comp.Edges, id)
+					comp.Length += g.edges[id].Length
+				}
+			}
+		}
+		sort.Strings(comp.Edges)
+		comps = append(comps, comp)
+	}
+	sort.SliceStable(comps, func(i, j int) bool {
+		return len(comps[i].Nodes) > len(comps[j].Nodes)
+	})
+	return comps
+}
+
+// reach returns the nodes reachable from start without using the edge
+// skip, marking them in seen. The result is sorted.
+func (g *Graph) reach(start, skip string, seen map[string]bool) []string {
+	nodes := []string{start}
+	seen[start] = true
+	for i := 0; i < len(nodes); i++ {
+		for _, id := range g.adj[nodes[i]] {
+			if id == skip {
+				continue
+			}
+			e := g.edges[id]
+			next := e.To
+			if next == nodes[i] {
+				next = e.From
+			}
+			if !seen[next] {
+				seen[next] = true
+				nodes = append(nodes, next)
+			}
+		}
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// Path is a route through the graph.
+type Path struct {
+	Nodes  []string `json:"nodes"`
+	Edges  []string `json:"edges"`
+	Length float64  `json:"length"`
+	Hops   int      `json:"hops"`
+}
+
+// ShortestPath returns the path between two nodes with the smallest total
+// length, or the fewest edges when byHops is set. It returns false if the
+// nodes are not connected.
+func (g *Graph) ShortestPath(from, to string, byHops bool) (Path, bool) {
+	if !g.HasNode(from) || !g.HasNode(to) {
+		return Path{}, false
+	}
+
+	weight := func(e Edge) float64 {
+		if byHops {
+			return 1
+		}
+		return e.Length
+	}
+
+	dist := map[string]float64{from: 0}
+	via := map[string]string{} // node -> edge it was reached by
+	done := map[string]bool{}
+	q := &queue{{node: from}}
+	for q.Len() > 0 {
+		cur := heap.Pop(q).(item)
+		if done[cur.node] {
+			continue
+		}
+		done[cur.node] = true
+		if cur.node == to {
+			break
+		}
+		for _, id := range g.adj[cur.node] {
+			e := g.edges[id]
+			next := e.To
+			if next == cur.node {
+				next = e.From
+			}
+			d := cur.dist + weight(e)
+			if old, ok := dist[next]; !done[next] && (!ok || d < old) {
+				dist[next] = d
+				via[next] = id
+				heap.Push(q, item{node: next, dist: d})
+			}
+		}
+	}
+	if !done[to] {
+		return Path{}, false
+	}
+
+	p := Path{Nodes: []string{to}}
+	for n := to; n != from; {
+		id := via[n]
+		e := g.edges[id]
+		p.Edges = append(p.Edges, id)
+		p.Length += e.Length
+		if e.To == n {
+			n = e.From
+		} else {
+			n = e.To
+		}
+		p.Nodes = append(p.Nodes, n)
+	}
+	reverse(p.Nodes)
+	reverse(p.Edges)
+	p.Hops = len(p.Edges)
+	return p, true
+}
+
+// Failure describes the effect of removing one edge.
+type Failure struct {
+	EdgeID string `json:"edgeId"`
+	// Bridge is set when the edge was the only connection between its ends.
+	Bridge bool `json:"bridge"`
+	// Reachable are the nodes still reachable from the root.
+	Reachable []string `json:"reachable"`
+	// CutOff are the nodes that lose their connection to the root.
+	CutOff []string `json:"cutOff"`
+	// CutOffEdges are the edges that end up disconnected with them.
+	CutOffEdges []string `json:"cutOffEdges"`
+}
+
+// Fail removes the edge and reports what is cut off. With a root node, the
+// nodes that could reach it before but not after are cut off. Without one,
+// the side of the edge with fewer nodes is, as the side more likely to
+// depend on the rest of the network. It returns false for unknown edges.
+func (g *Graph) Fail(edgeID, root string) (Failure, bool) {
+	e, ok := g.edges[edgeID]
+	if !ok {
+		return Failure{}, false
+	}
+	f := Failure{EdgeID: edgeID, Reachable: []string{}, CutOff: []string{}, CutOffEdges: []string{}}
+
+	fromSide := g.reach(e.From, edgeID, map[string]bool{})
+	f.Bridge = !contains(fromSide, e.To)
+
+	before := map[string]bool{}
+	var after []string
+	switch {
+	case root != "":
+		if !g.HasNode(root) {
+			return f, true
+		}
+		for _, n := range g.reach(root, "", map[string]bool{}) {
+			before[n] = true
+		}
+		after = g.reach(root, edgeID, map[string]bool{})
+	case !f.Bridge:
+		after = fromSide
+		for _, n := range fromSide {
+			before[n] = true
+		}
+	default:
+		toSide := g.reach(e.To, edgeID, map[string]bool{})
+		after = fromSide
+		if len(toSide) > len(fromSide) {
+			after = toSide
+		}
+		for _, n := range append(fromSide, toSide...) {
+			before[n] = true
+		}
+	}
+
+	f.Reachable = after
+	still := map[string]bool{}
+	for _, n := range after {
+		still[n] = true
+	}
+	for _, n := range sortedKeys(before) {
+		if !still[n] {
+			f.CutOff = append(f.CutOff, n)
+		}
+	}
+	for _, id := range sortedKeys(g.edges) {
+		if id == edgeID {
+			continue
+		}
+		other := g.edges[id]
+		if before[other.From] && !still[other.From] {
+			f.CutOffEdges = append(f.CutOffEdges, id)
+		}
+	}
+	return f, true
+}
+
+type item struct {
+	node string
+	dist float64
+}
+
+type queue []item
+
+func (q queue) Len() int            { return len(q) }
+func (q queue) Less(i, j int) bool  { return q[i].dist < q[j].dist }
+func (q queue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *queue) Push(x interface{}) { *q = append(*q, x.(item)) }
+func (q *queue) Pop() interface{} {
+	old := *q
+	it := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return it
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func contains(list []string, s string) bool {
+	i := sort.SearchStrings(list, s)
+	return i < len(list) && list[i] == s
+}
+
+func reverse(s []string) {
+	for i, j := 0, len(s)-1; i < j; i, j = i+1, j-1 {
+		s[i], s[j] = s[j], s[i]
+	}
+}
@@ -0,0 +1,79 @@
+package graph
+
+import (
+	"reflect"
+	"testing"
+)
+
+// ring: a-b-c-d-a, with a spur d-e-f and a separate island x-y.
+var testEdges = []Edge{
+	{ID: "ab", From: "a", To: "b", Length: 1},
+	{ID: "bc", From: "b", To: "c", Length: 1},
+	{ID: "cd", From: "c", To: "d", Length: 1},
+	{ID: "da", From: "d", To: "a", Length: 10},
+	{ID: "de", From: "d", To: "e", Length: 2},
+	{ID: "ef", From: "e", To: "f", Length: 2},
+	{ID: "xy", From: "x", To: "y", Length: 5},
+}
+
+func TestComponents(t *testing.T) {
+	comps := New(testEdges).Components()
+	if len(comps) != 2 {
+		t.Fatalf("want 2 components, got %v", comps)
+	}
+	if want := []string{"a", "b", "c", "d", "e", "f"}; !reflect.DeepEqual(comps[0].Nodes, want) {
+		t.Errorf("largest component = %v, want %v", comps[0].Nodes, want)
+	}
+	if comps[0].Length != 17 || len(comps[0].Edges) != 6 {
+		t.Errorf("largest component has %d edges of %v m", len(comps[0].Edges), comps[0].Length)
+	}
+	if want := []string{"x", "y"}; !reflect.DeepEqual(comps[1].Nodes, want) {
+		t.Errorf("island = %v, want %v", comps[1].Nodes, want)
+	}
+}
+
+func TestShortestPath(t *testing.T) {
+	g := New(testEdges)
+
+	p, ok := g.ShortestPath("a", "e", false)
+	if !ok || !reflect.DeepEqual(p.Nodes, []string{"a", "b", "c", "d", "e"}) || p.Length != 5 || p.Hops != 4 {
+		t.Errorf("shortest by length = %+v, %v", p, ok)
+	}
+	p, ok = g.ShortestPath("a", "e", true)
+	if !ok || !reflect.DeepEqual(p.Edges, []string{"da", "de"}) || p.Length != 12 || p.Hops != 2 {
+		t.Errorf("shortest by hops = %+v, %v", p, ok)
+	}
+	if _, ok := g.ShortestPath("a", "x", false); ok {
+		t.Error("nodes in different components are not connected")
+	}
+	if p, ok := g.ShortestPath("c", "c", false); !ok || p.Hops != 0 {
+		t.Errorf("a node reaches itself, got %+v, %v", p, ok)
+	}
+}
+
+func TestFail(t *testing.T) {
+	g := New(testEdges)
+
+	f, _ := g.Fail("ab", "")
+	if f.Bridge || len(f.CutOff) != 0 {
+		t.Errorf("ring edge should not cut anything off, got %+v", f)
+	}
+
+	f, _ = g.Fail("de", "")
+	if !f.Bridge || !reflect.DeepEqual(f.CutOff, []string{"e", "f"}) || !reflect.DeepEqual(f.CutOffEdges, []string{"ef"}) {
+		t.Errorf("spur failure should cut off e and f, got %+v", f)
+	}
+
+	// Seen from the spur's end, the rest of the network is what is lost.
+	f, _ = g.Fail("de", "f")
+	if want := []string{"a", "b", "c", "d"}; !reflect.DeepEqual(f.CutOff, want) {
+		t.Errorf("cut off from f = %v, want %v", f.CutOff, want)
+	}
+	if want := []string{"e", "f"}; !reflect.DeepEqual(f.Reachable, want) {
+		t.Errorf("reachable from f = %v, want %v", f.Reachable, want)
+	}
+
+	if _, ok := g.Fail("nope", ""); ok {
+		t.Error("unknown edge should not be found")
+	}
+}
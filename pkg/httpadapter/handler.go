@@ -9,6 +9,9 @@ import (
 	"strings"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // New creates a new backend.CallResourceHandler adapter for
@@ -24,13 +27,14 @@ type httpResourceHandler struct {
 }
 
 func (h *httpResourceHandler) CallResource(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
-	var reqBodyReader io.Reader
+	var reqBodyReader io.Reader = http.NoBody
 	if len(req.Body) > 0 {
 		reqBodyReader = bytes.NewReader(req.Body)
 	}
 
 	ctx = withPluginContext(ctx, req.PluginContext)
 	ctx = withUser(ctx, req.PluginContext.User)
+	ctx = withTraceContext(ctx, req.Headers)
 	reqURL, err := url.Parse(req.URL)
 	if err != nil {
 		return err
@@ -50,8 +54,10 @@ func (h *httpResourceHandler) CallResource(ctx context.Context, req *backend.Cal
 		return err
 	}
 
+	// Proxies may forward header names in lower case; canonical keys keep
+	// Header.Get working for handlers.
 	for key, values := range req.Headers {
-		httpReq.Header[key] = values
+		httpReq.Header[http.CanonicalHeaderKey(key)] = values
 	}
 
 	writer := newResponseWriter(sender)
@@ -61,6 +67,15 @@ func (h *httpResourceHandler) CallResource(ctx context.Context, req *backend.Cal
 	return nil
 }
 
+// withTraceContext picks up the trace from the forwarded request headers
+// when Grafana did not already propagate it through the gRPC call.
+func withTraceContext(ctx context.Context, headers map[string][]string) context.Context {
+	if trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(headers))
+}
+
 type pluginConfigKey struct{}
 
 func withPluginContext(ctx context.Context, pluginCtx backend.PluginContext) context.Context {
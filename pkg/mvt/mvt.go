@@ -0,0 +1,235 @@
+// Package mvt encodes Mapbox Vector Tiles (specification 2.1) without a
+// protobuf dependency: the format needs only a handful of message types.
+package mvt
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// DefaultExtent is the tile coordinate range used by most renderers.
+const DefaultExtent = 4096
+
+// Geometry types.
+const (
+	Point      = 1
+	LineString = 2
+)
+
+// Feature is one point or (multi)line in tile coordinates.
+type Feature struct {
+	Type int
+	// Parts are the points of a (multi)point, or the lines of a
+	// (multi)linestring. Coordinates are rounded to integers.
+	Parts      [][][2]float64
+	Properties map[string]interface{}
+}
+
+// Layer is a named set of features.
+type Layer struct {
+	Name     string
+	Extent   int
+	Features []Feature
+}
+
+// Encode serializes the layers as a tile. Layers without features are
+// left out; a tile without layers encodes to an empty slice.
+func Encode(layers []Layer) ([]byte, error) {
+	var tile []byte
+	for _, l := range layers {
+		if len(l.Features) == 0 {
+			continue
+		}
+		b, err := encodeLayer(l)
+		if err != nil {
+			return nil, fmt.Errorf("layer %s: %w", l.Name, err)
+		}
+		tile = appendBytes(tile, 3, b)
+	}
+	return tile, nil
+}
+
+func encodeLayer(l Layer) ([]byte, error) {
+	extent := l.Extent
+	if extent <= 0 {
+		extent = DefaultExtent
+	}
+
+	keys := map[string]uint64{}
+	var keyList []string
+	values := map[interface{}]uint64{}
+	var valueList [][]byte
+
+	var b []byte
+	b = appendVarintField(b, 15, 2)
+	b = appendBytes(b, 1, []byte(l.Name))
+
+	for _, f := range l.Features {
+		geom := encodeGeometry(f)
+		if len(geom) == 0 {
+			continue
+		}
+
+		// Sorted keys keep the encoding stable for caches and ETags.
+		names := make([]string, 0, len(f.Properties))
+		for k := range f.Properties {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+
+		var tags []uint64
+		for _, k := range names {
+			v := f.Properties[k]
+			val, key, err := encodeValue(v)
+			if err != nil {
+				return nil, fmt.Errorf("property %s: %w", k, err)
+			}
+			if val == nil {
+				continue
+			}
+			ki, ok := keys[k]
+			if !ok {
+				ki = uint64(len(keyList))
+				keys[k] = ki
+				keyList = append(keyList, k)
+			}
+			vi, ok := values[key]
+			if !ok {
+				vi = uint64(len(valueList))
+				values[key] = vi
+				valueList = append(valueList, val)
+			}
+			tags = append(tags, ki, vi)
+		}
+
+		var fb []byte
+		if len(tags) > 0 {
+			fb = appendPacked(fb, 2, tags)
+		}
+		fb = appendVarintField(fb, 3, uint64(f.Type))
+		fb = appendPacked(fb, 4, geom)
+		b = appendBytes(b, 2, fb)
+	}
+
+	for _, k := range keyList {
+		b = appendBytes(b, 3, []byte(k))
+	}
+	for _, v := range valueList {
+		b = appendBytes(b, 4, v)
+	}
+	b = appendVarintField(b, 5, uint64(extent))
+	return b, nil
+}
+
+// encodeValue returns the Value message of v and a key to dedupe it by.
+// Nil values are skipped.
+func encodeValue(v interface{}) ([]byte, interface{}, error) {
+	var b []byte
+	switch v := v.(type) {
+	case nil:
+		return nil, nil, nil
+	case string:
+		b = appendBytes(b, 1, []byte(v))
+	case bool:
+		n := uint64(0)
+		if v {
+			n = 1
+		}
+		b = appendVarintField(b, 7, n)
+	case int:
+		b = appendVarintField(b, 6, zigzag(int64(v)))
+	case int64:
+		b = appendVarintField(b, 6, zigzag(v))
+	case float64:
+		b = appendVarint(b, 3<<3|1)
+		bits := math.Float64bits(v)
+		for i := 0; i < 8; i++ {
+			b = append(b, byte(bits>>(8*i)))
+		}
+	default:
+		return nil, nil, fmt.Errorf("unsupported type %T", v)
+	}
+	return b, string(b), nil
+}
+
+// encodeGeometry turns the parts into MoveTo/LineTo commands with
+// zigzag-encoded deltas. Repeated points and degenerate lines are dropped.
+func encodeGeometry(f Feature) []uint64 {
+	var cmds []uint64
+	var cx, cy int64
+
+	if f.Type == Point {
+		var pts [][2]int64
+		for _, part := range f.Parts {
+			for _, p := range part {
+				pts = append(pts, [2]int64{int64(math.Round(p[0])), int64(math.Round(p[1]))})
+			}
+		}
+		if len(pts) == 0 {
+			return nil
+		}
+		cmds = append(cmds, command(1, len(pts)))
+		for _, p := range pts {
+			cmds = append(cmds, zigzag(p[0]-cx), zigzag(p[1]-cy))
+			cx, cy = p[0], p[1]
+		}
+		return cmds
+	}
+
+	for _, part := range f.Parts {
+		var line [][2]int64
+		for _, p := range part {
+			q := [2]int64{int64(math.Round(p[0])), int64(math.Round(p[1]))}
+			if len(line) == 0 || line[len(line)-1] != q {
+				line = append(line, q)
+			}
+		}
+		if len(line) < 2 {
+			continue
+		}
+		cmds = append(cmds, command(1, 1), zigzag(line[0][0]-cx), zigzag(line[0][1]-cy))
+		cx, cy = line[0][0], line[0][1]
+		cmds = append(cmds, command(2, len(line)-1))
+		for _, p := range line[1:] {
+			cmds = append(cmds, zigzag(p[0]-cx), zigzag(p[1]-cy))
+			cx, cy = p[0], p[1]
+		}
+	}
+	return cmds
+}
+
+func command(id, count int) uint64 {
+	return uint64(id&0x7) | uint64(count)<<3
+}
+
+func zigzag(n int64) uint64 {
+	return uint64((n << 1) ^ (n >> 63))
+}
+
+func appendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func appendVarintField(b []byte, field int, v uint64) []byte {
+	b = appendVarint(b, uint64(field)<<3)
+	return appendVarint(b, v)
+}
+
+func appendBytes(b []byte, field int, data []byte) []byte {
+	b = appendVarint(b, uint64(field)<<3|2)
+	b = appendVarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+func appendPacked(b []byte, field int, vals []uint64) []byte {
+	var packed []byte
+	for _, v := range vals {
+		packed = appendVarint(packed, v)
+	}
+	return appendBytes(b, field, packed)
+}
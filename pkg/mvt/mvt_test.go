@@ -0,0 +1,64 @@
+package mvt
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestEncodeGeometry checks the command streams of the specification's
+// examples (section 4.3.5).
+func TestEncodeGeometry(t *testing.T) {
+	tests := []struct {
+		name string
+		f    Feature
+		want []uint64
+	}{
+		{"point", Feature{Type: Point, Parts: [][][2]float64{{{25, 17}}}}, []uint64{9, 50, 34}},
+		{"multipoint", Feature{Type: Point, Parts: [][][2]float64{{{5, 7}, {3, 2}}}}, []uint64{17, 10, 14, 3, 9}},
+		{"linestring", Feature{Type: LineString, Parts: [][][2]float64{{{2, 2}, {2, 10}, {10, 10}}}},
+			[]uint64{9, 4, 4, 18, 0, 16, 16, 0}},
+		{"multilinestring", Feature{Type: LineString, Parts: [][][2]float64{{{2, 2}, {2, 10}, {10, 10}}, {{1, 1}, {3, 5}}}},
+			[]uint64{9, 4, 4, 18, 0, 16, 16, 0, 9, 17, 17, 10, 4, 8}},
+		{"degenerate line", Feature{Type: LineString, Parts: [][][2]float64{{{1.2, 1}, {0.9, 1}}}}, nil},
+	}
+	for _, tt := range tests {
+		if got := encodeGeometry(tt.f); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestEncode checks the layer framing and that keys and values are shared
+// between features.
+func TestEncode(t *testing.T) {
+	tile, err := Encode([]Layer{
+		{Name: "empty"},
+		{Name: "nodes", Features: []Feature{
+			{Type: Point, Parts: [][][2]float64{{{25, 17}}}, Properties: map[string]interface{}{"name": "a", "up": true}},
+			{Type: Point, Parts: [][][2]float64{{{1, 1}}}, Properties: map[string]interface{}{"name": "a", "skip": nil}},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("encode: %s", err)
+	}
+
+	want := []byte{
+		0x1a, 0x3b, // layer, 59 bytes
+		0x78, 0x02, // version 2
+		0x0a, 0x05, 'n', 'o', 'd', 'e', 's',
+		0x12, 0x0d, 0x12, 0x04, 0, 0, 1, 1, 0x18, 0x01, 0x22, 0x03, 9, 50, 34, // feature 1
+		0x12, 0x0b, 0x12, 0x02, 0, 0, 0x18, 0x01, 0x22, 0x03, 9, 2, 2, // feature 2
+		0x1a, 0x04, 'n', 'a', 'm', 'e',
+		0x1a, 0x02, 'u', 'p',
+		0x22, 0x03, 0x0a, 0x01, 'a',
+		0x22, 0x02, 0x38, 0x01,
+		0x28, 0x80, 0x20, // extent 4096
+	}
+	if !reflect.DeepEqual(tile, want) {
+		t.Errorf("tile = % x\nwant   % x", tile, want)
+	}
+
+	if tile, _ := Encode([]Layer{{Name: "empty"}}); len(tile) != 0 {
+		t.Errorf("tile without features should be empty, got % x", tile)
+	}
+}
@@ -2,15 +2,19 @@ package plugin
 
 import (
 	"context"
+	"encoding/json"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/instancemgmt"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"mapgl-app/pkg/database"
 	"mapgl-app/pkg/httpadapter"
+	"mapgl-app/pkg/resp"
 	"mapgl-app/pkg/settings"
 	// 	"net/http"
 	//  	"fmt"
 	"github.com/gorilla/mux"
-	//"mapgl-app/pkg/signal"
+	"mapgl-app/pkg/signal"
+	"sync"
 )
 
 // Make sure App implements required interfaces. This is important to do
@@ -27,6 +31,38 @@ var (
 type App struct {
 	backend.CallResourceHandler
 	MapglSettings *settings.MapglAppSettings
+
+	dbs       *database.Pool
+	redis     *resp.Client // set when the storage backend is Redis
+	tiles     *tileCache
+	expiring  *expiryTracker
+	signaling *signal.SignalingServer
+
+	// respServer serves a seed file to Redis clients when enabled;
+	// respRelease hands the seed file back. respErr is why it didn't start,
+	// or why it is still waiting for its port. respMu guards the three, which
+	// are set in the background while the previous instance holds the port.
+	respMu      sync.Mutex
+	respServer  *resp.Server
+	respRelease func()
+	respErr     error
+
+	// canWrite is set when the license has a power host, which enables the
+	// push routes and every other route that modifies seed databases.
+	canWrite bool
+
+	// pushMu serializes the pushes, RESP writes and edit lock routes on this
+	// instance, so that no lock is taken between the lock check of a write
+	// and the write; idempotencyLocks serializes the pushes with the same
+	// Idempotency-Key.
+	pushMu           sync.Mutex
+	idempotencyLocks keyLocks
+
+	// bgCtx is cancelled by Dispose; background jobs started with goBackground
+	// watch it and are waited for before the databases are closed.
+	bgCtx    context.Context
+	bgCancel context.CancelFunc
+	bgWg     sync.WaitGroup
 }
 
 // NewApp creates a new example *App instance.
@@ -47,22 +83,111 @@ func NewApp(ctx context.Context, appSettings backend.AppInstanceSettings) (insta
 
 	r := mux.NewRouter()
 	app.MapglSettings = mapglSettings
+	app.dbs = database.NewPool(database.PoolOptions{
+		IdleTimeout: mapglSettings.DbIdleTimeout,
+		MaxOpen:     mapglSettings.DbMaxOpen,
+		SQLite: database.SQLiteOptions{
+			JournalMode: mapglSettings.SqliteJournalMode,
+			BusyTimeout: mapglSettings.SqliteBusyTimeout,
+			Synchronous: mapglSettings.SqliteSynchronous,
+			CacheSize:   mapglSettings.SqliteCacheSize,
+			Readers:     mapglSettings.SqliteReaders,
+			Writers:     mapglSettings.SqliteWriters,
+		},
+	})
+	if mapglSettings.StorageBackend == settings.StorageRedis {
+		app.redis = resp.NewClient(resp.ClientOptions{
+			Addr:     mapglSettings.RedisAddr,
+			Username: mapglSettings.RedisUsername,
+			Password: mapglSettings.RedisPassword,
+			DB:       mapglSettings.RedisDb,
+		})
+	}
+	app.tiles = newTileCache(tileCacheSize)
+	app.expiring = newExpiryTracker()
+	app.bgCtx, app.bgCancel = context.WithCancel(context.Background())
 	app.registerRoutes(r)
 	app.CallResourceHandler = httpadapter.New(r)
+
+	if mapglSettings.SignalingEnabled {
+		app.signaling = signal.StartSignalingServerSimplePeer(signal.ServerOptions{
+			ApiToken: mapglSettings.ApiToken,
+			Port:     mapglSettings.ApiPort,
+		}, r)
+	}
+
+	if mapglSettings.RespEnabled {
+		if err := app.startRespServer(); err != nil {
+			app.respErr = err
+			log.DefaultLogger.Error("Error starting RESP server", "error", err)
+		}
+	}
+
 	return &app, nil
 }
 
 // Dispose here tells plugin SDK that plugin wants to clean up resources when a new instance
 // created.
 func (a *App) Dispose() {
-	// cleanup
+	log.DefaultLogger.Debug("Disposing mapgl app instance")
+
+	// Cancelling first keeps a RESP server still waiting for its port from
+	// starting after stopRespServer.
+	a.bgCancel()
+
+	if a.signaling != nil {
+		if err := a.signaling.Stop(); err != nil {
+			log.DefaultLogger.Error("Error stopping signaling server", "error", err)
+		}
+	}
+
+	a.stopRespServer()
+
+	a.bgWg.Wait()
+
+	if err := a.dbs.Close(); err != nil {
+		log.DefaultLogger.Error("Error closing seed databases", "error", err)
+	}
+	if a.redis != nil {
+		if err := a.redis.Close(); err != nil {
+			log.DefaultLogger.Error("Error closing redis connections", "error", err)
+		}
+	}
+}
+
+// goBackground runs job in a goroutine that Dispose cancels and waits for.
+func (a *App) goBackground(job func(ctx context.Context)) {
+	a.bgWg.Add(1)
+	go func() {
+		defer a.bgWg.Done()
+		job(a.bgCtx)
+	}()
 }
 
 // CheckHealth handles health checks sent from Grafana to the plugin.
-func (a *App) CheckHealth(_ context.Context, _ *backend.CheckHealthRequest) (*backend.CheckHealthResult, error) {
+// It verifies the seed storage, the license token and the signaling server,
+// and reports every check in JSONDetails for the config page.
+func (a *App) CheckHealth(ctx context.Context, _ *backend.CheckHealthRequest) (*backend.CheckHealthResult, error) {
+	details := healthDetails{
+		DataDir:   a.checkDataDir(),
+		SeedFile:  a.checkSeedFile(),
+		Pool:      a.dbs.Stats(),
+		Storage:   a.checkStorage(ctx),
+		License:   a.checkLicense(),
+		Signaling: a.checkSignaling(),
+		Resp:      a.checkResp(),
+	}
+
+	jsonDetails, err := json.Marshal(details)
+	if err != nil {
+		return nil, err
+	}
+
+	status, message := details.summary()
 	return &backend.CheckHealthResult{
-		Status:  backend.HealthStatusOk,
-		Message: "ok",
+		Status:      status,
+		Message:     message,
+		JSONDetails: jsonDetails,
 	}, nil
 }
 
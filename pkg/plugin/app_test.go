@@ -0,0 +1,134 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mapgl-app/pkg/database"
+	"mapgl-app/pkg/resp"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// TestNewAppDispose replaces instances the way Grafana does on every
+// settings change: it creates the next instance before it disposes the
+// previous one. Dispose must release the seed databases and the ports, and
+// the next instance must take the ports over once they are released.
+func TestNewAppDispose(t *testing.T) {
+	seedDir := t.TempDir()
+	port, respPort := freePort(t), freePort(t)
+
+	var prev *App
+	for i := 0; i < 5; i++ {
+		inst, err := NewApp(context.Background(), backend.AppInstanceSettings{
+			JSONData: []byte(fmt.Sprintf(`{"seedDir":%q,"apiPort":%q,"signalingEnabled":true,"respEnabled":true,"respPort":%q,"respFileName":"dispose"}`,
+				seedDir, port, respPort)),
+			DecryptedSecureJSONData: map[string]string{"respToken": "s3cret"},
+		})
+		if err != nil {
+			t.Fatalf("new app #%d: %s", i, err)
+		}
+		app := inst.(*App)
+
+		var r mockCallResourceResponseSender
+		err = app.CallResource(context.Background(), &backend.CallResourceRequest{
+			Method: http.MethodPost,
+			Path:   "pullEdges",
+			Body:   []byte(`{"fileName":"dispose","minTimestamp":0}`),
+		}, &r)
+		if err != nil {
+			t.Fatalf("CallResource error: %s", err)
+		}
+		if r.response.Status != http.StatusOK {
+			t.Fatalf("pullEdges status should be %d, got %d", http.StatusOK, r.response.Status)
+		}
+		if n := app.dbs.Len(); n != 1 {
+			t.Fatalf("instance #%d should hold 1 open db, got %d", i, n)
+		}
+
+		if prev != nil {
+			prev.Dispose()
+			checkDisposed(t, prev, i-1)
+		}
+		waitListening(t, port)
+		waitResp(t, app, respPort)
+		prev = app
+	}
+
+	prev.Dispose()
+	checkDisposed(t, prev, 4)
+	for _, p := range []string{port, respPort} {
+		ln, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", p))
+		if err != nil {
+			t.Fatalf("port %s still bound after the last Dispose: %s", p, err)
+		}
+		ln.Close()
+	}
+}
+
+func checkDisposed(t *testing.T, app *App, i int) {
+	t.Helper()
+	if n := app.dbs.Len(); n != 0 {
+		t.Errorf("disposed instance #%d still holds %d open dbs", i, n)
+	}
+	if _, err := app.dbs.GetDB(app.seedPath("dispose")); !errors.Is(err, database.ErrPoolClosed) {
+		t.Errorf("GetDB after Dispose should fail with ErrPoolClosed, got %v", err)
+	}
+	if err := app.bgCtx.Err(); !errors.Is(err, context.Canceled) {
+		t.Errorf("background context should be cancelled, got %v", err)
+	}
+	if app.respServer != nil {
+		t.Errorf("disposed instance #%d still serves RESP", i)
+	}
+}
+
+// waitResp waits until app serves RESP on port.
+func waitResp(t *testing.T, app *App, port string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if app.checkResp().Status == healthOk {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("RESP server did not take over port %s: %s", port, app.checkResp().Message)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	client := resp.NewClient(resp.ClientOptions{Addr: net.JoinHostPort("127.0.0.1", port), Password: "s3cret"})
+	defer client.Close()
+	if err := client.Ping(context.Background()); err != nil {
+		t.Fatalf("ping RESP port %s: %s", port, err)
+	}
+}
+
+func freePort(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	defer ln.Close()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	return port
+}
+
+func waitListening(t *testing.T, port string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort("127.0.0.1", port), 100*time.Millisecond)
+		if err == nil {
+			conn.Close()
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("signaling server did not take over port %s: %s", port, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,341 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"mapgl-app/pkg/database"
+	"mapgl-app/pkg/geo"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Changes listed by /diff.
+const (
+	changeAdded    = "added"
+	changeRemoved  = "removed"
+	changeRenamed  = "renamed"
+	changeGeometry = "geometry"
+)
+
+// IdChange is an id that was added, removed or renamed between two seeds.
+type IdChange struct {
+	ID      string `json:"id"`
+	Change  string `json:"change"`
+	Name    string `json:"name,omitempty"`
+	OldName string `json:"oldName,omitempty"`
+}
+
+// EdgeChange is an edge that was added or removed between two seeds, or
+// whose geometry or name changed. Changes lists "geometry" and "renamed"
+// for changed edges, or is the single "added" or "removed".
+type EdgeChange struct {
+	ID      string        `json:"id"`
+	Changes []string      `json:"changes"`
+	Name    string        `json:"name,omitempty"`
+	OldName string        `json:"oldName,omitempty"`
+	ParPath []interface{} `json:"parPath,omitempty"`
+	OldPath []interface{} `json:"oldParPath,omitempty"`
+}
+
+// SeedDiff is the result of /diff, sorted by id.
+type SeedDiff struct {
+	Ids   []IdChange   `json:"ids"`
+	Edges []EdgeChange `json:"edges"`
+}
+
+// seedState is what /diff compares of a seed: the names of its ids and its
+// live edges by id. Tombstones count as removed edges.
+type seedState struct {
+	ids   map[string]string
+	edges map[string][]interface{} // id -> parPath
+}
+
+func loadSeedState(ctx context.Context, store database.Store) (seedState, error) {
+	ids, err := loadIds(ctx, store, 0)
+	if err != nil {
+		return seedState{}, err
+	}
+	edges, err := loadEdges(ctx, store, 0, nil)
+	if err != nil {
+		return seedState{}, err
+	}
+	s := seedState{ids: make(map[string]string, len(ids)), edges: make(map[string][]interface{}, len(edges))}
+	for _, item := range ids {
+		s.ids[item.ID] = item.Name
+	}
+	for _, item := range edges {
+		if deleted, _ := item["_deleted"].(bool); deleted {
+			continue
+		}
+		id, _ := item["id"].(string)
+		parPath, _ := item["parPath"].([]interface{})
+		s.edges[id] = parPath
+	}
+	return s, nil
+}
+
+// diffSeeds lists the changes from base to seed.
+func diffSeeds(base, seed seedState) SeedDiff {
+	diff := SeedDiff{Ids: []IdChange{}, Edges: []EdgeChange{}}
+
+	for id, name := range seed.ids {
+		oldName, ok := base.ids[id]
+		switch {
+		case !ok:
+			diff.Ids = append(diff.Ids, IdChange{ID: id, Change: changeAdded, Name: name})
+		case oldName != name:
+			diff.Ids = append(diff.Ids, IdChange{ID: id, Change: changeRenamed, Name: name, OldName: oldName})
+		}
+	}
+	for id, oldName := range base.ids {
+		if _, ok := seed.ids[id]; !ok {
+			diff.Ids = append(diff.Ids, IdChange{ID: id, Change: changeRemoved, OldName: oldName})
+		}
+	}
+
+	for id, parPath := range seed.edges {
+		oldPath, ok := base.edges[id]
+		if !ok {
+			diff.Edges = append(diff.Edges, EdgeChange{
+				ID: id, Changes: []string{changeAdded}, Name: database.EdgeName(parPath), ParPath: parPath,
+			})
+			continue
+		}
+		var changes []string
+		if !samePoints(geo.PathPoints(oldPath), geo.PathPoints(parPath)) {
+			changes = append(changes, changeGeometry)
+		}
+		name, oldName := database.EdgeName(parPath), database.EdgeName(oldPath)
+		if name != oldName {
+			changes = append(changes, changeRenamed)
+		}
+		if len(changes) > 0 {
+			diff.Edges = append(diff.Edges, EdgeChange{
+				ID: id, Changes: changes, Name: name, OldName: oldName, ParPath: parPath, OldPath: oldPath,
+			})
+		}
+	}
+	for id, oldPath := range base.edges {
+		if _, ok := seed.edges[id]; !ok {
+			diff.Edges = append(diff.Edges, EdgeChange{
+				ID: id, Changes: []string{changeRemoved}, OldName: database.EdgeName(oldPath), OldPath: oldPath,
+			})
+		}
+	}
+
+	sort.Slice(diff.Ids, func(i, j int) bool { return diff.Ids[i].ID < diff.Ids[j].ID })
+	sort.Slice(diff.Edges, func(i, j int) bool { return diff.Edges[i].ID < diff.Edges[j].ID })
+	return diff
+}
+
+func samePoints(a, b []geo.Point) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// diffFeatures returns the changed edges as a GeoJSON FeatureCollection to
+// draw on the map. Features have the new geometry, or the old one for
+// removed edges, and the change as properties; moved edges also carry their
+// old geometry. Ids have no geometry of their own and are left out.
+func diffFeatures(diff SeedDiff) map[string]interface{} {
+	features := []interface{}{}
+	for _, e := range diff.Edges {
+		path := e.ParPath
+		if path == nil {
+			path = e.OldPath
+		}
+		geometry := geo.Geometry(geo.PathPoints(path))
+		if geometry == nil {
+			continue
+		}
+		props := map[string]interface{}{
+			"id":      e.ID,
+			"changes": e.Changes,
+			"name":    e.Name,
+			"oldName": e.OldName,
+		}
+		for _, c := range e.Changes {
+			if c == changeGeometry {
+				props["oldGeometry"] = geo.Geometry(geo.PathPoints(e.OldPath))
+			}
+		}
+		features = append(features, map[string]interface{}{
+			"type":       "Feature",
+			"id":         e.ID,
+			"geometry":   geometry,
+			"properties": props,
+		})
+	}
+	return map[string]interface{}{"type": "FeatureCollection", "features": features}
+}
+
+// openBase opens the side /diff compares against: another seed file, or a
+// backup of a seed file left by a migration or /snapshots.
+func (a *App) openBase(ctx context.Context, base, snapshot string) (database.Store, func(), error) {
+	if snapshot == "" {
+		return a.openStore(ctx, base)
+	}
+	h, err := database.OpenBackup(filepath.Join(a.MapglSettings.SeedDir, snapshot))
+	if err != nil {
+		return nil, nil, err
+	}
+	return h, func() { h.Close() }, nil
+}
+
+// isBackupOf reports whether snapshot names a backup of fileName in the seed
+// dir, e.g. "net.db.v3-20240101T000000.000.bak" or
+// "net.db.snap-20240101T000000.000.bak" for "net".
+func isBackupOf(snapshot, fileName string) bool {
+	return filepath.Base(snapshot) == snapshot &&
+		strings.HasPrefix(snapshot, fileName+".db.") && strings.HasSuffix(snapshot, ".bak")
+}
+
+// handleDiff lists what changed between two seeds, e.g. before promoting a
+// new map version: POST /diff {fileName, base, baseSnapshot, format} with
+// exactly one of base, another seed file, or baseSnapshot, a backup of
+// fileName. Changes go from the base to fileName. Format "geojson" returns
+// the changed edges as a FeatureCollection instead of the SeedDiff.
+func (a *App) handleDiff(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body struct {
+		FileName     string `json:"fileName"`
+		Base         string `json:"base"`
+		BaseSnapshot string `json:"baseSnapshot"`
+		Format       string `json:"format"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if (body.Base == "") == (body.BaseSnapshot == "") {
+		http.Error(w, "exactly one of base and baseSnapshot is required", http.StatusBadRequest)
+		return
+	}
+	if body.BaseSnapshot != "" {
+		if a.redis != nil {
+			http.Error(w, "snapshots need the seed database backend", http.StatusBadRequest)
+			return
+		}
+		if !isBackupOf(body.BaseSnapshot, body.FileName) {
+			http.Error(w, "baseSnapshot must name a backup of "+body.FileName+" in the seed dir", http.StatusBadRequest)
+			return
+		}
+	}
+	if body.Format != "" && body.Format != "json" && body.Format != "geojson" {
+		http.Error(w, `format must be "json" or "geojson"`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, span := startSpan(req.Context(), "diff",
+		attribute.String("fileName", body.FileName),
+		attribute.String("base", body.Base),
+		attribute.String("baseSnapshot", body.BaseSnapshot),
+	)
+	defer span.End()
+
+	store, release, err := a.openStore(ctx, body.FileName)
+	if err != nil {
+		log.DefaultLogger.Error("Failed to get database connection:", "filename", body.FileName, "error", err)
+		http.Error(w, "failed to get database connection", http.StatusInternalServerError)
+		return
+	}
+	defer release()
+
+	baseStore, releaseBase, err := a.openBase(ctx, body.Base, body.BaseSnapshot)
+	if err != nil {
+		log.DefaultLogger.Error("Failed to get database connection:", "base", body.Base, "baseSnapshot", body.BaseSnapshot, "error", err)
+		http.Error(w, "failed to get base database connection", http.StatusInternalServerError)
+		return
+	}
+	defer releaseBase()
+
+	seed, err := loadSeedState(ctx, store)
+	if err != nil {
+		log.DefaultLogger.Error("Failed to read seed", "filename", body.FileName, "error", err)
+		http.Error(w, "failed to read seed", http.StatusInternalServerError)
+		return
+	}
+	base, err := loadSeedState(ctx, baseStore)
+	if err != nil {
+		log.DefaultLogger.Error("Failed to read base seed", "base", body.Base, "baseSnapshot", body.BaseSnapshot, "error", err)
+		http.Error(w, "failed to read base seed", http.StatusInternalServerError)
+		return
+	}
+
+	diff := diffSeeds(base, seed)
+	span.SetAttributes(attribute.Int("ids", len(diff.Ids)), attribute.Int("edges", len(diff.Edges)))
+	if body.Format == "geojson" {
+		writeJSON(ctx, w, diffFeatures(diff))
+		return
+	}
+	writeJSON(ctx, w, diff)
+}
+
+// handleSnapshot copies a seed file to diff it against later, e.g. before
+// editing a new map version: POST /snapshots {fileName}. The response names
+// the copy to pass as the baseSnapshot of /diff.
+func (a *App) handleSnapshot(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body struct {
+		FileName string `json:"fileName"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !a.canWrite {
+		http.Error(w, "snapshots require a power host license", http.StatusForbidden)
+		return
+	}
+	if a.redis != nil {
+		http.Error(w, "snapshots need the seed database backend", http.StatusBadRequest)
+		return
+	}
+	// openDB creates missing seed files.
+	path := a.seedPath(body.FileName)
+	if _, err := os.Stat(path); err != nil {
+		http.Error(w, "seed file "+body.FileName+" not found", http.StatusNotFound)
+		return
+	}
+
+	ctx, span := startSpan(req.Context(), "snapshot", attribute.String("fileName", body.FileName))
+	defer span.End()
+
+	db, err := a.openDB(ctx, body.FileName)
+	if err != nil {
+		log.DefaultLogger.Error("Failed to get database connection:", "filename", body.FileName, "error", err)
+		http.Error(w, "failed to get database connection", http.StatusInternalServerError)
+		return
+	}
+	defer db.Release()
+
+	snapshot, err := db.Snapshot(path)
+	if err != nil {
+		log.DefaultLogger.Error("Failed to take snapshot", "filename", body.FileName, "error", err)
+		http.Error(w, "failed to take snapshot", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(ctx, w, map[string]string{"fileName": body.FileName, "snapshot": filepath.Base(snapshot)})
+}
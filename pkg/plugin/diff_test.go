@@ -0,0 +1,92 @@
+package plugin
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestDiffRoute changes a seed file after taking a snapshot of it, and diffs
+// it against the snapshot, which stays untouched, and against an empty seed
+// file.
+func TestDiffRoute(t *testing.T) {
+	app := newTestApp(t, "")
+
+	callJSON(t, app, "pushIds", `{"fileName":"net","newDocs":[{"tsId":"a","name":"A","updatedAt":1},{"tsId":"b","name":"B","updatedAt":1}]}`, nil)
+	callJSON(t, app, "pushEdges", `{"fileName":"net","newDocs":[
+		{"id":"e1","parPath":["a",[1,1],[2,2],"b"],"updatedAt":1},
+		{"id":"e2","parPath":["b",[2,2],[3,3],"c"],"updatedAt":1},
+		{"id":"e4","parPath":["a",[5,5],"c"],"updatedAt":1}]}`, nil)
+
+	var taken struct {
+		Snapshot string `json:"snapshot"`
+	}
+	callJSON(t, app, "snapshots", `{"fileName":"net"}`, &taken)
+	snapshot := taken.Snapshot
+	if !isBackupOf(snapshot, "net") {
+		t.Fatalf("snapshot should be a backup of net, got %q", snapshot)
+	}
+	if status, _ := call(t, app, "", "snapshots", `{"fileName":"nte"}`); status != http.StatusNotFound {
+		t.Errorf("snapshot of a missing seed file should fail with 404, got %d", status)
+	}
+
+	callJSON(t, app, "pushIds", `{"fileName":"net","newDocs":[{"tsId":"a","name":"A2","updatedAt":2},{"tsId":"d","name":"D","updatedAt":2}]}`, nil)
+	callJSON(t, app, "pushEdges", `{"fileName":"net","newDocs":[
+		{"id":"e1","parPath":["a",[1,1],[2,3],"b"],"updatedAt":2},
+		{"id":"e2","parPath":["b",[2,2],[3,3],"c"],"updatedAt":2,"_deleted":true},
+		{"id":"e3","parPath":["d",[4,4],"a"],"updatedAt":2},
+		{"id":"e4","parPath":["d",[5,5],"c"],"updatedAt":2}]}`, nil)
+
+	var diff SeedDiff
+	callJSON(t, app, "diff", fmt.Sprintf(`{"fileName":"net","baseSnapshot":%q}`, snapshot), &diff)
+	var ids, edges []string
+	for _, c := range diff.Ids {
+		ids = append(ids, c.ID+":"+c.Change)
+	}
+	for _, c := range diff.Edges {
+		edges = append(edges, c.ID+":"+strings.Join(c.Changes, ","))
+	}
+	if got := strings.Join(ids, " "); got != "a:renamed d:added" {
+		t.Errorf("unexpected id changes %q", got)
+	}
+	if got := strings.Join(edges, " "); got != "e1:geometry e2:removed e3:added e4:renamed" {
+		t.Errorf("unexpected edge changes %q", got)
+	}
+	if diff.Ids[0].OldName != "A" || diff.Edges[3].OldName != "c - a" || diff.Edges[3].Name != "c - d" {
+		t.Errorf("changes should carry the old names, got %+v %+v", diff.Ids[0], diff.Edges[3])
+	}
+	if _, err := os.Stat(filepath.Join(app.MapglSettings.SeedDir, snapshot+"-wal")); !os.IsNotExist(err) {
+		t.Errorf("diff should open the snapshot read-only, got a WAL file: %v", err)
+	}
+
+	var fc struct {
+		Type     string `json:"type"`
+		Features []struct {
+			Geometry struct {
+				Type string `json:"type"`
+			} `json:"geometry"`
+			Properties map[string]interface{} `json:"properties"`
+		} `json:"features"`
+	}
+	callJSON(t, app, "diff", `{"fileName":"net","base":"empty","format":"geojson"}`, &fc)
+	if fc.Type != "FeatureCollection" || len(fc.Features) != 3 {
+		t.Fatalf("want 3 live edges added to an empty seed, got %+v", fc)
+	}
+	if f := fc.Features[0]; f.Geometry.Type != "LineString" || f.Properties["id"] != "e1" {
+		t.Errorf("unexpected feature %+v", f)
+	}
+
+	for _, body := range []string{
+		`{"fileName":"net"}`,
+		`{"fileName":"net","base":"empty","baseSnapshot":"` + snapshot + `"}`,
+		`{"fileName":"net","baseSnapshot":"../net.db.v1.bak"}`,
+		`{"fileName":"other","baseSnapshot":"` + snapshot + `"}`,
+	} {
+		if status, _ := call(t, app, "", "diff", body); status != http.StatusBadRequest {
+			t.Errorf("%s: want 400, got %d", body, status)
+		}
+	}
+}
@@ -0,0 +1,511 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mapgl-app/pkg/database"
+	"math"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Metadata keys of a draft: the seed file it was forked from, and the
+// revisions of the draft and of that seed file when they were last in sync,
+// at the fork or the last merge, by objectField.
+const (
+	draftOfKey        = "_draftOf"
+	syncedRevsKey     = "_syncedRevs"
+	syncedLiveRevsKey = "_syncedLiveRevs"
+)
+
+// Conflict resolutions of /drafts/merge.
+const (
+	takeDraft = "draft"
+	takeLive  = "live"
+)
+
+// DraftConflict is an id or edge changed both in a draft and in its seed
+// file since their last sync, to different values. Draft and Live are the names of
+// an id, or the parPath, _deleted, isEph and props of an edge.
+type DraftConflict struct {
+	Kind       string      `json:"kind"` // "id" or "edge"
+	Key        string      `json:"key"`
+	Draft      interface{} `json:"draft"`
+	Live       interface{} `json:"live"`
+	Resolution string      `json:"resolution,omitempty"`
+}
+
+// DraftMerge is the result of /drafts/merge: the ids and edges written to the
+// seed file, or that would be in a preview, and the conflicts found.
+type DraftMerge struct {
+	FileName  string          `json:"fileName"`
+	Draft     string          `json:"draft"`
+	Preview   bool            `json:"preview"`
+	Ids       []string        `json:"ids"`
+	Edges     []string        `json:"edges"`
+	Conflicts []DraftConflict `json:"conflicts"`
+	// UpdatedAt is the score of the merged documents, zero in a preview.
+	UpdatedAt float64 `json:"updatedAt,omitempty"`
+}
+
+// edgeDoc returns what a merge compares and copies of an edge hash.
+func edgeDoc(fields map[string]string) map[string]interface{} {
+	var parPath []interface{}
+	_ = json.Unmarshal([]byte(fields["parPath"]), &parPath)
+	deleted, _ := strconv.ParseBool(fields["deleted"])
+	isEph, _ := strconv.ParseBool(fields["isEph"])
+	props, _ := database.ParseProps(fields)
+	return map[string]interface{}{"parPath": parPath, "_deleted": deleted, "isEph": isEph, "props": props}
+}
+
+// changedSince returns the ids and edges of store whose revision differs
+// from the one recorded under syncedKey in draft, i.e. those written since
+// the draft was last in sync. Revisions count server-side writes, so client
+// clocks don't matter.
+func changedSince(ctx context.Context, store, draft database.Store, syncedKey string) (ids, edges map[string]bool, err error) {
+	revs, err := loadRevs(ctx, store)
+	if err != nil {
+		return nil, nil, err
+	}
+	synced, err := readRevs(ctx, draft, syncedKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	ids, edges = map[string]bool{}, map[string]bool{}
+	for field, rev := range revs {
+		if synced[field] == rev {
+			continue
+		}
+		switch kind, key, _ := strings.Cut(field, ":"); kind {
+		case "id":
+			ids[key] = true
+		case "edge":
+			edges[key] = true
+		}
+	}
+	return ids, edges, nil
+}
+
+// markSynced records the current revisions of draft and live in draft as
+// those of their last sync. Revisions only grow, so overwriting the fields
+// is enough.
+func markSynced(ctx context.Context, draft, live database.Store) error {
+	for key, store := range map[string]database.Store{syncedRevsKey: draft, syncedLiveRevsKey: live} {
+		revs, err := loadRevs(ctx, store)
+		if err != nil {
+			return err
+		}
+		if len(revs) == 0 {
+			continue
+		}
+		values := make(map[string]any, len(revs))
+		for field, rev := range revs {
+			values[field] = strconv.FormatInt(rev, 10)
+		}
+		if err := draft.HSet(ctx, key, values); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// forkSeed copies the ids and edges of src into the empty seed dst with
+// their scores, and records dst as a draft of srcName in sync with it.
+// Ephemeral edges of the draft expire EphemeralTtl after the fork.
+func (a *App) forkSeed(ctx context.Context, src, dst database.Store, srcName, dstName string) (ids, edges int, err error) {
+	idItems, err := src.ZRangeByScore(ctx, database.IdsIndex, math.Inf(-1), math.Inf(1))
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, item := range idItems {
+		name, err := src.Get(ctx, item.Member)
+		if errors.Is(err, database.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return ids, edges, err
+		}
+		if err := dst.Set(ctx, item.Member, name); err != nil {
+			return ids, edges, err
+		}
+		if err := dst.ZAdd(ctx, database.IdsIndex, item.Member, item.Score); err != nil {
+			return ids, edges, err
+		}
+		if err := a.idWritten(ctx, dst, item.Member, name, item.Score); err != nil {
+			return ids, edges, err
+		}
+		ids++
+	}
+
+	edgeItems, err := src.ZRangeByScore(ctx, database.EdgesIndex, math.Inf(-1), math.Inf(1))
+	if err != nil {
+		return ids, edges, err
+	}
+	for _, item := range edgeItems {
+		fields, err := src.HGetAll(ctx, item.Member)
+		if err != nil {
+			return ids, edges, err
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		values := make(map[string]any, len(fields))
+		for field, val := range fields {
+			values[field] = val
+		}
+		if err := dst.HSet(ctx, item.Member, values); err != nil {
+			return ids, edges, err
+		}
+		doc := edgeDoc(fields)
+		if err := a.edgeWritten(ctx, dst, dstName, item.Member, doc["parPath"].([]interface{}), item.Score); err != nil {
+			return ids, edges, err
+		}
+		if err := dst.ZAdd(ctx, database.EdgesIndex, item.Member, item.Score); err != nil {
+			return ids, edges, err
+		}
+		edges++
+	}
+
+	if err := dst.Set(ctx, draftOfKey, srcName); err != nil {
+		return ids, edges, err
+	}
+	return ids, edges, markSynced(ctx, dst, src)
+}
+
+// mergeDraft merges the ids and edges changed in a draft since its last
+// sync into live. Those also changed in live since, to a different value,
+// are conflicts settled by onConflict: "draft" or "live" wins, or with ""
+// the merge stops before writing anything. A preview only plans the merge.
+// Merged documents are written with a new score so that clients of live
+// pull them. Merges run under pushMu, and fail with the message of
+// checkLocks when a document to write is locked by someone but user.
+func (a *App) mergeDraft(ctx context.Context, draft, live database.Store, liveName, user, onConflict string, preview bool) (DraftMerge, string, error) {
+	merge := DraftMerge{Preview: preview, Ids: []string{}, Edges: []string{}, Conflicts: []DraftConflict{}}
+	if !preview {
+		a.pushMu.Lock()
+		defer a.pushMu.Unlock()
+	}
+
+	draftIds, draftEdges, err := changedSince(ctx, draft, draft, syncedRevsKey)
+	if err != nil {
+		return merge, "", err
+	}
+	liveIds, liveEdges, err := changedSince(ctx, live, draft, syncedLiveRevsKey)
+	if err != nil {
+		return merge, "", err
+	}
+
+	// Plan: what to write, by key, in sorted order.
+	names := map[string]string{}
+	for _, key := range sortedSet(draftIds) {
+		name, err := draft.Get(ctx, key)
+		if errors.Is(err, database.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return merge, "", err
+		}
+		if liveIds[key] {
+			liveName, err := live.Get(ctx, key)
+			if err != nil && !errors.Is(err, database.ErrNotFound) {
+				return merge, "", err
+			}
+			if liveName == name {
+				continue
+			}
+			merge.Conflicts = append(merge.Conflicts, DraftConflict{Kind: "id", Key: key, Draft: name, Live: liveName, Resolution: onConflict})
+			if onConflict != takeDraft {
+				continue
+			}
+		}
+		names[key] = name
+		merge.Ids = append(merge.Ids, key)
+	}
+
+	docs := map[string]map[string]string{}
+	for _, key := range sortedSet(draftEdges) {
+		fields, err := draft.HGetAll(ctx, key)
+		if err != nil {
+			return merge, "", err
+		}
+		if liveEdges[key] {
+			liveFields, err := live.HGetAll(ctx, key)
+			if err != nil {
+				return merge, "", err
+			}
+			draftDoc, liveDoc := edgeDoc(fields), edgeDoc(liveFields)
+			if reflect.DeepEqual(draftDoc, liveDoc) {
+				continue
+			}
+			merge.Conflicts = append(merge.Conflicts, DraftConflict{Kind: "edge", Key: key, Draft: draftDoc, Live: liveDoc, Resolution: onConflict})
+			if onConflict != takeDraft {
+				continue
+			}
+		}
+		docs[key] = fields
+		merge.Edges = append(merge.Edges, key)
+	}
+
+	if preview || (onConflict == "" && len(merge.Conflicts) > 0) {
+		return merge, "", nil
+	}
+	for kind, keys := range map[string][]string{"id": merge.Ids, "edge": merge.Edges} {
+		if msg, err := checkLocks(ctx, live, kind, user, keys); err != nil || msg != "" {
+			return merge, msg, err
+		}
+	}
+
+	score := nowScore()
+	for _, key := range merge.Ids {
+		if err := live.Set(ctx, key, names[key]); err != nil {
+			return merge, "", fmt.Errorf("merge id %s: %w", key, err)
+		}
+		if err := live.ZAdd(ctx, database.IdsIndex, key, score); err != nil {
+			return merge, "", fmt.Errorf("merge id %s: %w", key, err)
+		}
+		if err := a.idWritten(ctx, live, key, names[key], score); err != nil {
+			return merge, "", fmt.Errorf("merge id %s: %w", key, err)
+		}
+	}
+	for _, key := range merge.Edges {
+		fields := docs[key]
+		doc := edgeDoc(fields)
+		parPath := doc["parPath"].([]interface{})
+		values := map[string]any{
+			"deleted": doc["_deleted"],
+			"parPath": fields["parPath"],
+		}
+		// Like pushes, leave the optional fields the draft doesn't have.
+		for _, field := range []string{"isEph", database.PropsField} {
+			if val, ok := fields[field]; ok {
+				values[field] = val
+			}
+		}
+		for field, val := range database.EdgeMetrics(parPath) {
+			values[field] = val
+		}
+		if err := live.HSet(ctx, key, values); err != nil {
+			return merge, "", fmt.Errorf("merge edge %s: %w", key, err)
+		}
+		if err := a.edgeWritten(ctx, live, liveName, key, parPath, score); err != nil {
+			return merge, "", fmt.Errorf("merge edge %s: %w", key, err)
+		}
+		if err := live.ZAdd(ctx, database.EdgesIndex, key, score); err != nil {
+			return merge, "", fmt.Errorf("merge edge %s: %w", key, err)
+		}
+	}
+
+	// The draft is now in sync with live.
+	if err := markSynced(ctx, draft, live); err != nil {
+		return merge, "", err
+	}
+	merge.UpdatedAt = score
+	return merge, "", nil
+}
+
+func sortedSet(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// handleForkDraft forks a seed file into a draft, a new seed file that is
+// pulled and pushed like any other and merged back with /drafts/merge:
+// POST /drafts/fork {fileName, draft}. The seed file must have ids or edges,
+// the draft none yet.
+func (a *App) handleForkDraft(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body struct {
+		FileName string `json:"fileName"`
+		Draft    string `json:"draft"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !a.canWrite {
+		http.Error(w, "drafts require a power host license", http.StatusForbidden)
+		return
+	}
+	if body.Draft == "" || body.Draft == body.FileName {
+		http.Error(w, "draft must name another seed file", http.StatusBadRequest)
+		return
+	}
+
+	ctx, span := startSpan(req.Context(), "forkDraft",
+		attribute.String("fileName", body.FileName),
+		attribute.String("draft", body.Draft),
+	)
+	defer span.End()
+
+	src, release, err := a.openStore(ctx, body.FileName)
+	if err != nil {
+		log.DefaultLogger.Error("Failed to get database connection:", "filename", body.FileName, "error", err)
+		http.Error(w, "failed to get database connection", http.StatusInternalServerError)
+		return
+	}
+	defer release()
+	dst, releaseDraft, err := a.openStore(ctx, body.Draft)
+	if err != nil {
+		log.DefaultLogger.Error("Failed to get database connection:", "filename", body.Draft, "error", err)
+		http.Error(w, "failed to get database connection", http.StatusInternalServerError)
+		return
+	}
+	defer releaseDraft()
+
+	// openStore creates missing seed files, so a misspelled fileName
+	// would fork an empty draft.
+	empty := true
+	for _, index := range []string{database.IdsIndex, database.EdgesIndex} {
+		items, err := src.ZRangeByScore(ctx, index, math.Inf(-1), math.Inf(1))
+		if err != nil {
+			log.DefaultLogger.Error("Failed to read seed file", "filename", body.FileName, "error", err)
+			http.Error(w, "failed to read seed file", http.StatusInternalServerError)
+			return
+		}
+		empty = empty && len(items) == 0
+	}
+	if empty {
+		http.Error(w, "seed file "+body.FileName+" has no ids or edges to fork", http.StatusNotFound)
+		return
+	}
+
+	for _, index := range []string{database.IdsIndex, database.EdgesIndex} {
+		items, err := dst.ZRangeByScore(ctx, index, math.Inf(-1), math.Inf(1))
+		if err != nil {
+			log.DefaultLogger.Error("Failed to read draft", "filename", body.Draft, "error", err)
+			http.Error(w, "failed to read draft", http.StatusInternalServerError)
+			return
+		}
+		if len(items) > 0 {
+			http.Error(w, "seed file "+body.Draft+" already exists", http.StatusConflict)
+			return
+		}
+	}
+
+	// Pushes to the seed file wait, so that the draft is in sync with the
+	// revisions recorded for it.
+	a.pushMu.Lock()
+	forkedAt := nowScore()
+	ids, edges, err := a.forkSeed(ctx, src, dst, body.FileName, body.Draft)
+	a.pushMu.Unlock()
+	span.SetAttributes(attribute.Int("ids", ids), attribute.Int("edges", edges))
+	if err != nil {
+		log.DefaultLogger.Error("Failed to fork seed file", "filename", body.FileName, "draft", body.Draft, "error", err)
+		http.Error(w, "failed to fork seed file", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(ctx, w, map[string]interface{}{
+		"fileName": body.FileName,
+		"draft":    body.Draft,
+		"forkedAt": forkedAt,
+		"ids":      ids,
+		"edges":    edges,
+	})
+}
+
+// handleMergeDraft merges a draft back into the seed file it was forked
+// from: POST /drafts/merge {draft, preview, onConflict}. Ids and edges
+// changed in both since the fork or the last merge are conflicts, settled
+// with onConflict "draft" or "live"; without it a merge with conflicts
+// fails with 409 and the DraftMerge listing them. A merge writing an id or
+// edge locked by another user fails with 409 too. A preview returns the
+// plan without writing.
+func (a *App) handleMergeDraft(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body struct {
+		Draft      string `json:"draft"`
+		Preview    bool   `json:"preview"`
+		OnConflict string `json:"onConflict"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if body.OnConflict != "" && body.OnConflict != takeDraft && body.OnConflict != takeLive {
+		http.Error(w, `onConflict must be "draft" or "live"`, http.StatusBadRequest)
+		return
+	}
+	if !body.Preview && !a.canWrite {
+		http.Error(w, "drafts require a power host license", http.StatusForbidden)
+		return
+	}
+
+	ctx, span := startSpan(req.Context(), "mergeDraft",
+		attribute.String("draft", body.Draft),
+		attribute.Bool("preview", body.Preview),
+	)
+	defer span.End()
+
+	draft, release, err := a.openStore(ctx, body.Draft)
+	if err != nil {
+		log.DefaultLogger.Error("Failed to get database connection:", "filename", body.Draft, "error", err)
+		http.Error(w, "failed to get database connection", http.StatusInternalServerError)
+		return
+	}
+	defer release()
+
+	liveName, err := draft.Get(ctx, draftOfKey)
+	if errors.Is(err, database.ErrNotFound) {
+		http.Error(w, body.Draft+" is not a draft", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.DefaultLogger.Error("Failed to read draft", "filename", body.Draft, "error", err)
+		http.Error(w, "failed to read draft", http.StatusInternalServerError)
+		return
+	}
+
+	live, releaseLive, err := a.openStore(ctx, liveName)
+	if err != nil {
+		log.DefaultLogger.Error("Failed to get database connection:", "filename", liveName, "error", err)
+		http.Error(w, "failed to get database connection", http.StatusInternalServerError)
+		return
+	}
+	defer releaseLive()
+
+	merge, msg, err := a.mergeDraft(ctx, draft, live, liveName, requestUser(ctx), body.OnConflict, body.Preview)
+	merge.FileName, merge.Draft = liveName, body.Draft
+	span.SetAttributes(
+		attribute.Int("ids", len(merge.Ids)),
+		attribute.Int("edges", len(merge.Edges)),
+		attribute.Int("conflicts", len(merge.Conflicts)),
+	)
+	if err != nil {
+		log.DefaultLogger.Error("Failed to merge draft", "filename", liveName, "draft", body.Draft, "error", err)
+		http.Error(w, "failed to merge draft", http.StatusInternalServerError)
+		return
+	}
+	if msg != "" {
+		http.Error(w, msg, http.StatusConflict)
+		return
+	}
+
+	if !body.Preview && merge.UpdatedAt == 0 {
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		_ = json.NewEncoder(w).Encode(merge)
+		return
+	}
+	writeJSON(ctx, w, merge)
+}
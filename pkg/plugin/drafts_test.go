@@ -0,0 +1,105 @@
+package plugin
+
+import (
+	"context"
+	"mapgl-app/pkg/database"
+	"math"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestDrafts forks a seed file, edits both copies from clients with stale
+// clocks, and merges the draft back: first as a preview, then refused for a
+// conflict and for a lock, then with the draft winning.
+func TestDrafts(t *testing.T) {
+	app := newTestApp(t, "")
+
+	callJSON(t, app, "pushIds", `{"fileName":"live","newDocs":[{"tsId":"a","name":"A","updatedAt":1}]}`, nil)
+	callJSON(t, app, "pushEdges", `{"fileName":"live","newDocs":[
+		{"id":"e1","parPath":["a",[1,1],[2,2],"b"],"updatedAt":1},
+		{"id":"e2","parPath":["b",[2,2],[3,3],"c"],"updatedAt":1}]}`, nil)
+
+	var fork struct {
+		ForkedAt   float64 `json:"forkedAt"`
+		Ids, Edges int
+	}
+	callJSON(t, app, "drafts/fork", `{"fileName":"live","draft":"reroute"}`, &fork)
+	if fork.Ids != 1 || fork.Edges != 2 {
+		t.Fatalf("fork should copy 1 id and 2 edges, got %+v", fork)
+	}
+	if status, _ := call(t, app, "", "drafts/fork", `{"fileName":"live","draft":"reroute"}`); status != http.StatusConflict {
+		t.Errorf("forking into an existing seed should conflict, got %d", status)
+	}
+	if status, _ := call(t, app, "", "drafts/fork", `{"fileName":"lvie","draft":"typo"}`); status != http.StatusNotFound {
+		t.Errorf("forking an empty seed should fail with 404, got %d", status)
+	}
+
+	// The clients' updatedAt predate the fork; revisions still tell the
+	// changes.
+	callJSON(t, app, "pushIds", `{"fileName":"reroute","newDocs":[{"tsId":"d","name":"D","updatedAt":2}]}`, nil)
+	callJSON(t, app, "pushEdges", `{"fileName":"reroute","newDocs":[
+		{"id":"e1","parPath":["a",[1,1],[5,5],"b"],"updatedAt":2},
+		{"id":"e3","parPath":["d",[4,4],"a"],"updatedAt":2}]}`, nil)
+	callJSON(t, app, "pushEdges", `{"fileName":"live","newDocs":[
+		{"id":"e1","parPath":["a",[1,1],[6,6],"b"],"props":{"owner":"ops"},"updatedAt":2},
+		{"id":"e2","parPath":["b",[2,2],[3,4],"c"],"updatedAt":2}]}`, nil)
+
+	var merge DraftMerge
+	callJSON(t, app, "drafts/merge", `{"draft":"reroute","preview":true}`, &merge)
+	if merge.FileName != "live" || strings.Join(merge.Ids, ",") != "d" || strings.Join(merge.Edges, ",") != "e3" {
+		t.Errorf("unexpected preview %+v", merge)
+	}
+	if len(merge.Conflicts) != 1 || merge.Conflicts[0].Key != "e1" || merge.UpdatedAt != 0 {
+		t.Errorf("preview should find the conflict on e1, got %+v", merge.Conflicts)
+	}
+
+	if status, body := call(t, app, "", "drafts/merge", `{"draft":"reroute"}`); status != http.StatusConflict || !strings.Contains(body, `"e1"`) {
+		t.Errorf("merge with conflicts should fail with 409, got %d %s", status, body)
+	}
+
+	lock := `{"fileName":"live","kind":"edge","key":"e1"}`
+	if status, body := call(t, app, "alice", "locks/acquire", lock); status != http.StatusOK {
+		t.Fatalf("lock: %d %s", status, body)
+	}
+	if status, body := call(t, app, "bob", "drafts/merge", `{"draft":"reroute","onConflict":"draft"}`); status != http.StatusConflict || !strings.Contains(body, "locked by alice") {
+		t.Errorf("merge over alice's lock should fail with 409, got %d %s", status, body)
+	}
+	if status, body := call(t, app, "alice", "locks/release", lock); status != http.StatusOK {
+		t.Fatalf("unlock: %d %s", status, body)
+	}
+
+	callJSON(t, app, "drafts/merge", `{"draft":"reroute","onConflict":"draft"}`, &merge)
+	if strings.Join(merge.Edges, ",") != "e1,e3" || merge.UpdatedAt <= fork.ForkedAt {
+		t.Fatalf("merge should write e1 and e3 with a new score, got %+v", merge)
+	}
+
+	db, err := app.openDB(context.Background(), "live")
+	if err != nil {
+		t.Fatalf("open: %s", err)
+	}
+	defer db.Release()
+	fields, _ := db.HGetAll(context.Background(), "e1")
+	if fields["parPath"] != `["a",[1,1],[5,5],"b"]` {
+		t.Errorf("draft should win e1, got %s", fields["parPath"])
+	}
+	if _, ok := fields["isEph"]; ok || fields[database.PropsField] != `{"owner":"ops"}` {
+		t.Errorf("merge should keep the fields the draft doesn't have, got %v", fields)
+	}
+	items, _ := db.ZRangeByScore(context.Background(), database.EdgesIndex, merge.UpdatedAt, math.Inf(1))
+	if len(items) != 2 {
+		t.Errorf("merged edges should be pulled after the merge, got %+v", items)
+	}
+	if name, _ := db.Get(context.Background(), "d"); name != "D" {
+		t.Errorf("id d should be merged, got %q", name)
+	}
+
+	// Nothing changed in the draft since the merge.
+	callJSON(t, app, "drafts/merge", `{"draft":"reroute"}`, &merge)
+	if len(merge.Ids)+len(merge.Edges)+len(merge.Conflicts) != 0 {
+		t.Errorf("second merge should be empty, got %+v", merge)
+	}
+	if status, _ := call(t, app, "", "drafts/merge", `{"draft":"live"}`); status != http.StatusBadRequest {
+		t.Errorf("merging a seed that is no draft should fail with 400, got %d", status)
+	}
+}
@@ -0,0 +1,197 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mapgl-app/pkg/database"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// expireInterval is how often the background job looks for expired
+// ephemeral edges.
+const expireInterval = time.Minute
+
+// expiryTracker remembers the seed files with ephemeral edges and when the
+// first of them expires, so that the background job only opens files with
+// work to do. Files whose edges were pushed before a restart are scheduled
+// again by their next pull.
+type expiryTracker struct {
+	mu   sync.Mutex
+	next map[string]float64 // fileName -> earliest expiry in ms
+}
+
+func newExpiryTracker() *expiryTracker {
+	return &expiryTracker{next: make(map[string]float64)}
+}
+
+// schedule records that fileName has an edge expiring at expiresAt.
+func (t *expiryTracker) schedule(fileName string, expiresAt float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if next, ok := t.next[fileName]; !ok || expiresAt < next {
+		t.next[fileName] = expiresAt
+	}
+}
+
+// reset replaces the earliest expiry of fileName after a sweep; zero means
+// there are no ephemeral edges left.
+func (t *expiryTracker) reset(fileName string, next float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if next == 0 {
+		delete(t.next, fileName)
+	} else {
+		t.next[fileName] = next
+	}
+}
+
+// due returns the files with edges expired at now.
+func (t *expiryTracker) due(now float64) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var files []string
+	for fileName, next := range t.next {
+		if next <= now {
+			files = append(files, fileName)
+		}
+	}
+	return files
+}
+
+// scheduleExpiry sets or clears the expiry of an edge after a write, from
+// its merged hash fields: live ephemeral edges expire EphemeralTtl after
+// they were last written, other edges never. fields is updated in place.
+func (a *App) scheduleExpiry(ctx context.Context, store database.Store, fileName, id string, fields map[string]string) error {
+	isEph, _ := strconv.ParseBool(fields["isEph"])
+	deleted, _ := strconv.ParseBool(fields["deleted"])
+	ttl := a.MapglSettings.EphemeralTtl
+
+	if isEph && !deleted && ttl > 0 {
+		expiresAt := nowScore() + float64(ttl.Milliseconds())
+		if err := store.HSet(ctx, id, map[string]any{database.ExpiresAtField: expiresAt}); err != nil {
+			return err
+		}
+		if err := store.ZAdd(ctx, database.ExpiryIndex, id, expiresAt); err != nil {
+			return err
+		}
+		fields[database.ExpiresAtField] = strconv.FormatFloat(expiresAt, 'f', -1, 64)
+		a.expiring.schedule(fileName, expiresAt)
+		return nil
+	}
+
+	if s := fields[database.ExpiresAtField]; s == "" || s == "0" {
+		return nil
+	}
+	if err := store.HSet(ctx, id, map[string]any{database.ExpiresAtField: 0}); err != nil {
+		return err
+	}
+	fields[database.ExpiresAtField] = "0"
+	return store.ZRem(ctx, database.ExpiryIndex, id)
+}
+
+// expireEdges turns the ephemeral edges of a seed file whose TTL has passed
+// into tombstones, re-scored in lastEdges so that replicating clients pull
+// the removal. It runs under pushMu, so an edge renewed by a push is seen
+// with its new expiry. It returns the number of expired edges.
+func (a *App) expireEdges(ctx context.Context, store database.Store, fileName string) (int, error) {
+	ctx, span := startSpan(ctx, "expireEdges", attribute.String("fileName", fileName))
+	defer span.End()
+
+	a.pushMu.Lock()
+	defer a.pushMu.Unlock()
+
+	now := nowScore()
+	due, err := store.ZRangeByScore(ctx, database.ExpiryIndex, math.Inf(-1), now)
+	if err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for _, m := range due {
+		fields, err := store.HGetAll(ctx, m.Member)
+		if err != nil {
+			return expired, err
+		}
+		if deleted, _ := strconv.ParseBool(fields["deleted"]); deleted || len(fields) == 0 {
+			// Deleted by a client since it was scheduled.
+			if err := store.ZRem(ctx, database.ExpiryIndex, m.Member); err != nil {
+				return expired, err
+			}
+			continue
+		}
+		// The hash has the current expiry; a stale index entry must not
+		// delete an edge that was renewed or made permanent.
+		expiresAt, _ := strconv.ParseFloat(fields[database.ExpiresAtField], 64)
+		if expiresAt > now {
+			continue
+		}
+		if expiresAt == 0 {
+			if err := store.ZRem(ctx, database.ExpiryIndex, m.Member); err != nil {
+				return expired, err
+			}
+			continue
+		}
+
+		if err := store.HSet(ctx, m.Member, map[string]any{"deleted": true}); err != nil {
+			return expired, fmt.Errorf("expire %s: %w", m.Member, err)
+		}
+		var parPath []interface{}
+		_ = json.Unmarshal([]byte(fields["parPath"]), &parPath)
+		score := nowScore()
+		if err := a.edgeWritten(ctx, store, fileName, m.Member, parPath, score); err != nil {
+			return expired, fmt.Errorf("expire %s: %w", m.Member, err)
+		}
+		if err := store.ZAdd(ctx, database.EdgesIndex, m.Member, score); err != nil {
+			return expired, fmt.Errorf("expire %s: %w", m.Member, err)
+		}
+		expired++
+	}
+
+	rest, err := store.ZRangeByScore(ctx, database.ExpiryIndex, math.Inf(-1), math.Inf(1))
+	if err != nil {
+		return expired, err
+	}
+	if len(rest) > 0 {
+		a.expiring.reset(fileName, rest[0].Score)
+	} else {
+		a.expiring.reset(fileName, 0)
+	}
+	span.SetAttributes(attribute.Int("expired", expired))
+	return expired, nil
+}
+
+// expireLoop periodically expires the ephemeral edges of the seed files the
+// tracker knows about, until ctx is cancelled. It only runs on instances
+// that may write, see registerWriteRoutes.
+func (a *App) expireLoop(ctx context.Context) {
+	ticker := time.NewTicker(expireInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, fileName := range a.expiring.due(nowScore()) {
+			store, release, err := a.openStore(ctx, fileName)
+			if err != nil {
+				log.DefaultLogger.Error("Failed to get database connection:", "filename", fileName, "error", err)
+				continue
+			}
+			n, err := a.expireEdges(ctx, store, fileName)
+			release()
+			if err != nil {
+				log.DefaultLogger.Error("Failed to expire ephemeral edges", "filename", fileName, "error", err)
+			} else if n > 0 {
+				log.DefaultLogger.Info("Expired ephemeral edges", "filename", fileName, "count", n)
+			}
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package plugin
+
+import (
+	"context"
+	"mapgl-app/pkg/database"
+	"math"
+	"testing"
+	"time"
+)
+
+// TestEphemeralExpiry checks that pulls leave ephemeral edges out once their
+// TTL has passed, that expireEdges turns them into tombstones with a new
+// score, and that other edges are kept.
+func TestEphemeralExpiry(t *testing.T) {
+	app := newTestApp(t, `"ephemeralTtl":"50ms"`)
+
+	callJSON(t, app, "pushEdges", `{"fileName":"eph","newDocs":[
+		{"id":"probe","parPath":["b",[0,0],[0,1],"a"],"updatedAt":10,"isEph":true},
+		{"id":"kept","parPath":["c",[0,0],[1,0],"a"],"updatedAt":10,"isEph":true},
+		{"id":"cable","parPath":["d",[0,0],[1,1],"a"],"updatedAt":10}
+	]}`, nil)
+	// kept is made permanent again before it expires.
+	callJSON(t, app, "pushEdges", `{"fileName":"eph","newDocs":[{"id":"kept","parPath":["c",[0,0],[1,0],"a"],"updatedAt":20,"isEph":false}]}`, nil)
+
+	if files := app.expiring.due(math.Inf(1)); len(files) != 1 || files[0] != "eph" {
+		t.Errorf("the background job should know about eph, got %v", files)
+	}
+
+	var edges []map[string]interface{}
+	callJSON(t, app, "pullEdges", `{"fileName":"eph","minTimestamp":0}`, &edges)
+	for _, e := range edges {
+		if e["id"] == "probe" && (e["_deleted"] != false || e["expiresAt"] == nil) {
+			t.Errorf("probe should be live with an expiry before its TTL, got %v", e)
+		}
+	}
+
+	time.Sleep(60 * time.Millisecond)
+
+	// Pulls leave the expired edge out without writing.
+	edges = nil
+	callJSON(t, app, "pullEdges", `{"fileName":"eph","minTimestamp":0}`, &edges)
+	if len(edges) != 2 {
+		t.Fatalf("pull should leave out the expired edge, got %v", edges)
+	}
+
+	ctx := context.Background()
+	db, err := app.openDB(ctx, "eph")
+	if err != nil {
+		t.Fatalf("open: %s", err)
+	}
+	defer db.Release()
+	before := nowScore()
+	if n, err := app.expireEdges(ctx, db, "eph"); err != nil || n != 1 {
+		t.Fatalf("want 1 expired edge, got %d, %v", n, err)
+	}
+
+	edges = nil
+	callJSON(t, app, "pullEdges", `{"fileName":"eph","minTimestamp":0}`, &edges)
+	if len(edges) != 3 {
+		t.Fatalf("want 3 edges, got %v", edges)
+	}
+	for _, e := range edges {
+		deleted := e["_deleted"].(bool)
+		switch e["id"] {
+		case "probe":
+			if !deleted || e["updatedAt"].(float64) < before || e["expiresAt"] != nil {
+				t.Errorf("probe should be a fresh tombstone without expiry, got %v", e)
+			}
+		default:
+			if deleted {
+				t.Errorf("%s should not expire, got %v", e["id"], e)
+			}
+		}
+	}
+	if files := app.expiring.due(math.Inf(1)); len(files) != 0 {
+		t.Errorf("nothing is left to expire, got %v", files)
+	}
+	if left, err := db.ZRangeByScore(ctx, database.ExpiryIndex, math.Inf(-1), math.Inf(1)); err != nil || len(left) != 0 {
+		t.Errorf("expiry index should be empty, got %v, %v", left, err)
+	}
+}
@@ -0,0 +1,135 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"mapgl-app/pkg/database"
+	"net/http"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// idsFrame returns ids as a frame with id, name and updatedAt fields.
+func idsFrame(items []RedisIdItem) *data.Frame {
+	ids := make([]string, len(items))
+	names := make([]string, len(items))
+	updated := make([]time.Time, len(items))
+	for i, item := range items {
+		ids[i], names[i] = item.ID, item.Name
+		updated[i] = time.UnixMilli(int64(item.Score))
+	}
+	return data.NewFrame("ids",
+		data.NewField("id", nil, ids),
+		data.NewField("name", nil, names),
+		data.NewField("updatedAt", nil, updated),
+	)
+}
+
+// edgesFrame returns edges in the pullEdges format as a frame with id,
+// name, updatedAt, deleted, length and props fields. Edges are named after
+// their end nodes; props are JSON, null for edges without.
+func edgesFrame(items []map[string]interface{}) *data.Frame {
+	ids := make([]string, len(items))
+	names := make([]string, len(items))
+	updated := make([]time.Time, len(items))
+	deleted := make([]bool, len(items))
+	lengths := make([]float64, len(items))
+	props := make([]*json.RawMessage, len(items))
+	for i, item := range items {
+		ids[i], _ = item["id"].(string)
+		parPath, _ := item["parPath"].([]interface{})
+		names[i] = database.EdgeName(parPath)
+		score, _ := item["updatedAt"].(float64)
+		updated[i] = time.UnixMilli(int64(score))
+		deleted[i], _ = item["_deleted"].(bool)
+		lengths[i], _ = item["length"].(float64)
+		if p, ok := item["props"]; ok {
+			b, _ := json.Marshal(p)
+			raw := json.RawMessage(b)
+			props[i] = &raw
+		}
+	}
+	length := data.NewField("length", nil, lengths).SetConfig(&data.FieldConfig{Unit: "lengthm"})
+	return data.NewFrame("edges",
+		data.NewField("id", nil, ids),
+		data.NewField("name", nil, names),
+		data.NewField("updatedAt", nil, updated),
+		data.NewField("deleted", nil, deleted),
+		length,
+		data.NewField("props", nil, props),
+	)
+}
+
+// writeFrames writes frames in the data frame JSON format, or with format
+// "arrow" as a JSON array of base64 encoded Arrow IPC files, one per frame.
+func writeFrames(ctx context.Context, w http.ResponseWriter, frames data.Frames, format string) {
+	if format != "arrow" {
+		writeJSON(ctx, w, frames)
+		return
+	}
+	encoded, err := frames.MarshalArrow()
+	if err != nil {
+		log.DefaultLogger.Error("Failed to encode frames", "error", err)
+		http.Error(w, "failed to encode frames", http.StatusInternalServerError)
+		return
+	}
+	// []byte values marshal as base64 strings.
+	writeJSON(ctx, w, encoded)
+}
+
+// handleFrames returns the ids and edges of a seed file as Grafana data
+// frames, for table and other panels: POST /frames {fileName, minTimestamp,
+// format} with format "json" (the default) or "arrow".
+func (a *App) handleFrames(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body struct {
+		FileName     string `json:"fileName"`
+		MinTimestamp int64  `json:"minTimestamp"`
+		Format       string `json:"format"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if body.Format != "" && body.Format != "json" && body.Format != "arrow" {
+		http.Error(w, `format must be "json" or "arrow"`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, span := startSpan(req.Context(), "frames",
+		attribute.String("fileName", body.FileName),
+		attribute.String("format", body.Format),
+	)
+	defer span.End()
+
+	store, release, err := a.openStore(ctx, body.FileName)
+	if err != nil {
+		log.DefaultLogger.Error("Failed to get database connection:", "filename", body.FileName, "error", err)
+		http.Error(w, "failed to get database connection", http.StatusInternalServerError)
+		return
+	}
+	defer release()
+
+	ids, err := loadIds(ctx, store, float64(body.MinTimestamp))
+	if err != nil {
+		log.DefaultLogger.Error("Failed to read ids", "filename", body.FileName, "error", err)
+		http.Error(w, "failed to read ids", http.StatusInternalServerError)
+		return
+	}
+	edges, err := loadEdges(ctx, store, float64(body.MinTimestamp), nil)
+	if err != nil {
+		log.DefaultLogger.Error("Failed to read edges", "filename", body.FileName, "error", err)
+		http.Error(w, "failed to read edges", http.StatusInternalServerError)
+		return
+	}
+	span.SetAttributes(attribute.Int("ids", len(ids)), attribute.Int("edges", len(edges)))
+
+	writeFrames(ctx, w, data.Frames{idsFrame(ids), edgesFrame(edges)}, body.Format)
+}
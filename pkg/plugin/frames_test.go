@@ -0,0 +1,73 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"mapgl-app/pkg/database"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// TestFrames checks that /frames returns the same typed frames in JSON and
+// Arrow.
+func TestFrames(t *testing.T) {
+	app := newTestApp(t, "")
+
+	ctx := context.Background()
+	db, err := app.openDB(ctx, "frames")
+	if err != nil {
+		t.Fatalf("open: %s", err)
+	}
+	if err := db.Set(ctx, "ts1", "Paris"); err != nil {
+		t.Fatalf("seed: %s", err)
+	}
+	if err := db.ZAdd(ctx, database.IdsIndex, "ts1", 1000); err != nil {
+		t.Fatalf("seed: %s", err)
+	}
+	parPath := []interface{}{"b", []interface{}{0.0, 0.0}, []interface{}{0.0, 1.0}, "a"}
+	fields := database.EdgeMetrics(parPath)
+	fields["deleted"] = false
+	fields["parPath"] = mustJSON(t, parPath)
+	if err := db.HSet(ctx, "e1", fields); err != nil {
+		t.Fatalf("seed: %s", err)
+	}
+	if err := db.ZAdd(ctx, database.EdgesIndex, "e1", 2000); err != nil {
+		t.Fatalf("seed: %s", err)
+	}
+	db.Release()
+
+	var raw []json.RawMessage
+	callJSON(t, app, "frames", `{"fileName":"frames"}`, &raw)
+	jsonFrames := make(data.Frames, len(raw))
+	for i, b := range raw {
+		jsonFrames[i] = &data.Frame{}
+		if err := json.Unmarshal(b, jsonFrames[i]); err != nil {
+			t.Fatalf("decode frame: %s", err)
+		}
+	}
+
+	var encoded [][]byte
+	callJSON(t, app, "frames", `{"fileName":"frames","format":"arrow"}`, &encoded)
+	arrowFrames, err := data.UnmarshalArrowFrames(encoded)
+	if err != nil {
+		t.Fatalf("decode arrow: %s", err)
+	}
+
+	for format, frames := range map[string]data.Frames{"json": jsonFrames, "arrow": arrowFrames} {
+		if len(frames) != 2 || frames[0].Name != "ids" || frames[1].Name != "edges" {
+			t.Fatalf("%s: want ids and edges frames, got %v", format, frames)
+		}
+		ids, edges := frames[0], frames[1]
+		if ids.Rows() != 1 || ids.At(1, 0) != "Paris" || !ids.At(2, 0).(time.Time).Equal(time.UnixMilli(1000)) {
+			t.Errorf("%s: unexpected ids frame %v", format, ids.Fields)
+		}
+		if edges.Rows() != 1 || edges.At(1, 0) != "a - b" || edges.At(3, 0) != false {
+			t.Errorf("%s: unexpected edges frame %v", format, edges.Fields)
+		}
+		if l, _ := edges.At(4, 0).(float64); l < 111000 || l > 111300 {
+			t.Errorf("%s: edge length should be about 111.2 km, got %v", format, edges.At(4, 0))
+		}
+	}
+}
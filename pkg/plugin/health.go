@@ -0,0 +1,260 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"mapgl-app/pkg/database"
+	"mapgl-app/pkg/settings"
+	"mapgl-app/pkg/util"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+const (
+	healthOk       = "ok"
+	healthDegraded = "degraded"
+	healthError    = "error"
+	healthDisabled = "disabled"
+)
+
+// healthCheck is the outcome of a single CheckHealth probe.
+type healthCheck struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+type powerHostHealth struct {
+	Domain    string `json:"domain"`
+	ExpiresAt int64  `json:"expiresAt"`
+	Expired   bool   `json:"expired"`
+}
+
+type licenseHealth struct {
+	healthCheck
+	OrgName    string            `json:"org,omitempty"`
+	ExpiresAt  int64             `json:"expiresAt,omitempty"`
+	PowerHosts []powerHostHealth `json:"powerHosts,omitempty"`
+}
+
+type seedFileHealth struct {
+	healthCheck
+	FileName      string `json:"fileName,omitempty"`
+	SchemaVersion int    `json:"schemaVersion"`
+	LatestVersion int    `json:"latestSchemaVersion"`
+	Edges         int    `json:"edges"`
+	Ids           int    `json:"ids"`
+}
+
+// healthDetails is sent as CheckHealthResult.JSONDetails.
+type healthDetails struct {
+	DataDir   healthCheck        `json:"dataDir"`
+	SeedFile  seedFileHealth     `json:"seedFile"`
+	Pool      database.PoolStats `json:"pool"`
+	Storage   healthCheck        `json:"storage"`
+	License   licenseHealth      `json:"license"`
+	Signaling healthCheck        `json:"signaling"`
+	Resp      healthCheck        `json:"resp"`
+}
+
+// summary folds the checks into the overall status. A failed storage check,
+// an invalid or expired license and enabled servers that aren't listening
+// are errors. Degraded checks, e.g. a license without power hosts, leave
+// the plugin usable, so they are warnings in the message of an ok status:
+// Grafana has no degraded status, and fails plugins that report any other
+// status than ok.
+func (d healthDetails) summary() (backend.HealthStatus, string) {
+	checks := []struct {
+		name string
+		healthCheck
+	}{
+		{"data dir", d.DataDir},
+		{"seed file", d.SeedFile.healthCheck},
+		{"storage", d.Storage},
+		{"license", d.License.healthCheck},
+		{"signaling", d.Signaling},
+		{"resp", d.Resp},
+	}
+
+	var errs, degraded []string
+	for _, c := range checks {
+		switch c.Status {
+		case healthError:
+			errs = append(errs, c.name+": "+c.Message)
+		case healthDegraded:
+			degraded = append(degraded, c.name+": "+c.Message)
+		}
+	}
+
+	switch {
+	case len(errs) > 0:
+		return backend.HealthStatusError, strings.Join(append(errs, degraded...), "; ")
+	case len(degraded) > 0:
+		return backend.HealthStatusOk, "degraded: " + strings.Join(degraded, "; ")
+	default:
+		return backend.HealthStatusOk, "ok"
+	}
+}
+
+// checkDataDir verifies that the seed dir exists and accepts new files.
+func (a *App) checkDataDir() healthCheck {
+	dir := a.MapglSettings.SeedDir
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		return healthCheck{healthError, fmt.Sprintf("seed dir %s: %v", dir, err)}
+	}
+	if !info.IsDir() {
+		return healthCheck{healthError, fmt.Sprintf("seed dir %s is not a directory", dir)}
+	}
+
+	f, err := os.CreateTemp(dir, ".health-*")
+	if err != nil {
+		return healthCheck{healthError, fmt.Sprintf("seed dir %s is not writable: %v", dir, err)}
+	}
+	f.Close()
+	os.Remove(f.Name())
+
+	return healthCheck{healthOk, dir + " is writable"}
+}
+
+// checkSeedFile checks that the first seed file on disk can be read: it
+// opens the file read-only, not through the instance's pool, so that a
+// health check never migrates or backs it up, and reads its schema version
+// and the sizes of its replication indexes. CheckHealth reports the pool
+// stats next to it.
+func (a *App) checkSeedFile() seedFileHealth {
+	files, err := filepath.Glob(filepath.Join(a.MapglSettings.SeedDir, "*.db"))
+	if err != nil {
+		return seedFileHealth{healthCheck: healthCheck{healthError, err.Error()}}
+	}
+	if len(files) == 0 {
+		return seedFileHealth{healthCheck: healthCheck{healthOk, "no seed databases yet"}}
+	}
+	sort.Strings(files)
+
+	fileName := strings.TrimSuffix(filepath.Base(files[0]), ".db")
+	res := seedFileHealth{FileName: fileName, LatestVersion: database.LatestSchemaVersion()}
+
+	DB, err := database.OpenReadOnly(files[0])
+	if err != nil {
+		res.healthCheck = healthCheck{healthError, fmt.Sprintf("open %s: %v", fileName, err)}
+		return res
+	}
+	defer DB.Close()
+
+	if res.SchemaVersion, err = database.SchemaVersion(DB); err != nil {
+		res.healthCheck = healthCheck{healthError, fmt.Sprintf("query %s: %v", fileName, err)}
+		return res
+	}
+	if res.Edges, err = DB.ZSet().Len("lastEdges"); err != nil {
+		res.healthCheck = healthCheck{healthError, fmt.Sprintf("query %s: %v", fileName, err)}
+		return res
+	}
+	if res.Ids, err = DB.ZSet().Len("lastIds"); err != nil {
+		res.healthCheck = healthCheck{healthError, fmt.Sprintf("query %s: %v", fileName, err)}
+		return res
+	}
+
+	msg := fmt.Sprintf("%s opened read-only and queried", fileName)
+	if res.SchemaVersion < res.LatestVersion {
+		msg += fmt.Sprintf(", schema v%d migrates to v%d when next opened", res.SchemaVersion, res.LatestVersion)
+	}
+	res.healthCheck = healthCheck{healthOk, msg}
+	return res
+}
+
+// checkLicense decodes the configured token and reports its expiry and
+// power hosts. Push routes are only registered with a valid power host.
+func (a *App) checkLicense() licenseHealth {
+	if a.MapglSettings.ApiToken == settings.ApiToken {
+		// The placeholder of installs without a license.
+		return licenseHealth{healthCheck: healthCheck{healthDegraded, "no license token, push routes are disabled"}}
+	}
+	claims, err := util.DecodeToken(a.MapglSettings.ApiToken, JWT_PUBLIC_KEY)
+	if err != nil {
+		return licenseHealth{healthCheck: healthCheck{healthError, fmt.Sprintf("invalid license token: %v", err)}}
+	}
+
+	now := time.Now()
+	res := licenseHealth{
+		OrgName:   claims.OrgName,
+		ExpiresAt: claims.ExpiresAt.Time.Unix(),
+	}
+
+	validPower := 0
+	for _, h := range claims.AllowedHosts {
+		if !h.IsPower {
+			continue
+		}
+		expired := h.ExpiresAt.Time.Before(now)
+		if !expired {
+			validPower++
+		}
+		res.PowerHosts = append(res.PowerHosts, powerHostHealth{
+			Domain:    h.Domain,
+			ExpiresAt: h.ExpiresAt.Time.Unix(),
+			Expired:   expired,
+		})
+	}
+
+	switch {
+	case claims.ExpiresAt.Time.Before(now):
+		res.healthCheck = healthCheck{healthError, fmt.Sprintf("token expired at %s", claims.ExpiresAt.Time.Format(time.RFC3339))}
+	case validPower == 0:
+		res.healthCheck = healthCheck{healthDegraded, "no unexpired power hosts, push routes are disabled"}
+	default:
+		res.healthCheck = healthCheck{healthOk, fmt.Sprintf("licensed for %s, %d power host(s)", claims.OrgName, validPower)}
+	}
+	return res
+}
+
+// checkStorage pings the external Redis when it is the storage backend.
+// Seed databases are covered by checkDataDir and checkSeedFile.
+func (a *App) checkStorage(ctx context.Context) healthCheck {
+	if a.redis == nil {
+		return healthCheck{healthOk, "replicating through seed databases in " + a.MapglSettings.SeedDir}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	if err := a.redis.Ping(ctx); err != nil {
+		return healthCheck{healthError, fmt.Sprintf("redis %s: %v", a.MapglSettings.RedisAddr, err)}
+	}
+	return healthCheck{healthOk, "replicating through redis at " + a.MapglSettings.RedisAddr}
+}
+
+// checkSignaling dials the signaling port when the server is enabled.
+func (a *App) checkSignaling() healthCheck {
+	if !a.MapglSettings.SignalingEnabled {
+		return healthCheck{healthDisabled, "signaling server is disabled"}
+	}
+
+	addr := net.JoinHostPort("127.0.0.1", a.MapglSettings.ApiPort)
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		return healthCheck{healthError, fmt.Sprintf("signaling port %s is not bound: %v", a.MapglSettings.ApiPort, err)}
+	}
+	conn.Close()
+
+	return healthCheck{healthOk, "signaling server listening on " + addr}
+}
+
+// checkResp reports whether the RESP listener runs when it is enabled.
+func (a *App) checkResp() healthCheck {
+	a.respMu.Lock()
+	defer a.respMu.Unlock()
+
+	switch {
+	case !a.MapglSettings.RespEnabled:
+		return healthCheck{healthDisabled, "RESP server is disabled"}
+	case a.respErr != nil:
+		return healthCheck{healthError, "RESP server is not running: " + a.respErr.Error()}
+	}
+	return healthCheck{healthOk, fmt.Sprintf("serving %s on %s", a.MapglSettings.RespFileName, a.respServer.Addr())}
+}
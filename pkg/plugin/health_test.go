@@ -0,0 +1,109 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// TestCheckHealth checks that storage problems and invalid licenses fail the
+// health check while an unlicensed install is ok with a warning.
+func TestCheckHealth(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		seedDir    string
+		settings   string // more JSON settings
+		seedFile   string // content of a seed file, if any
+		token      string
+		expStatus  backend.HealthStatus
+		expDir     string
+		expLicense string
+		expStorage string // status of the seed file and storage checks
+	}{
+		{
+			name:       "writable seed dir",
+			seedDir:    t.TempDir(),
+			expStatus:  backend.HealthStatusOk,
+			expDir:     healthOk,
+			expLicense: healthDegraded,
+		},
+		{
+			name:       "missing seed dir",
+			seedDir:    t.TempDir() + "/missing",
+			expStatus:  backend.HealthStatusError,
+			expDir:     healthError,
+			expLicense: healthDegraded,
+		},
+		{
+			name:       "unreadable seed file",
+			seedDir:    t.TempDir(),
+			seedFile:   "not a database",
+			expStatus:  backend.HealthStatusError,
+			expDir:     healthOk,
+			expLicense: healthDegraded,
+			expStorage: healthError,
+		},
+		{
+			name:       "redis down",
+			seedDir:    t.TempDir(),
+			settings:   `,"storageBackend":"redis","redisAddr":"127.0.0.1:1"`,
+			expStatus:  backend.HealthStatusError,
+			expDir:     healthOk,
+			expLicense: healthDegraded,
+			expStorage: healthError,
+		},
+		{
+			name:       "invalid license",
+			seedDir:    t.TempDir(),
+			token:      "not-a-jwt",
+			expStatus:  backend.HealthStatusError,
+			expDir:     healthOk,
+			expLicense: healthError,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.seedFile != "" {
+				if err := os.WriteFile(filepath.Join(tc.seedDir, "seed.db"), []byte(tc.seedFile), 0o644); err != nil {
+					t.Fatalf("write seed file: %s", err)
+				}
+			}
+			inst, err := NewApp(context.Background(), backend.AppInstanceSettings{
+				JSONData:                []byte(`{"seedDir":"` + tc.seedDir + `"` + tc.settings + `}`),
+				DecryptedSecureJSONData: map[string]string{"apiToken": tc.token},
+			})
+			if err != nil {
+				t.Fatalf("new app: %s", err)
+			}
+			app := inst.(*App)
+
+			res, err := app.CheckHealth(context.Background(), &backend.CheckHealthRequest{})
+			if err != nil {
+				t.Fatalf("CheckHealth error: %s", err)
+			}
+			if res.Status != tc.expStatus {
+				t.Errorf("status should be %s, got %s (%s)", tc.expStatus, res.Status, res.Message)
+			}
+
+			var details healthDetails
+			if err := json.Unmarshal(res.JSONDetails, &details); err != nil {
+				t.Fatalf("unmarshal details: %s", err)
+			}
+			if details.DataDir.Status != tc.expDir {
+				t.Errorf("data dir status should be %s, got %s", tc.expDir, details.DataDir.Status)
+			}
+			if details.License.Status != tc.expLicense {
+				t.Errorf("license status should be %s, got %s", tc.expLicense, details.License.Status)
+			}
+			if tc.expStorage != "" && details.SeedFile.Status != tc.expStorage && details.Storage.Status != tc.expStorage {
+				t.Errorf("seed file or storage status should be %s, got %s and %s", tc.expStorage, details.SeedFile.Status, details.Storage.Status)
+			}
+			if details.Signaling.Status != healthDisabled {
+				t.Errorf("signaling status should be %s, got %s", healthDisabled, details.Signaling.Status)
+			}
+		})
+	}
+}
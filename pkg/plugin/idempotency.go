@@ -0,0 +1,190 @@
+package plugin
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"mapgl-app/pkg/database"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// idempotencyHeader is the optional header of a push that makes its retries
+// safe: repeats of the key get the recorded result instead of applying the
+// push again.
+const idempotencyHeader = "Idempotency-Key"
+
+// maxIdempotencyKeyLen bounds the keys clients may send.
+const maxIdempotencyKeyLen = 255
+
+// idempotentResult is the recorded result of a push, kept in its seed file
+// for IdempotencyTtl under a "_idempotency:<route>:<user>:<key>" string key.
+// Keys are scoped to the Grafana user, so that users can't replay each
+// other's results.
+type idempotentResult struct {
+	// Fingerprint is a hash of the request body, to tell a retry from a
+	// different request reusing the key.
+	Fingerprint string `json:"fingerprint"`
+	Status      int    `json:"status"`
+	ContentType string `json:"contentType"`
+	Body        []byte `json:"body"`
+}
+
+// keyLocks hands out one mutex per key, kept while someone holds or waits
+// for it.
+type keyLocks struct {
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lock locks key and returns the function unlocking it.
+func (l *keyLocks) lock(key string) func() {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*keyLock)
+	}
+	k, ok := l.locks[key]
+	if !ok {
+		k = &keyLock{}
+		l.locks[key] = k
+	}
+	k.refs++
+	l.mu.Unlock()
+
+	k.Lock()
+	return func() {
+		k.Unlock()
+		l.mu.Lock()
+		if k.refs--; k.refs == 0 {
+			delete(l.locks, key)
+		}
+		l.mu.Unlock()
+	}
+}
+
+// recordingWriter passes a response through while keeping a copy of it.
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// idempotent wraps a push route so that requests with an Idempotency-Key are
+// applied once: the result is recorded in the seed file of the request and
+// replayed, with an Idempotent-Replayed header, for repeats of the key
+// within IdempotencyTtl. Reusing a key for another body fails with 422.
+// Only successful results are recorded, so that retries of failed or
+// rejected pushes, e.g. by an edit lock, run again.
+func (a *App) idempotent(route string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		key := req.Header.Get(idempotencyHeader)
+		if key == "" {
+			next(w, req)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLen {
+			http.Error(w, idempotencyHeader+" is too long", http.StatusBadRequest)
+			return
+		}
+
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		var target struct {
+			FileName string `json:"fileName"`
+		}
+		if json.Unmarshal(body, &target) != nil {
+			// The route reports the malformed body.
+			next(w, req)
+			return
+		}
+		sum := sha256.Sum256(body)
+		fingerprint := hex.EncodeToString(sum[:])
+
+		ctx, span := startSpan(req.Context(), "idempotency",
+			attribute.String("fileName", target.FileName),
+			attribute.String("route", route),
+		)
+		defer span.End()
+
+		store, release, err := a.openStore(ctx, target.FileName)
+		if err != nil {
+			log.DefaultLogger.Error("Failed to get database connection:", "filename", target.FileName, "error", err)
+			http.Error(w, "failed to get database connection", http.StatusInternalServerError)
+			return
+		}
+		defer release()
+
+		// A repeat arriving while the first request runs waits for its
+		// result.
+		storeKey := "_idempotency:" + route + ":" + url.QueryEscape(requestUser(ctx)) + ":" + key
+		defer a.idempotencyLocks.lock(target.FileName + "\x00" + storeKey)()
+		recorded, err := store.Get(ctx, storeKey)
+		if err != nil && !errors.Is(err, database.ErrNotFound) {
+			log.DefaultLogger.Error("Failed to read idempotency key", "filename", target.FileName, "error", err)
+			http.Error(w, "failed to read idempotency key", http.StatusInternalServerError)
+			return
+		}
+		if err == nil {
+			var result idempotentResult
+			if err := json.Unmarshal([]byte(recorded), &result); err != nil {
+				log.DefaultLogger.Error("Malformed idempotency record", "filename", target.FileName, "key", storeKey, "error", err)
+				http.Error(w, "failed to read idempotency key", http.StatusInternalServerError)
+				return
+			}
+			if result.Fingerprint != fingerprint {
+				http.Error(w, idempotencyHeader+" was already used for another request", http.StatusUnprocessableEntity)
+				return
+			}
+			span.SetAttributes(attribute.Bool("replayed", true))
+			if result.ContentType != "" {
+				w.Header().Set("Content-Type", result.ContentType)
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(result.Status)
+			_, _ = w.Write(result.Body)
+			return
+		}
+
+		rec := &recordingWriter{ResponseWriter: w, status: http.StatusOK}
+		next(rec, req.WithContext(ctx))
+		if rec.status < 200 || rec.status >= 300 {
+			return
+		}
+
+		b, _ := json.Marshal(idempotentResult{
+			Fingerprint: fingerprint,
+			Status:      rec.status,
+			ContentType: rec.Header().Get("Content-Type"),
+			Body:        rec.body.Bytes(),
+		})
+		if err := store.SetEx(ctx, storeKey, string(b), a.MapglSettings.IdempotencyTtl); err != nil {
+			log.DefaultLogger.Error("Failed to record idempotency key", "filename", target.FileName, "key", storeKey, "error", err)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package plugin
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// TestIdempotentPush retries a push with the same Idempotency-Key and checks
+// that it is applied once.
+func TestIdempotentPush(t *testing.T) {
+	app := newTestApp(t, "")
+	push := func(key, body string) *backend.CallResourceResponse {
+		t.Helper()
+		req := &backend.CallResourceRequest{Method: http.MethodPost, Path: "pushEdges", Body: []byte(body)}
+		if key != "" {
+			// Forwarded header names may be lower case.
+			req.Headers = map[string][]string{"idempotency-key": {key}}
+		}
+		return callResource(t, app, req)
+	}
+
+	body := `{"fileName":"retry","newDocs":[{"id":"e1","parPath":["a",[1,1],"b"],"updatedAt":1}]}`
+	first := push("k1", body)
+	if first.Status != http.StatusOK || !strings.Contains(string(first.Body), `"_rev":1`) {
+		t.Fatalf("first push: %d %s", first.Status, first.Body)
+	}
+	again := push("k1", body)
+	if again.Status != http.StatusOK || string(again.Body) != string(first.Body) {
+		t.Errorf("retry should replay the first result, got %d %s", again.Status, again.Body)
+	}
+	if h := again.Headers["Idempotent-Replayed"]; len(h) != 1 || h[0] != "true" {
+		t.Errorf("replay should be marked, got headers %v", again.Headers)
+	}
+
+	var items []map[string]interface{}
+	callJSON(t, app, "pullEdges", `{"fileName":"retry"}`, &items)
+	if len(items) != 1 || items[0]["_rev"] != 1.0 {
+		t.Errorf("retry should not write e1 again, got %v", items)
+	}
+
+	other := strings.Replace(body, `"updatedAt":1`, `"updatedAt":2`, 1)
+	if res := push("k1", other); res.Status != http.StatusUnprocessableEntity {
+		t.Errorf("reusing a key for another body should fail with 422, got %d", res.Status)
+	}
+	if res := push("k2", other); res.Status != http.StatusOK || !strings.Contains(string(res.Body), `"_rev":2`) {
+		t.Errorf("a new key should apply the push, got %d %s", res.Status, res.Body)
+	}
+	if res := push("", other); res.Status != http.StatusOK || !strings.Contains(string(res.Body), `"_rev":3`) {
+		t.Errorf("pushes without a key should always apply, got %d %s", res.Status, res.Body)
+	}
+}
+
+// TestIdempotentPushScope checks that keys are scoped to the Grafana user
+// and that rejected pushes are not recorded.
+func TestIdempotentPushScope(t *testing.T) {
+	app := newTestApp(t, "")
+	push := func(login, key, body string) *backend.CallResourceResponse {
+		t.Helper()
+		req := &backend.CallResourceRequest{Method: http.MethodPost, Path: "pushEdges", Body: []byte(body)}
+		req.PluginContext.User = &backend.User{Login: login}
+		req.Headers = map[string][]string{"Idempotency-Key": {key}}
+		return callResource(t, app, req)
+	}
+
+	body := `{"fileName":"scope","newDocs":[{"id":"e1","parPath":["a",[1,1],"b"],"updatedAt":1}]}`
+	if res := push("alice", "k1", body); res.Status != http.StatusOK || !strings.Contains(string(res.Body), `"_rev":1`) {
+		t.Fatalf("alice's push: %d %s", res.Status, res.Body)
+	}
+	if res := push("bob", "k1", body); res.Status != http.StatusOK || !strings.Contains(string(res.Body), `"_rev":2`) {
+		t.Errorf("bob's key should not replay alice's result, got %d %s", res.Status, res.Body)
+	}
+
+	lock := `{"fileName":"scope","kind":"edge","key":"e1"}`
+	if status, body := call(t, app, "alice", "locks/acquire", lock); status != http.StatusOK {
+		t.Fatalf("lock: %d %s", status, body)
+	}
+	if res := push("bob", "k2", body); res.Status != http.StatusConflict {
+		t.Fatalf("bob's push should be refused by the lock, got %d %s", res.Status, res.Body)
+	}
+	if status, body := call(t, app, "alice", "locks/release", lock); status != http.StatusOK {
+		t.Fatalf("unlock: %d %s", status, body)
+	}
+	if res := push("bob", "k2", body); res.Status != http.StatusOK || res.Headers["Idempotent-Replayed"] != nil {
+		t.Errorf("the retry of a refused push should run again, got %d %s", res.Status, res.Body)
+	}
+}
@@ -0,0 +1,111 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"mapgl-app/pkg/database"
+	"net/http"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// handleCheckIntegrity checks a seed file for index/hash inconsistencies and
+// SQLite corruption, and repairs what it can when asked to. Repairs write to
+// the seed file like pushes, so they need the same license as the push
+// routes and don't interleave with pushes.
+func (a *App) handleCheckIntegrity(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body struct {
+		FileName string `json:"fileName"`
+		Repair   bool   `json:"repair"`
+	}
+
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if body.Repair && !a.canWrite {
+		http.Error(w, "repair requires a power host license", http.StatusForbidden)
+		return
+	}
+
+	fileName := body.FileName
+	ctx, span := startSpan(req.Context(), "checkIntegrity",
+		attribute.String("fileName", fileName),
+		attribute.Bool("repair", body.Repair),
+	)
+	defer span.End()
+
+	DB, err := a.openDB(ctx, fileName)
+	if err != nil {
+		log.DefaultLogger.Error("Failed to get database connection:", "filename", fileName, "error", err)
+		http.Error(w, "failed to get database connection", http.StatusInternalServerError)
+		return
+	}
+	defer DB.Release()
+
+	var repair database.RepairFunc
+	if body.Repair {
+		a.pushMu.Lock()
+		defer a.pushMu.Unlock()
+		repair = func(id string, fields map[string]any) error {
+			return a.repairEdge(ctx, DB, fileName, id, fields)
+		}
+	}
+
+	report, err := database.CheckIntegrity(DB.Handle, repair)
+	if err != nil {
+		tracing.Error(span, err)
+		log.DefaultLogger.Error("Integrity check failed", "filename", fileName, "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	span.SetAttributes(
+		attribute.Int("repaired", report.Repaired),
+		attribute.Int("unrepaired", report.Unrepaired),
+	)
+	if report.Repaired > 0 || report.Unrepaired > 0 {
+		log.DefaultLogger.Warn("Seed db integrity issues", "filename", fileName,
+			"repaired", report.Repaired, "unrepaired", report.Unrepaired)
+	}
+
+	writeJSON(ctx, w, report)
+}
+
+// repairEdge writes an integrity fix to an edge as a push would: with its
+// metrics, a new revision, its derived data and a fresh score.
+func (a *App) repairEdge(ctx context.Context, store database.Store, fileName, id string, fields map[string]any) error {
+	existing, err := store.HGetAll(ctx, id)
+	if err != nil {
+		return err
+	}
+	rawPath := existing["parPath"]
+	if p, ok := fields["parPath"].(string); ok {
+		rawPath = p
+	}
+	// Malformed paths of live edges are left as they are.
+	var parPath []interface{}
+	if json.Unmarshal([]byte(rawPath), &parPath) == nil {
+		for field, val := range database.EdgeMetrics(parPath) {
+			fields[field] = val
+		}
+	}
+
+	if len(fields) > 0 {
+		if err := store.HSet(ctx, id, fields); err != nil {
+			return err
+		}
+	}
+	score := nowScore()
+	if err := a.edgeWritten(ctx, store, fileName, id, parPath, score); err != nil {
+		return err
+	}
+	return store.ZAdd(ctx, database.EdgesIndex, id, score)
+}
@@ -0,0 +1,50 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"mapgl-app/pkg/database"
+	"testing"
+)
+
+// TestRepairIntegrity repairs a seed file and checks that the fixed edges
+// replicate like pushed ones: re-scored, with a new revision and their
+// relational row.
+func TestRepairIntegrity(t *testing.T) {
+	app := newTestApp(t, "")
+	callJSON(t, app, "pushEdges", `{"fileName":"fix","newDocs":[{"id":"e1","parPath":["a",[1,1],"b"],"updatedAt":1}]}`, nil)
+
+	ctx := context.Background()
+	db, err := app.openDB(ctx, "fix")
+	if err != nil {
+		t.Fatalf("open: %s", err)
+	}
+	defer db.Release()
+	if _, err := db.Hash().Set("e1", "deleted", "yes"); err != nil {
+		t.Fatalf("corrupt: %s", err)
+	}
+	if _, err := db.Hash().SetMany("e2", map[string]any{"deleted": false, "parPath": `["c",[2,2],"d"]`}); err != nil {
+		t.Fatalf("corrupt: %s", err)
+	}
+
+	before := nowScore()
+	var report database.IntegrityReport
+	callJSON(t, app, "checkIntegrity", `{"fileName":"fix","repair":true}`, &report)
+	if report.Repaired != 2 || report.Unrepaired != 0 {
+		t.Fatalf("want 2 repaired issues, got %+v", report)
+	}
+
+	var edges []map[string]interface{}
+	callJSON(t, app, "pullEdges", fmt.Sprintf(`{"fileName":"fix","minTimestamp":%.0f}`, before), &edges)
+	revs := map[interface{}]interface{}{}
+	for _, e := range edges {
+		revs[e["id"]] = e["_rev"]
+	}
+	if len(edges) != 2 || revs["e1"] != 2.0 || revs["e2"] != 1.0 {
+		t.Errorf("repaired edges should be pulled with a new _rev, got %v", edges)
+	}
+	var deleted bool
+	if err := db.RO.QueryRow(`select deleted from edges where id = 'e1'`).Scan(&deleted); err != nil || !deleted {
+		t.Errorf("edges table should have the repaired e1, got %v, %v", deleted, err)
+	}
+}
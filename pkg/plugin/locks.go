@@ -0,0 +1,280 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mapgl-app/pkg/database"
+	"mapgl-app/pkg/httpadapter"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// locksKey is the hash of the edit locks of a seed file, by objectField.
+const locksKey = "_editLocks"
+
+// EditLock is a lease of a Grafana user on editing an edge or id. It lasts
+// until ExpiresAt, in ms, unless its holder renews or releases it.
+type EditLock struct {
+	Kind      string  `json:"kind"` // "edge" or "id"
+	Key       string  `json:"key"`
+	User      string  `json:"user"`
+	ExpiresAt float64 `json:"expiresAt"`
+}
+
+// objectField names an edge or id in the metadata hashes of a seed file,
+// as "kind:key".
+func objectField(kind, key string) string {
+	return kind + ":" + key
+}
+
+// reservedKey tells the metadata keys of a seed file, such as locksKey and
+// revsKey, which start with "_", from the ids and edges clients may write.
+func reservedKey(key string) bool {
+	return strings.HasPrefix(key, "_")
+}
+
+// requestUser returns the login of the Grafana user of a request, or "" for
+// requests without one.
+func requestUser(ctx context.Context) string {
+	if u := httpadapter.UserFromContext(ctx); u != nil {
+		return u.Login
+	}
+	return ""
+}
+
+// objectFields returns the objectFields of keys of kind.
+func objectFields(kind string, keys []string) []string {
+	fields := make([]string, len(keys))
+	for i, key := range keys {
+		fields[i] = objectField(kind, key)
+	}
+	return fields
+}
+
+// loadLocks returns the edit locks of a seed file active at now, by
+// objectField, and the fields of those that expired.
+func loadLocks(ctx context.Context, store database.Store, now float64) (map[string]EditLock, []string, error) {
+	fields, err := store.HGetAll(ctx, locksKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	locks, expired := parseLocks(fields, now)
+	return locks, expired, nil
+}
+
+// objectLocks returns the edit locks active at now of the objects of kind
+// with keys, by objectField. Pulls and pushes only read those of the
+// objects they return; expired locks are cleaned up by handleLock.
+func objectLocks(ctx context.Context, store database.Store, kind string, keys []string, now float64) (map[string]EditLock, error) {
+	fields, err := store.HMGet(ctx, locksKey, objectFields(kind, keys)...)
+	if err != nil {
+		return nil, err
+	}
+	locks, _ := parseLocks(fields, now)
+	return locks, nil
+}
+
+func parseLocks(fields map[string]string, now float64) (map[string]EditLock, []string) {
+	locks := make(map[string]EditLock, len(fields))
+	var expired []string
+	for field, val := range fields {
+		var lock EditLock
+		if json.Unmarshal([]byte(val), &lock) != nil || lock.ExpiresAt <= now {
+			expired = append(expired, field)
+			continue
+		}
+		locks[field] = lock
+	}
+	return locks, expired
+}
+
+// checkLocks returns the error message of a push by user when one of keys
+// is locked by someone else, or "" when the push may write them all.
+func checkLocks(ctx context.Context, store database.Store, kind, user string, keys []string) (string, error) {
+	locks, err := objectLocks(ctx, store, kind, keys, nowScore())
+	if err != nil || len(locks) == 0 {
+		return "", err
+	}
+	for _, key := range keys {
+		if lock, ok := locks[objectField(kind, key)]; ok && lock.User != user {
+			until := time.UnixMilli(int64(lock.ExpiresAt)).UTC().Format(time.RFC3339)
+			return fmt.Sprintf("%s %s is locked by %s until %s", kind, key, lock.User, until), nil
+		}
+	}
+	return "", nil
+}
+
+// guardedWrite runs write, a write of keys of kind by user, under pushMu so
+// that it doesn't interleave with the checks and writes of other pushes or
+// with lock requests on this instance. Pushes and RESP writes go through
+// it. When one of keys is locked by someone else, it returns the message of
+// checkLocks instead of writing.
+func (a *App) guardedWrite(ctx context.Context, store database.Store, kind, user string, keys []string, write func() error) (string, error) {
+	a.pushMu.Lock()
+	defer a.pushMu.Unlock()
+
+	msg, err := checkLocks(ctx, store, kind, user, keys)
+	if err != nil || msg != "" {
+		return msg, err
+	}
+	return "", write()
+}
+
+func idKeys(docs []NewIdDoc) []string {
+	keys := make([]string, len(docs))
+	for i, doc := range docs {
+		keys[i] = doc.TsId
+	}
+	return keys
+}
+
+func edgeKeys(docs []NewEdgeDoc) []string {
+	keys := make([]string, len(docs))
+	for i, doc := range docs {
+		keys[i] = doc.Id
+	}
+	return keys
+}
+
+// touchLocked re-scores a locked or unlocked object in its replication index
+// so that incremental pulls return it with its new lock. Objects that don't
+// exist yet, e.g. an edge being drawn, have nothing to touch.
+func touchLocked(ctx context.Context, store database.Store, kind, key string) error {
+	index := database.EdgesIndex
+	if kind == "id" {
+		index = database.IdsIndex
+		if _, err := store.Get(ctx, key); errors.Is(err, database.ErrNotFound) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	} else {
+		fields, err := store.HGetAll(ctx, key)
+		if err != nil {
+			return err
+		}
+		if len(fields) == 0 {
+			return nil
+		}
+	}
+	return store.ZAdd(ctx, index, key, nowScore())
+}
+
+// handleLock acquires, renews or releases the edit lock of the request's
+// Grafana user on an edge or id: POST /locks/{acquire,renew,release}
+// {fileName, kind, key}. Pushes by other users to a locked object fail
+// until the lock expires, EditLockTtl after it was last acquired or
+// renewed. Acquiring a lock held by another user, or renewing or releasing
+// one that isn't the caller's, fails with 409 and the current lock.
+func (a *App) handleLock(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	action := mux.Vars(req)["action"]
+
+	var body struct {
+		FileName string `json:"fileName"`
+		Kind     string `json:"kind"`
+		Key      string `json:"key"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if body.Kind != "edge" && body.Kind != "id" {
+		http.Error(w, `kind must be "edge" or "id"`, http.StatusBadRequest)
+		return
+	}
+	if body.Key == "" {
+		http.Error(w, "key is required", http.StatusBadRequest)
+		return
+	}
+	if reservedKey(body.Key) {
+		http.Error(w, fmt.Sprintf("%s %s is reserved for plugin metadata", body.Kind, body.Key), http.StatusBadRequest)
+		return
+	}
+	user := requestUser(req.Context())
+	if user == "" {
+		http.Error(w, "edit locks need a signed in Grafana user", http.StatusUnauthorized)
+		return
+	}
+
+	ctx, span := startSpan(req.Context(), "lock",
+		attribute.String("fileName", body.FileName),
+		attribute.String("action", action),
+		attribute.String("kind", body.Kind),
+		attribute.String("key", body.Key),
+	)
+	defer span.End()
+
+	store, release, err := a.openStore(ctx, body.FileName)
+	if err != nil {
+		log.DefaultLogger.Error("Failed to get database connection:", "filename", body.FileName, "error", err)
+		http.Error(w, "failed to get database connection", http.StatusInternalServerError)
+		return
+	}
+	defer release()
+
+	// Checking and taking a lock must not interleave with another lock
+	// request or with a write on the same plugin instance, see guardedWrite.
+	a.pushMu.Lock()
+	defer a.pushMu.Unlock()
+
+	now := nowScore()
+	locks, expired, err := loadLocks(ctx, store, now)
+	if err != nil {
+		log.DefaultLogger.Error("Failed to read edit locks", "filename", body.FileName, "error", err)
+		http.Error(w, "failed to read edit locks", http.StatusInternalServerError)
+		return
+	}
+	for _, field := range expired {
+		if err := store.HDel(ctx, locksKey, field); err != nil {
+			log.DefaultLogger.Error("Failed to remove expired edit lock", "filename", body.FileName, "lock", field, "error", err)
+		}
+	}
+	field := objectField(body.Kind, body.Key)
+	current, held := locks[field]
+
+	switch {
+	case held && current.User != user:
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		_ = json.NewEncoder(w).Encode(current)
+		return
+	case !held && action != "acquire":
+		if action == "release" {
+			// Releasing a lock that already expired is a no-op.
+			writeJSON(ctx, w, EditLock{Kind: body.Kind, Key: body.Key, User: user})
+			return
+		}
+		http.Error(w, "no lock to renew, acquire it again", http.StatusConflict)
+		return
+	}
+
+	lock := EditLock{Kind: body.Kind, Key: body.Key, User: user}
+	if action == "release" {
+		err = store.HDel(ctx, locksKey, field)
+	} else {
+		lock.ExpiresAt = now + float64(a.MapglSettings.EditLockTtl.Milliseconds())
+		b, _ := json.Marshal(lock)
+		err = store.HSet(ctx, locksKey, map[string]any{field: string(b)})
+	}
+	if err == nil {
+		err = touchLocked(ctx, store, body.Kind, body.Key)
+	}
+	if err != nil {
+		log.DefaultLogger.Error("Failed to write edit lock", "filename", body.FileName, "error", err)
+		http.Error(w, "failed to write edit lock", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(ctx, w, lock)
+}
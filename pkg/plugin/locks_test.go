@@ -0,0 +1,87 @@
+package plugin
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestEditLocks has two users lock, push and pull an edge.
+func TestEditLocks(t *testing.T) {
+	app := newTestApp(t, "")
+
+	push := `{"fileName":"locks","newDocs":[{"id":"e1","parPath":["a",[1,1],[2,2],"b"],"updatedAt":1}]}`
+	if status, body := call(t, app, "alice", "pushEdges", push); status != http.StatusOK {
+		t.Fatalf("push: %d %s", status, body)
+	}
+
+	lock := `{"fileName":"locks","kind":"edge","key":"e1"}`
+	if status, _ := call(t, app, "", "locks/acquire", lock); status != http.StatusUnauthorized {
+		t.Errorf("locks without a user should be refused, got %d", status)
+	}
+	status, body := call(t, app, "alice", "locks/acquire", lock)
+	var held EditLock
+	if status != http.StatusOK || json.Unmarshal([]byte(body), &held) != nil || held.User != "alice" || held.ExpiresAt <= nowScore() {
+		t.Fatalf("alice should get the lock, got %d %s", status, body)
+	}
+	if status, body := call(t, app, "bob", "locks/acquire", lock); status != http.StatusConflict || !strings.Contains(body, `"alice"`) {
+		t.Errorf("bob should see alice's lock, got %d %s", status, body)
+	}
+	if status, body := call(t, app, "bob", "pushEdges", push); status != http.StatusConflict || !strings.Contains(body, "locked by alice") {
+		t.Errorf("bob's push should be refused, got %d %s", status, body)
+	}
+	if status, body := call(t, app, "alice", "pushEdges", push); status != http.StatusOK {
+		t.Errorf("alice's push should pass, got %d %s", status, body)
+	}
+	if status, _ := call(t, app, "alice", "locks/renew", lock); status != http.StatusOK {
+		t.Errorf("alice should renew her lock, got %d", status)
+	}
+
+	// The lock re-scored the edge, so an incremental pull returns it.
+	var items []map[string]interface{}
+	_, body = call(t, app, "bob", "pullEdges", `{"fileName":"locks","minTimestamp":2}`)
+	if err := json.Unmarshal([]byte(body), &items); err != nil || len(items) != 1 {
+		t.Fatalf("pull should return the locked edge, got %s", body)
+	}
+	if l, _ := items[0]["_lock"].(map[string]interface{}); l["user"] != "alice" {
+		t.Errorf("pull should show alice's lock, got %v", items[0]["_lock"])
+	}
+
+	if status, _ := call(t, app, "bob", "locks/release", lock); status != http.StatusConflict {
+		t.Errorf("bob should not release alice's lock, got %d", status)
+	}
+	if status, _ := call(t, app, "alice", "locks/release", lock); status != http.StatusOK {
+		t.Errorf("alice should release her lock, got %d", status)
+	}
+	if status, body := call(t, app, "bob", "pushEdges", push); status != http.StatusOK {
+		t.Errorf("bob's push should pass after the release, got %d %s", status, body)
+	}
+	if status, _ := call(t, app, "bob", "locks/renew", lock); status != http.StatusConflict {
+		t.Errorf("renewing a lock bob doesn't hold should fail, got %d", status)
+	}
+	_, body = call(t, app, "bob", "pullEdges", `{"fileName":"locks"}`)
+	if strings.Contains(body, "_lock") {
+		t.Errorf("released lock should not be pulled, got %s", body)
+	}
+}
+
+// TestPushReservedKeys checks that pushes can't overwrite the metadata of a
+// seed file, such as its edit locks, and that it can't be locked.
+func TestPushReservedKeys(t *testing.T) {
+	app := newTestApp(t, "")
+
+	for route, push := range map[string]string{
+		"pushIds":   `{"fileName":"reserved","newDocs":[{"tsId":"_editLocks","name":"x","updatedAt":1}]}`,
+		"pushEdges": `{"fileName":"reserved","newDocs":[{"id":"_revs","parPath":["a",[1,1],"b"],"updatedAt":1}]}`,
+	} {
+		if status, body := call(t, app, "alice", route, push); status != http.StatusBadRequest || !strings.Contains(body, "reserved") {
+			t.Errorf("%s of a metadata key should be refused, got %d %s", route, status, body)
+		}
+	}
+
+	lock := `{"fileName":"reserved","kind":"edge","key":"_revs"}`
+	if status, body := call(t, app, "alice", "locks/acquire", lock); status != http.StatusBadRequest || !strings.Contains(body, "reserved") {
+		t.Errorf("lock of a metadata key should be refused, got %d %s", status, body)
+	}
+}
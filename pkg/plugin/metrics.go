@@ -0,0 +1,91 @@
+package plugin
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// EdgeTotals aggregates the derived metrics of the edges of a seed file.
+// Deleted edges are only counted.
+type EdgeTotals struct {
+	Edges           int       `json:"edges"`
+	Deleted         int       `json:"deleted"`
+	Ephemeral       int       `json:"ephemeral"`
+	Length          float64   `json:"length"`          // meters, live edges
+	EphemeralLength float64   `json:"ephemeralLength"` // meters, live ephemeral edges
+	BBox            []float64 `json:"bbox"`            // [minLon, minLat, maxLon, maxLat] of live edges, null if none
+}
+
+// add counts one edge in the pullEdges format.
+func (t *EdgeTotals) add(item map[string]interface{}) {
+	if deleted, _ := item["_deleted"].(bool); deleted {
+		t.Deleted++
+		return
+	}
+	t.Edges++
+	length, _ := item["length"].(float64)
+	t.Length += length
+	if isEph, _ := item["isEph"].(bool); isEph {
+		t.Ephemeral++
+		t.EphemeralLength += length
+	}
+	box, ok := item["bbox"].([]float64)
+	if !ok {
+		return
+	}
+	if t.BBox == nil {
+		t.BBox = append([]float64(nil), box...)
+		return
+	}
+	t.BBox[0] = math.Min(t.BBox[0], box[0])
+	t.BBox[1] = math.Min(t.BBox[1], box[1])
+	t.BBox[2] = math.Max(t.BBox[2], box[2])
+	t.BBox[3] = math.Max(t.BBox[3], box[3])
+}
+
+// edgeTotals returns the route length and extent of a seed file, from the
+// lengths and boxes computed when its edges were pushed.
+func (a *App) edgeTotals(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body struct {
+		FileName string `json:"fileName"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, span := startSpan(req.Context(), "edgeTotals", attribute.String("fileName", body.FileName))
+	defer span.End()
+
+	store, release, err := a.openStore(ctx, body.FileName)
+	if err != nil {
+		log.DefaultLogger.Error("Failed to get database connection:", "filename", body.FileName, "error", err)
+		http.Error(w, "failed to get database connection", http.StatusInternalServerError)
+		return
+	}
+	defer release()
+
+	items, err := loadEdges(ctx, store, 0, nil)
+	if err != nil {
+		log.DefaultLogger.Error("Failed to read edges", "filename", body.FileName, "error", err)
+		http.Error(w, "failed to read edges", http.StatusInternalServerError)
+		return
+	}
+
+	var totals EdgeTotals
+	for _, item := range items {
+		totals.add(item)
+	}
+	span.SetAttributes(attribute.Int("edges", totals.Edges), attribute.Float64("length", totals.Length))
+
+	writeJSON(ctx, w, totals)
+}
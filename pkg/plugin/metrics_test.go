@@ -0,0 +1,47 @@
+package plugin
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+// TestEdgeTotals pushes edges and checks the lengths and boxes the backend
+// computes for them, per edge in pullEdges and summed by /edgeTotals.
+func TestEdgeTotals(t *testing.T) {
+	app := newTestApp(t, "")
+
+	// One degree of latitude is about 111.2 km, a tenth of it 11.1 km.
+	push := `{"fileName":"fiber","newDocs":[
+		{"id":"trunk","parPath":["b",[0,0],[0,1],"a"],"updatedAt":10,"_deleted":false},
+		{"id":"drop","parPath":["c",[0,1],[0,1.1],"b"],"updatedAt":10,"_deleted":false,"isEph":true},
+		{"id":"old","parPath":[],"updatedAt":10,"_deleted":true}
+	]}`
+	callJSON(t, app, "pushEdges", push, nil)
+
+	var edges []map[string]interface{}
+	callJSON(t, app, "pullEdges", `{"fileName":"fiber","minTimestamp":0}`, &edges)
+	for _, e := range edges {
+		if e["id"] != "trunk" {
+			continue
+		}
+		if l, _ := e["length"].(float64); math.Abs(l-111195) > 1 {
+			t.Errorf("trunk length should be about 111195 m, got %v", e["length"])
+		}
+		if fmt.Sprint(e["bbox"]) != "[0 0 0 1]" {
+			t.Errorf("trunk bbox should be [0 0 0 1], got %v", e["bbox"])
+		}
+	}
+
+	var totals EdgeTotals
+	callJSON(t, app, "edgeTotals", `{"fileName":"fiber"}`, &totals)
+	if totals.Edges != 2 || totals.Deleted != 1 || totals.Ephemeral != 1 {
+		t.Errorf("want 2 live edges, 1 deleted, 1 ephemeral, got %+v", totals)
+	}
+	if math.Abs(totals.Length-122315) > 2 || math.Abs(totals.EphemeralLength-11120) > 1 {
+		t.Errorf("want about 122315 m in total and 11120 m ephemeral, got %+v", totals)
+	}
+	if fmt.Sprint(totals.BBox) != "[0 0 0 1.1]" {
+		t.Errorf("totals bbox should be [0 0 0 1.1], got %v", totals.BBox)
+	}
+}
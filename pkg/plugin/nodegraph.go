@@ -0,0 +1,123 @@
+package plugin
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// nodeGraphFrames builds the nodes and edges frames of the Node Graph panel
+// from live edges in the pullEdges format. Nodes are the ends of the edges,
+// titled with the matching id's name when there is one, with their number
+// of edges as main stat; edges have their length as main stat.
+func nodeGraphFrames(ids []RedisIdItem, items []map[string]interface{}) data.Frames {
+	titles := make(map[string]string, len(ids))
+	for _, id := range ids {
+		titles[id.ID] = id.Name
+	}
+
+	var edgeIds, sources, targets []string
+	var lengths []float64
+	degree := map[string]int64{}
+	for _, item := range items {
+		if deleted, _ := item["_deleted"].(bool); deleted {
+			continue
+		}
+		parPath, _ := item["parPath"].([]interface{})
+		from, to, ok := edgeEnds(parPath)
+		if !ok {
+			continue
+		}
+		id, _ := item["id"].(string)
+		length, _ := item["length"].(float64)
+		edgeIds, sources, targets = append(edgeIds, id), append(sources, from), append(targets, to)
+		lengths = append(lengths, length)
+		degree[from]++
+		if to != from {
+			degree[to]++
+		}
+	}
+
+	nodeIds := make([]string, 0, len(degree))
+	for n := range degree {
+		nodeIds = append(nodeIds, n)
+	}
+	sort.Strings(nodeIds)
+	nodeTitles := make([]string, len(nodeIds))
+	stats := make([]int64, len(nodeIds))
+	for i, n := range nodeIds {
+		nodeTitles[i], stats[i] = n, degree[n]
+		if title, ok := titles[n]; ok && title != "" {
+			nodeTitles[i] = title
+		}
+	}
+
+	meta := &data.FrameMeta{PreferredVisualization: data.VisTypeNodeGraph}
+	nodes := data.NewFrame("nodes",
+		data.NewField("id", nil, nodeIds),
+		data.NewField("title", nil, nodeTitles),
+		data.NewField("mainstat", nil, stats).SetConfig(&data.FieldConfig{DisplayName: "Edges"}),
+	).SetMeta(meta)
+	edges := data.NewFrame("edges",
+		data.NewField("id", nil, edgeIds),
+		data.NewField("source", nil, sources),
+		data.NewField("target", nil, targets),
+		data.NewField("mainstat", nil, lengths).SetConfig(&data.FieldConfig{Unit: "lengthm"}),
+	).SetMeta(meta)
+	return data.Frames{nodes, edges}
+}
+
+// handleNodeGraph returns the network of a seed file as the nodes and edges
+// frames of the Node Graph panel: POST /nodeGraph {fileName, format} with
+// format "json" (the default) or "arrow", as for /frames.
+func (a *App) handleNodeGraph(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body struct {
+		FileName string `json:"fileName"`
+		Format   string `json:"format"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if body.Format != "" && body.Format != "json" && body.Format != "arrow" {
+		http.Error(w, `format must be "json" or "arrow"`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, span := startSpan(req.Context(), "nodeGraph", attribute.String("fileName", body.FileName))
+	defer span.End()
+
+	store, release, err := a.openStore(ctx, body.FileName)
+	if err != nil {
+		log.DefaultLogger.Error("Failed to get database connection:", "filename", body.FileName, "error", err)
+		http.Error(w, "failed to get database connection", http.StatusInternalServerError)
+		return
+	}
+	defer release()
+
+	ids, err := loadIds(ctx, store, 0)
+	if err != nil {
+		log.DefaultLogger.Error("Failed to read ids", "filename", body.FileName, "error", err)
+		http.Error(w, "failed to read ids", http.StatusInternalServerError)
+		return
+	}
+	edges, err := loadEdges(ctx, store, 0, nil)
+	if err != nil {
+		log.DefaultLogger.Error("Failed to read edges", "filename", body.FileName, "error", err)
+		http.Error(w, "failed to read edges", http.StatusInternalServerError)
+		return
+	}
+
+	frames := nodeGraphFrames(ids, edges)
+	span.SetAttributes(attribute.Int("nodes", frames[0].Rows()), attribute.Int("edges", frames[1].Rows()))
+	writeFrames(ctx, w, frames, body.Format)
+}
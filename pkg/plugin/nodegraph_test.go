@@ -0,0 +1,37 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// TestNodeGraph checks the Node Graph frames of a small network, including
+// an empty one.
+func TestNodeGraph(t *testing.T) {
+	ids := []RedisIdItem{{ID: "a", Name: "Exchange A"}}
+	items := []map[string]interface{}{
+		{"id": "e1", "parPath": []interface{}{"b", "a"}, "length": 10.0, "_deleted": false},
+		{"id": "e2", "parPath": []interface{}{"c", "b"}, "length": 20.0, "_deleted": false},
+		{"id": "gone", "parPath": []interface{}{"d", "a"}, "_deleted": true},
+	}
+	frames := nodeGraphFrames(ids, items)
+	nodes, edges := frames[0], frames[1]
+	if nodes.Rows() != 3 || edges.Rows() != 2 {
+		t.Fatalf("want 3 nodes and 2 edges, got %d and %d", nodes.Rows(), edges.Rows())
+	}
+	if nodes.At(0, 0) != "a" || nodes.At(1, 0) != "Exchange A" || nodes.At(1, 1) != "b" || nodes.At(2, 1) != int64(2) {
+		t.Errorf("unexpected nodes frame %v", nodes.Fields)
+	}
+	if edges.At(1, 0) != "a" || edges.At(2, 0) != "b" || edges.At(3, 1) != 20.0 {
+		t.Errorf("unexpected edges frame %v", edges.Fields)
+	}
+	if nodes.Meta == nil || nodes.Meta.PreferredVisualization != data.VisTypeNodeGraph {
+		t.Errorf("frames should prefer the node graph, got %+v", nodes.Meta)
+	}
+
+	frames = nodeGraphFrames(nil, nil)
+	if _, err := frames.MarshalArrow(); err != nil || frames[0].Rows() != 0 {
+		t.Errorf("empty network should give empty frames, got %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+package plugin
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// TestEdgeProps pushes edges with props and checks that they are validated,
+// returned and filtered by pullEdges, and exported to the edges table.
+func TestEdgeProps(t *testing.T) {
+	app := newTestApp(t, "")
+
+	callJSON(t, app, "pushEdges", `{"fileName":"props","newDocs":[
+		{"id":"e1","parPath":["b","a"],"updatedAt":10,"props":{"owner":"acme","capacity":96}},
+		{"id":"e2","parPath":["c","a"],"updatedAt":10,"props":{"owner":"other"}},
+		{"id":"e3","parPath":["d","a"],"updatedAt":10}
+	]}`, nil)
+	// A push without props keeps them; a deleted edge is pulled whatever its props.
+	callJSON(t, app, "pushEdges", `{"fileName":"props","newDocs":[
+		{"id":"e1","parPath":["b","a"],"updatedAt":20},
+		{"id":"e3","parPath":[],"updatedAt":20,"_deleted":true}
+	]}`, nil)
+	bad := `{"fileName":"props","newDocs":[{"id":"bad","parPath":[],"updatedAt":1,"props":{"_rev":1}}]}`
+	if status, _ := call(t, app, "", "pushEdges", bad); status != http.StatusBadRequest {
+		t.Errorf("reserved props key should be rejected, got %d", status)
+	}
+
+	var edges []map[string]interface{}
+	callJSON(t, app, "pullEdges", `{"fileName":"props","minTimestamp":0,"props":{"owner":"acme"}}`, &edges)
+	ids := map[string]bool{}
+	for _, e := range edges {
+		ids[e["id"].(string)] = true
+		if e["id"] == "e1" && fmt.Sprint(e["props"]) != "map[capacity:96 owner:acme]" {
+			t.Errorf("e1 should keep its props, got %v", e["props"])
+		}
+	}
+	if len(edges) != 2 || !ids["e1"] || !ids["e3"] {
+		t.Errorf("want e1 and the e3 tombstone, got %v", edges)
+	}
+
+	db, err := app.openDB(context.Background(), "props")
+	if err != nil {
+		t.Fatalf("open: %s", err)
+	}
+	defer db.Release()
+	var props sql.NullString
+	if err := db.RO.QueryRow(`select props from edges where id = 'e2'`).Scan(&props); err != nil {
+		t.Fatalf("query: %s", err)
+	}
+	if props.String != `{"owner":"other"}` {
+		t.Errorf("edges table should have the props of e2, got %v", props)
+	}
+	if err := db.RO.QueryRow(`select props from edges where id = 'e3'`).Scan(&props); err != nil || props.Valid {
+		t.Errorf("e3 has no props, got %v, %v", props, err)
+	}
+}
@@ -0,0 +1,35 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+)
+
+// TestRelationalSync checks that pushes keep the ids and edges tables of a
+// seed file in sync.
+func TestRelationalSync(t *testing.T) {
+	app := newTestApp(t, "")
+
+	for path, body := range map[string]string{
+		"pushIds":   `{"fileName":"sql","newDocs":[{"tsId":"ts1","name":"Paris","updatedAt":5}]}`,
+		"pushEdges": `{"fileName":"sql","newDocs":[{"id":"e1","parPath":["b",[0,0],[0,1],"a"],"updatedAt":7}]}`,
+	} {
+		callJSON(t, app, path, body, nil)
+	}
+
+	db, err := app.openDB(context.Background(), "sql")
+	if err != nil {
+		t.Fatalf("open: %s", err)
+	}
+	defer db.Release()
+
+	var name string
+	var updatedAt int64
+	row := db.RO.QueryRow(`select i.name, e.updated_at from ids i, edges e where i.ts_id = 'ts1' and e.id = 'e1'`)
+	if err := row.Scan(&name, &updatedAt); err != nil {
+		t.Fatalf("query: %s", err)
+	}
+	if name != "Paris" || updatedAt != 7 {
+		t.Errorf("want Paris and an edge updated at 7, got %s, %d", name, updatedAt)
+	}
+}
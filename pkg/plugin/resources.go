@@ -1,16 +1,23 @@
 package plugin
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"mapgl-app/pkg/database"
+	"mapgl-app/pkg/geo"
 	"mapgl-app/pkg/util"
+	"math"
 	"net/http"
+	"path/filepath"
 	"strconv"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
+	"go.opentelemetry.io/otel/attribute"
 	_ "modernc.org/sqlite"
 )
 
@@ -30,22 +37,14 @@ func init() {
 //	return DB
 //}
 
-type SetItem struct {
-	id    int
-	Elem  []byte
-	Score float64
-}
-
-type MySetItem struct {
-	id    int
-	Elem  string
-	Score float64
-}
-
 type RedisIdItem struct {
 	ID    string  `json:"tsId"`
 	Name  string  `json:"name"`
 	Score float64 `json:"updatedAt"`
+	// Rev is the revision of the id, see revsKey.
+	Rev int64 `json:"_rev"`
+	// Lock is the active edit lock on the id, in pulls.
+	Lock *EditLock `json:"_lock,omitempty"`
 }
 
 type NewIdDoc struct {
@@ -53,6 +52,10 @@ type NewIdDoc struct {
 	TsId      string `json:"tsId"`
 	UpdatedAt int64  `json:"updatedAt"`
 	Deleted   bool   `json:"_deleted"`
+	// Rev, when set, is the revision the client last saw: the push fails
+	// with a RevConflict unless the id is still at it. Pushes answer with
+	// the new revisions. The Redis backend refuses conditional pushes.
+	Rev *int64 `json:"_rev,omitempty"`
 }
 
 type NewEdgeDoc struct {
@@ -61,6 +64,14 @@ type NewEdgeDoc struct {
 	UpdatedAt   float64       `json:"updatedAt"`
 	IsEphemeral *bool         `json:"isEph,omitempty"`
 	Deleted     bool          `json:"_deleted"`
+	// Props are the edge's properties, see database.ValidateProps. Docs
+	// without props keep the stored ones; an empty object clears them.
+	Props map[string]interface{} `json:"props,omitempty"`
+	// Rev, when set, is the revision the client last saw, as for ids.
+	Rev *int64 `json:"_rev,omitempty"`
+	// Simplified marks pulled docs whose parPath was simplified by a
+	// tolerance or zoom. Their paths are lossy, so pushes refuse them.
+	Simplified bool `json:"simplified,omitempty"`
 }
 
 type Response struct {
@@ -72,21 +83,52 @@ type Response struct {
 	ExpiresAt int64  `json:"expiresAt"`
 }
 
-// ConvertSetItems converts []SetItem to []MySetItem
-func ConvertSetItems(items []SetItem) []MySetItem {
-	var convertedItems []MySetItem
-	for _, item := range items {
-		// Convert []byte to string
-		elemString := string(item.Elem)
-
-		// Append to convertedItems slice
-		convertedItems = append(convertedItems, MySetItem{
-			id:    item.id,
-			Elem:  elemString,
-			Score: item.Score,
-		})
+// seedPath returns the path of a seed database inside the configured seed dir.
+func (a *App) seedPath(fileName string) string {
+	return filepath.Join(a.MapglSettings.SeedDir, fileName+".db")
+}
+
+// openDB leases the redka handle of a seed file, traced as its own step.
+// Callers must Release the lease when they are done with it.
+func (a *App) openDB(ctx context.Context, fileName string) (*database.Lease, error) {
+	_, span := startSpan(ctx, "database.GetDB", attribute.String("fileName", fileName))
+	defer span.End()
+
+	db, err := a.dbs.GetDB(a.seedPath(fileName))
+	if err != nil {
+		return nil, tracing.Error(span, err)
+	}
+	return db, nil
+}
+
+// openStore returns the storage of a seed file for the replication routes:
+// the seed database, or its keys in Redis when that backend is configured.
+// Callers must call release when they are done with the store.
+func (a *App) openStore(ctx context.Context, fileName string) (store database.Store, release func(), err error) {
+	if a.redis != nil {
+		prefix := a.MapglSettings.RedisKeyPrefix + fileName + ":"
+		return database.NewRedisStore(a.redis, prefix), func() {}, nil
+	}
+
+	db, err := a.openDB(ctx, fileName)
+	if err != nil {
+		return nil, nil, err
+	}
+	return db, db.Release, nil
+}
+
+// writeJSON encodes the response body in its own span, so the time spent
+// encoding large pulls is visible next to the database steps.
+func writeJSON(ctx context.Context, w http.ResponseWriter, v interface{}) {
+	_, span := startSpan(ctx, "json.Encode")
+	defer span.End()
+
+	w.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		tracing.Error(span, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
-	return convertedItems
 }
 
 func (a *App) pullIds(w http.ResponseWriter, req *http.Request) {
@@ -109,76 +151,88 @@ func (a *App) pullIds(w http.ResponseWriter, req *http.Request) {
 	minTimestampFloat := float64(body.MinTimestamp)
 	fileName := body.FileName
 
-	//   maxScore := "+inf"
+	ctx, span := startSpan(req.Context(), "pullIds",
+		attribute.String("fileName", fileName),
+		attribute.Int64("minTimestamp", body.MinTimestamp),
+	)
+	defer span.End()
 
-	// Retrieve members of the sorted set within the specified score range
-
-	//DB := GetDB()
-	DB, err0 := database.GetDB("./public/seed/" + fileName + ".db")
+	store, release, err0 := a.openStore(ctx, fileName)
 	if err0 != nil {
 		log.DefaultLogger.Error("Failed to get database connection:", "filename", fileName, "error", err0)
 		http.Error(w, "failed to get database connection", http.StatusInternalServerError)
 		return
 	}
+	defer release()
 
-	maxEl, err1 := DB.ZSet().RangeWith("lastIds").ByRank(0, 0).Desc().Run()
-	if err1 != nil {
-		log.DefaultLogger.Error(fmt.Sprintf("Failed !!!!!!!!!!!!!! lastIds fial!! : %v", err1))
+	redisItems, err := loadIds(ctx, store, minTimestampFloat)
+	if err != nil {
+		log.DefaultLogger.Error("Failed to range lastIds", "filename", fileName, "error", err)
+		http.Error(w, "failed to read ids", http.StatusInternalServerError)
+		return
 	}
-
-	var maxScore float64
-	if len(maxEl) > 0 {
-		maxScore = maxEl[0].Score
+	keys := make([]string, len(redisItems))
+	for i, item := range redisItems {
+		keys[i] = item.ID
 	}
-
-	log.DefaultLogger.Info(fmt.Sprintf("minTimestampFloat: %+v", minTimestampFloat))
-	log.DefaultLogger.Info(fmt.Sprintf("MaxScore: %+v", maxScore))
-
-	setItems, err2 := DB.ZSet().RangeWith("lastIds").ByScore(minTimestampFloat, maxScore).Run()
-	if err2 != nil {
-		panic(err2)
+	locks, err := objectLocks(ctx, store, "id", keys, nowScore())
+	if err != nil {
+		log.DefaultLogger.Error("Failed to read edit locks", "filename", fileName, "error", err)
+		http.Error(w, "failed to read edit locks", http.StatusInternalServerError)
+		return
 	}
-
-	var customSetItems []SetItem
-	for _, item := range setItems {
-		customSetItems = append(customSetItems, SetItem{
-			Elem:  item.Elem,
-			Score: item.Score,
-		})
+	revs, err := objectRevs(ctx, store, "id", keys)
+	if err != nil {
+		log.DefaultLogger.Error("Failed to read revisions", "filename", fileName, "error", err)
+		http.Error(w, "failed to read revisions", http.StatusInternalServerError)
+		return
+	}
+	for i := range redisItems {
+		field := objectField("id", redisItems[i].ID)
+		redisItems[i].Rev = revs[field]
+		if lock, ok := locks[field]; ok {
+			redisItems[i].Lock = &lock
+		}
 	}
+	span.SetAttributes(attribute.Int("items", len(redisItems)))
 
-	// Convert custom SetItems to MySetItems
-	mySetItems := ConvertSetItems(customSetItems)
+	writeJSON(ctx, w, redisItems)
+}
+
+// loadIds reads the ids updated since minTimestamp in the pullIds format.
+func loadIds(ctx context.Context, store database.Store, minTimestamp float64) ([]RedisIdItem, error) {
+	// Retrieve members of the sorted set within the specified score range
+	_, rangeSpan := startSpan(ctx, "store.ZRangeByScore", attribute.String("key", database.IdsIndex))
+	setItems, err := store.ZRangeByScore(ctx, database.IdsIndex, minTimestamp, math.Inf(1))
+	rangeSpan.SetAttributes(attribute.Int("items", len(setItems)))
+	if err != nil {
+		tracing.Error(rangeSpan, err)
+		rangeSpan.End()
+		return nil, err
+	}
+	rangeSpan.End()
 
 	var redisItems []RedisIdItem
 
-	for _, item := range mySetItems {
-		// Retrieve the string value from Redis using the key from Elem property
-		value, err := DB.Str().Get(item.Elem)
+	_, getSpan := startSpan(ctx, "store.Get", attribute.Int("items", len(setItems)))
+	defer getSpan.End()
+	for _, item := range setItems {
+		// Retrieve the string value using the key from Member
+		value, err := store.Get(ctx, item.Member)
 		if err != nil {
 			// Handle error
-			log.DefaultLogger.Error(fmt.Sprintf("Failed to retrieve value for key %s: %v", item.Elem, err))
+			log.DefaultLogger.Error(fmt.Sprintf("Failed to retrieve value for key %s: %v", item.Member, err))
 			continue // Continue to the next item
 		}
 
-		// Extract the value from the result tuple
-		stringValue := string(value)
-
 		// Create a new RedisIdItem struct and append it to the redisItems slice
 		redisItems = append(redisItems, RedisIdItem{
-			ID:    item.Elem, // Assuming item.Elem is already a string
-			Name:  stringValue,
+			ID:    item.Member,
+			Name:  value,
 			Score: item.Score,
 		})
 	}
-
-	w.Header().Add("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(redisItems); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	w.WriteHeader(http.StatusOK)
-
+	return redisItems, nil
 }
 
 func (a *App) pullEdges(w http.ResponseWriter, req *http.Request) {
@@ -188,8 +242,13 @@ func (a *App) pullEdges(w http.ResponseWriter, req *http.Request) {
 	}
 
 	var body struct {
-		MinTimestamp int64  `json:"minTimestamp"`
-		FileName     string `json:"fileName"`
+		MinTimestamp int64     `json:"minTimestamp"`
+		FileName     string    `json:"fileName"`
+		BBox         []float64 `json:"bbox,omitempty"` // [minLon, minLat, maxLon, maxLat]
+		Tolerance    *float64  `json:"tolerance,omitempty"`
+		Zoom         *float64  `json:"zoom,omitempty"`
+		// Props keeps the edges whose props have these values.
+		Props map[string]interface{} `json:"props,omitempty"`
 	}
 
 	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
@@ -197,60 +256,150 @@ func (a *App) pullEdges(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	var bbox *geo.BBox
+	if body.BBox != nil {
+		if len(body.BBox) != 4 {
+			http.Error(w, "bbox must be [minLon, minLat, maxLon, maxLat]", http.StatusBadRequest)
+			return
+		}
+		bbox = &geo.BBox{MinLon: body.BBox[0], MinLat: body.BBox[1], MaxLon: body.BBox[2], MaxLat: body.BBox[3]}
+		if err := bbox.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	tolerance, err := pullTolerance(body.Tolerance, body.Zoom)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Convert MinTimestamp from int64 to float64
 	minTimestampFloat := float64(body.MinTimestamp)
+	fileName := body.FileName
 
-	//   maxScore := "+inf"
-
-	// Retrieve members of the sorted set within the specified score range
+	ctx, span := startSpan(req.Context(), "pullEdges",
+		attribute.String("fileName", fileName),
+		attribute.Int64("minTimestamp", body.MinTimestamp),
+		attribute.Bool("bbox", bbox != nil),
+		attribute.Float64("tolerance", tolerance),
+	)
+	defer span.End()
 
-	//DB := GetDB()
-	fileName := body.FileName
-	DB, err0 := database.GetDB("./public/seed/" + fileName + ".db")
+	store, release, err0 := a.openStore(ctx, fileName)
 	if err0 != nil {
 		log.DefaultLogger.Error("Failed to get database connection:", "filename", fileName, "error", err0)
 		http.Error(w, "failed to get database connection", http.StatusInternalServerError)
 		return
 	}
+	defer release()
 
-	maxEl, err := DB.ZSet().RangeWith("lastEdges").ByRank(0, 0).Desc().Run()
-	var maxScore float64
-	if len(maxEl) > 0 {
-		maxScore = maxEl[0].Score
+	// Pulls leave expired ephemeral edges out and don't write; the
+	// background job turns them into tombstones. It learns here about the
+	// files whose edges were pushed before a restart.
+	if due, err := store.ZRangeByScore(ctx, database.ExpiryIndex, math.Inf(-1), nowScore()); err != nil {
+		log.DefaultLogger.Error("Failed to read ephemeral edge expiries", "filename", fileName, "error", err)
+	} else if len(due) > 0 {
+		a.expiring.schedule(fileName, due[0].Score)
 	}
 
-	setItems, err := DB.ZSet().RangeWith("lastEdges").ByScore(minTimestampFloat, maxScore).Run()
+	redisItems, err := loadEdges(ctx, store, minTimestampFloat, bbox)
 	if err != nil {
-		panic(err)
+		log.DefaultLogger.Error("Failed to read edges", "filename", fileName, "error", err)
+		http.Error(w, "failed to read edges", http.StatusInternalServerError)
+		return
 	}
+	redisItems = filterProps(redisItems, body.Props)
+	simplifyEdges(ctx, redisItems, tolerance)
 
-	var customSetItems []SetItem
-	for _, item := range setItems {
-		customSetItems = append(customSetItems, SetItem{
-			Elem:  item.Elem,
-			Score: item.Score,
-		})
+	keys := make([]string, len(redisItems))
+	for i, item := range redisItems {
+		keys[i], _ = item["id"].(string)
+	}
+	locks, err := objectLocks(ctx, store, "edge", keys, nowScore())
+	if err != nil {
+		log.DefaultLogger.Error("Failed to read edit locks", "filename", fileName, "error", err)
+		http.Error(w, "failed to read edit locks", http.StatusInternalServerError)
+		return
+	}
+	revs, err := objectRevs(ctx, store, "edge", keys)
+	if err != nil {
+		log.DefaultLogger.Error("Failed to read revisions", "filename", fileName, "error", err)
+		http.Error(w, "failed to read revisions", http.StatusInternalServerError)
+		return
 	}
+	for _, item := range redisItems {
+		id, _ := item["id"].(string)
+		field := objectField("edge", id)
+		item["_rev"] = revs[field]
+		if lock, ok := locks[field]; ok {
+			item["_lock"] = lock
+		}
+	}
+	span.SetAttributes(attribute.Int("items", len(redisItems)))
 
-	// Convert custom SetItems to MySetItems
-	mySetItems := ConvertSetItems(customSetItems)
+	writeJSON(ctx, w, redisItems)
+}
+
+// loadEdges reads the edges updated since minTimestamp in the pullEdges
+// format. With bbox set, only edges intersecting it are returned; edges
+// without coordinates, such as emptied tombstones, are kept when the store
+// has no spatial index to place them. Ephemeral edges past their expiry are
+// left out.
+func loadEdges(ctx context.Context, store database.Store, minTimestamp float64, bbox *geo.BBox) ([]map[string]interface{}, error) {
+	// Retrieve members of the sorted set within the specified score range
+	_, rangeSpan := startSpan(ctx, "store.ZRangeByScore", attribute.String("key", database.EdgesIndex))
+	setItems, err := store.ZRangeByScore(ctx, database.EdgesIndex, minTimestamp, math.Inf(1))
+	rangeSpan.SetAttributes(attribute.Int("items", len(setItems)))
+	if err != nil {
+		tracing.Error(rangeSpan, err)
+		rangeSpan.End()
+		return nil, err
+	}
+	rangeSpan.End()
+
+	var inBox map[string]bool
+	idx, indexed := store.(database.SpatialIndex)
+	if bbox != nil && indexed {
+		_, bboxSpan := startSpan(ctx, "database.EdgesInBBox")
+		inBox, err = idx.EdgesInBBox(ctx, *bbox)
+		bboxSpan.SetAttributes(attribute.Int("items", len(inBox)))
+		if err != nil {
+			tracing.Error(bboxSpan, err)
+			bboxSpan.End()
+			return nil, err
+		}
+		bboxSpan.End()
+	}
 
 	var redisItems []map[string]interface{}
+	now := nowScore()
+
+	_, itemsSpan := startSpan(ctx, "store.HGetAll", attribute.Int("items", len(setItems)))
+	defer itemsSpan.End()
+	for _, item := range setItems {
+		if inBox != nil && !inBox[item.Member] {
+			continue
+		}
 
-	for _, item := range mySetItems {
-		// Retrieve the map[string]core.Value value from Redis using the key from Elem property
-		valueMap, err := DB.Hash().Items(item.Elem)
+		// Retrieve the edge hash using the key from Member
+		valueMap, err := store.HGetAll(ctx, item.Member)
 		if err != nil {
 			// Handle error
-			log.DefaultLogger.Error(fmt.Sprintf("Failed to retrieve value for key %s: %v", item.Elem, err))
+			log.DefaultLogger.Error(fmt.Sprintf("Failed to retrieve value for key %s: %v", item.Member, err))
 			continue // Continue to the next item
 		}
 
 		// Extract individual values from the valueMap and unmarshal them
-		parPathJSON := valueMap["parPath"].String()
+		parPathJSON := valueMap["parPath"]
 
-		deletedStr := valueMap["deleted"].String()
-		deletedValue, err := strconv.ParseBool(deletedStr)
+		deletedValue, _ := strconv.ParseBool(valueMap["deleted"])
+		expiresAt, _ := strconv.ParseFloat(valueMap[database.ExpiresAtField], 64)
+		if !deletedValue && expiresAt > 0 && expiresAt <= now {
+			// Expired, waiting for expireEdges to delete it.
+			continue
+		}
 		var parPathValue []interface{} // Assuming parPathValue is an array of any type
 
 		// Unmarshal the JSON strings into appropriate Go data types
@@ -258,11 +407,17 @@ func (a *App) pullEdges(w http.ResponseWriter, req *http.Request) {
 			// Handle error
 		}
 
+		if bbox != nil && !indexed {
+			if b, ok := geo.Bounds(geo.PathPoints(parPathValue)); ok && !b.Intersects(*bbox) {
+				continue
+			}
+		}
+
 		var isEphValue *bool // Change to pointer type to make it optional
 
 		isEphStr, isEphExists := valueMap["isEph"]
 		if isEphExists {
-			isEphVal, err := strconv.ParseBool(isEphStr.String())
+			isEphVal, err := strconv.ParseBool(isEphStr)
 			if err != nil {
 				// Handle error
 			}
@@ -271,7 +426,7 @@ func (a *App) pullEdges(w http.ResponseWriter, req *http.Request) {
 
 		// Construct RedisEdgeItem instance
 		redisItem := NewEdgeDoc{
-			Id:          item.Elem,
+			Id:          item.Member,
 			ParPath:     parPathValue,
 			UpdatedAt:   item.Score,
 			Deleted:     deletedValue,
@@ -291,17 +446,31 @@ func (a *App) pullEdges(w http.ResponseWriter, req *http.Request) {
 			redisItemMap["isEph"] = *redisItem.IsEphemeral
 		}
 
+		// Edges written before metrics were stored get them computed here.
+		length, box, ok := database.ParseEdgeMetrics(valueMap)
+		if !ok {
+			length = geo.Length(geo.PathPoints(parPathValue))
+			if b, found := geo.Bounds(geo.PathPoints(parPathValue)); found {
+				box = &b
+			}
+		}
+		redisItemMap["length"] = length
+		if expiresAt > 0 {
+			redisItemMap["expiresAt"] = expiresAt
+		}
+		if props, err := database.ParseProps(valueMap); err != nil {
+			log.DefaultLogger.Error(fmt.Sprintf("Malformed props for key %s: %v", item.Member, err))
+		} else if len(props) > 0 {
+			redisItemMap["props"] = props
+		}
+		if box != nil {
+			redisItemMap["bbox"] = []float64{box.MinLon, box.MinLat, box.MaxLon, box.MaxLat}
+		}
+
 		// Append the map to redisItems
 		redisItems = append(redisItems, redisItemMap)
 	}
-
-	w.Header().Add("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(redisItems); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	w.WriteHeader(http.StatusOK)
-
+	return redisItems, nil
 }
 
 func (a *App) pushIds(w http.ResponseWriter, req *http.Request) {
@@ -321,43 +490,91 @@ func (a *App) pushIds(w http.ResponseWriter, req *http.Request) {
 	}
 
 	NewDocs := body.NewDocs
+	fileName := body.FileName
 
 	for _, item := range NewDocs {
-		// Retrieve the string value from Redis using the key from Elem property
-
-		//DB := GetDB()
-		fileName := body.FileName
-		DB, err0 := database.GetDB("./public/seed/" + fileName + ".db")
-		if err0 != nil {
-			log.DefaultLogger.Error("Failed to get database connection:", "filename", fileName, "error", err0)
-			http.Error(w, "failed to get database connection", http.StatusInternalServerError)
+		if reservedKey(item.TsId) {
+			http.Error(w, fmt.Sprintf("id %s is reserved for plugin metadata", item.TsId), http.StatusBadRequest)
 			return
 		}
+	}
+	if a.redis != nil && idRevs(NewDocs) {
+		http.Error(w, conditionalRedisMsg, http.StatusBadRequest)
+		return
+	}
+
+	ctx, span := startSpan(req.Context(), "pushIds",
+		attribute.String("fileName", fileName),
+		attribute.Int("items", len(NewDocs)),
+	)
+	defer span.End()
+
+	store, release, err0 := a.openStore(ctx, fileName)
+	if err0 != nil {
+		log.DefaultLogger.Error("Failed to get database connection:", "filename", fileName, "error", err0)
+		http.Error(w, "failed to get database connection", http.StatusInternalServerError)
+		return
+	}
+	defer release()
+
+	var conflict *RevConflict
+	var revs map[string]int64
+	msg, err := a.guardedWrite(ctx, store, "id", requestUser(ctx), idKeys(NewDocs), func() error {
+		var err error
+		if conflict, err = checkIdRevs(ctx, store, NewDocs); err != nil || conflict != nil {
+			return err
+		}
+		a.writeIds(ctx, store, NewDocs)
+		revs, err = objectRevs(ctx, store, "id", idKeys(NewDocs))
+		return err
+	})
+	switch {
+	case err != nil:
+		log.DefaultLogger.Error("Failed to push ids", "filename", fileName, "error", err)
+		http.Error(w, "failed to push ids", http.StatusInternalServerError)
+		return
+	case msg != "":
+		http.Error(w, msg, http.StatusConflict)
+		return
+	case conflict != nil:
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		_ = json.NewEncoder(w).Encode(conflict)
+		return
+	}
 
-		err := DB.Str().Set(item.TsId, item.Name)
+	for i := range NewDocs {
+		rev := revs[objectField("id", NewDocs[i].TsId)]
+		NewDocs[i].Rev = &rev
+	}
+
+	writeJSON(ctx, w, NewDocs)
+}
+
+// writeIds writes pushed ids with their derived data. Failed ids are logged
+// and skipped.
+func (a *App) writeIds(ctx context.Context, store database.Store, docs []NewIdDoc) {
+	_, writeSpan := startSpan(ctx, "store.Set", attribute.Int("items", len(docs)))
+	defer writeSpan.End()
+	for _, item := range docs {
+		err := store.Set(ctx, item.TsId, item.Name)
 		if err != nil {
 			// Handle error
 			log.DefaultLogger.Error(fmt.Sprintf("Ids: Failed to set value for key %s: %v", item.TsId, err))
 			continue // Continue to the next item
 		}
-		_, err2 := DB.ZSet().AddMany("lastIds", map[any]float64{
-			item.TsId: float64(item.UpdatedAt),
-		})
 
+		err2 := store.ZAdd(ctx, database.IdsIndex, item.TsId, float64(item.UpdatedAt))
 		if err2 != nil {
 			// Handle error
-			log.DefaultLogger.Error(fmt.Sprintf("Ids: Failed to zadd value for key %s: %v", item.TsId, err))
+			log.DefaultLogger.Error(fmt.Sprintf("Ids: Failed to zadd value for key %s: %v", item.TsId, err2))
 			continue // Continue to the next item
 		}
-	}
 
-	w.Header().Add("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(NewDocs); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		if err := a.idWritten(ctx, store, item.TsId, item.Name, float64(item.UpdatedAt)); err != nil {
+			log.DefaultLogger.Error(fmt.Sprintf("Ids: Failed to update derived data for key %s: %v", item.TsId, err))
+		}
 	}
-	w.WriteHeader(http.StatusOK)
-
 }
 
 func (a *App) pushEdges(w http.ResponseWriter, req *http.Request) {
@@ -377,11 +594,84 @@ func (a *App) pushEdges(w http.ResponseWriter, req *http.Request) {
 	}
 
 	NewDocs := body.NewDocs
+	fileName := body.FileName
 
+	if a.redis != nil && edgeRevs(NewDocs) {
+		http.Error(w, conditionalRedisMsg, http.StatusBadRequest)
+		return
+	}
 	for _, item := range NewDocs {
-		// Retrieve the string value from Redis using the key from Elem property
+		if reservedKey(item.Id) {
+			http.Error(w, fmt.Sprintf("edge %s is reserved for plugin metadata", item.Id), http.StatusBadRequest)
+			return
+		}
+		if item.Simplified {
+			http.Error(w, fmt.Sprintf("edge %s has a simplified parPath, push the full path", item.Id), http.StatusBadRequest)
+			return
+		}
+		if item.Props == nil {
+			continue
+		}
+		if err := database.ValidateProps(item.Props); err != nil {
+			http.Error(w, fmt.Sprintf("edge %s: %s", item.Id, err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx, span := startSpan(req.Context(), "pushEdges",
+		attribute.String("fileName", fileName),
+		attribute.Int("items", len(NewDocs)),
+	)
+	defer span.End()
+
+	store, release, err0 := a.openStore(ctx, fileName)
+	if err0 != nil {
+		log.DefaultLogger.Error("Failed to get database connection:", "filename", fileName, "error", err0)
+		http.Error(w, "failed to get database connection", http.StatusInternalServerError)
+		return
+	}
+	defer release()
+
+	var conflict *RevConflict
+	var revs map[string]int64
+	msg, err := a.guardedWrite(ctx, store, "edge", requestUser(ctx), edgeKeys(NewDocs), func() error {
+		var err error
+		if conflict, err = checkEdgeRevs(ctx, store, NewDocs); err != nil || conflict != nil {
+			return err
+		}
+		a.writeEdges(ctx, store, fileName, NewDocs)
+		revs, err = objectRevs(ctx, store, "edge", edgeKeys(NewDocs))
+		return err
+	})
+	switch {
+	case err != nil:
+		log.DefaultLogger.Error("Failed to push edges", "filename", fileName, "error", err)
+		http.Error(w, "failed to push edges", http.StatusInternalServerError)
+		return
+	case msg != "":
+		http.Error(w, msg, http.StatusConflict)
+		return
+	case conflict != nil:
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		_ = json.NewEncoder(w).Encode(conflict)
+		return
+	}
+
+	for i := range NewDocs {
+		rev := revs[objectField("edge", NewDocs[i].Id)]
+		NewDocs[i].Rev = &rev
+	}
 
-		//updatedAtJSON := strconv.FormatInt(item.UpdatedAt, 10)
+	writeJSON(ctx, w, NewDocs)
+}
+
+// writeEdges writes pushed edges with their derived data. Failed edges are
+// logged and skipped.
+func (a *App) writeEdges(ctx context.Context, store database.Store, fileName string, docs []NewEdgeDoc) {
+	_, writeSpan := startSpan(ctx, "store.HSet", attribute.Int("items", len(docs)))
+	defer writeSpan.End()
+	for _, item := range docs {
 		parPathJSON, _ := json.Marshal(item.ParPath)
 
 		// Construct the map for setting hash values
@@ -395,42 +685,34 @@ func (a *App) pushEdges(w http.ResponseWriter, req *http.Request) {
 			hashValues["isEph"] = *item.IsEphemeral
 		}
 
-		//DB := GetDB()
-		fileName := body.FileName
-		DB, err0 := database.GetDB("./public/seed/" + fileName + ".db")
-		if err0 != nil {
-			log.DefaultLogger.Error("Failed to get database connection:", "filename", fileName, "error", err0)
-			http.Error(w, "failed to get database connection", http.StatusInternalServerError)
-			return
+		if item.Props != nil {
+			propsJSON, _ := json.Marshal(item.Props)
+			hashValues[database.PropsField] = string(propsJSON)
 		}
 
-		_, err := DB.Hash().SetMany(item.Id, hashValues)
+		// Length and bbox are computed here so that all clients agree on them.
+		for field, val := range database.EdgeMetrics(item.ParPath) {
+			hashValues[field] = val
+		}
 
+		err := store.HSet(ctx, item.Id, hashValues)
 		if err != nil {
 			// Handle error
-			log.DefaultLogger.Error(fmt.Sprintf("Edges: Failed to zadd value for key %s: %v", item.Id, item.ParPath, err))
+			log.DefaultLogger.Error(fmt.Sprintf("Edges: Failed to hset value for key %s: %v", item.Id, err))
 			continue // Continue to the next item
 		}
 
-		_, err2 := DB.ZSet().AddMany("lastEdges", map[any]float64{
-			item.Id: float64(item.UpdatedAt),
-		})
+		if err := a.edgeWritten(ctx, store, fileName, item.Id, item.ParPath, item.UpdatedAt); err != nil {
+			log.DefaultLogger.Error(fmt.Sprintf("Edges: Failed to update derived data for key %s: %v", item.Id, err))
+		}
 
+		err2 := store.ZAdd(ctx, database.EdgesIndex, item.Id, item.UpdatedAt)
 		if err2 != nil {
 			// Handle error
-			log.DefaultLogger.Error(fmt.Sprintf("Edges: Failed to zset value for key %s: %v", item.Id, item.ParPath, err))
+			log.DefaultLogger.Error(fmt.Sprintf("Edges: Failed to zadd value for key %s: %v", item.Id, err2))
 			continue // Continue to the next item
 		}
-
 	}
-
-	w.Header().Add("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(NewDocs); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	w.WriteHeader(http.StatusOK)
-
 }
 
 func (a *App) handlePing(w http.ResponseWriter, req *http.Request) {
@@ -446,7 +728,8 @@ func (a *App) handlePing(w http.ResponseWriter, req *http.Request) {
 		Host string `json:"host"`
 	}
 
-	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+	// A bare GET ping carries no body; answer it with the token status only.
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil && err != io.EOF {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
@@ -499,7 +782,7 @@ func createResponseFromClaims(host string, claims *util.Claims, tokenString stri
 		response.IsPower = true
 	} else {
 		response.Status = fmt.Sprintf(
-			"token invalid: %s (exp: %v, err: %v)",
+			"token invalid (exp: %d, err: %v)",
 			claims.ExpiresAt.Time.Unix(),
 			err,
 		)
@@ -555,18 +838,40 @@ func (a *App) handleEcho(w http.ResponseWriter, req *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// handleDbStats reports the seed database handle cache of this instance.
+func (a *App) handleDbStats(w http.ResponseWriter, req *http.Request) {
+	writeJSON(req.Context(), w, a.dbs.Stats())
+}
+
 func (a *App) registerRoutes(r *mux.Router) {
 
 	r.HandleFunc("/ping", a.handlePing)
 	r.HandleFunc("/echo", a.handleEcho)
 
+	r.HandleFunc("/dbStats", a.handleDbStats)
+
 	r.HandleFunc("/pullIds", a.pullIds)
 	r.HandleFunc("/pullEdges", a.pullEdges)
+	r.HandleFunc("/queryEdges", a.queryEdges)
+	r.HandleFunc("/edgeTotals", a.edgeTotals)
+	r.HandleFunc("/search", a.handleSearch)
+	r.HandleFunc("/frames", a.handleFrames)
+	r.HandleFunc("/nodeGraph", a.handleNodeGraph)
+	r.HandleFunc("/diff", a.handleDiff)
+	r.HandleFunc("/snapshots", a.handleSnapshot)
+	r.HandleFunc("/drafts/fork", a.handleForkDraft)
+	r.HandleFunc("/drafts/merge", a.handleMergeDraft)
+	r.HandleFunc("/tiles/{file}/{z:[0-9]+}/{x:[0-9]+}/{y:[0-9]+}.mvt", a.handleTile)
+
+	r.HandleFunc("/topology/components", a.handleComponents)
+	r.HandleFunc("/topology/shortestPath", a.handleShortestPath)
+	r.HandleFunc("/topology/reachability", a.handleReachability)
+
+	r.HandleFunc("/checkIntegrity", a.handleCheckIntegrity)
 
 	publicKey := JWT_PUBLIC_KEY
 	if ok, _ := util.HasSomePowerHost(a.MapglSettings.ApiToken, publicKey); ok {
-		r.HandleFunc("/pushIds", a.pushIds)
-		r.HandleFunc("/pushEdges", a.pushEdges)
+		a.registerWriteRoutes(r)
 	} else {
 		log.DefaultLogger.Info("Not a power host")
 	}
@@ -574,3 +879,13 @@ func (a *App) registerRoutes(r *mux.Router) {
 	//log.DefaultLogger.Info(fmt.Sprintf("Test. Gen token expires at: %s", time.Unix(expirationTime, 0)))
 
 }
+
+// registerWriteRoutes enables the routes that only a license with a power
+// host may use.
+func (a *App) registerWriteRoutes(r *mux.Router) {
+	a.canWrite = true
+	a.goBackground(a.expireLoop)
+	r.HandleFunc("/pushIds", a.idempotent("pushIds", a.pushIds))
+	r.HandleFunc("/pushEdges", a.idempotent("pushEdges", a.pushEdges))
+	r.HandleFunc("/locks/{action:acquire|renew|release}", a.handleLock)
+}
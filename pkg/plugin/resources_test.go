@@ -3,7 +3,11 @@ package plugin
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"mapgl-app/pkg/httpadapter"
 	"net/http"
 	"testing"
 )
@@ -20,6 +24,69 @@ func (s *mockCallResourceResponseSender) Send(response *backend.CallResourceResp
 	return nil
 }
 
+// newTestApp returns an app on a temporary seed dir that is disposed when
+// the test ends. settings are more fields of its JSON settings, e.g.
+// `"ephemeralTtl":"50ms"`. The app serves the power host routes whatever
+// its license.
+func newTestApp(t *testing.T, settings string) *App {
+	t.Helper()
+	jsonData := fmt.Sprintf(`{"seedDir":%q`, t.TempDir())
+	if settings != "" {
+		jsonData += "," + settings
+	}
+	inst, err := NewApp(context.Background(), backend.AppInstanceSettings{JSONData: []byte(jsonData + "}")})
+	if err != nil {
+		t.Fatalf("new app: %s", err)
+	}
+	app := inst.(*App)
+	t.Cleanup(app.Dispose)
+
+	r := mux.NewRouter()
+	app.registerRoutes(r)
+	app.registerWriteRoutes(r)
+	app.CallResourceHandler = httpadapter.New(r)
+	return app
+}
+
+// callResource sends req to app and returns the response.
+func callResource(t *testing.T, app *App, req *backend.CallResourceRequest) *backend.CallResourceResponse {
+	t.Helper()
+	var r mockCallResourceResponseSender
+	if err := app.CallResource(context.Background(), req, &r); err != nil {
+		t.Fatalf("%s: %s", req.Path, err)
+	}
+	return r.response
+}
+
+// call posts body to a resource route of app as the Grafana user login, or
+// without a user when login is "", and returns the status and body of the
+// response.
+func call(t *testing.T, app *App, login, path, body string) (int, string) {
+	t.Helper()
+	req := &backend.CallResourceRequest{Method: http.MethodPost, Path: path, Body: []byte(body)}
+	if login != "" {
+		req.PluginContext.User = &backend.User{Login: login}
+	}
+	res := callResource(t, app, req)
+	return res.Status, string(res.Body)
+}
+
+// callJSON posts body to a resource route of app, fails the test unless it
+// answers 200, and decodes the response into v unless v is nil.
+func callJSON(t *testing.T, app *App, path, body string, v interface{}) {
+	t.Helper()
+	status, res := call(t, app, "", path, body)
+	if status != http.StatusOK {
+		t.Fatalf("%s status should be %d, got %d: %s", path, http.StatusOK, status, res)
+	}
+	if v == nil {
+		return
+	}
+	if err := json.Unmarshal([]byte(res), v); err != nil {
+		t.Fatalf("%s: %s", path, err)
+	}
+}
+
 // TestCallResource tests CallResource calls, using backend.CallResourceRequest and backend.CallResourceResponse.
 // This ensures the httpadapter for CallResource works correctly.
 func TestCallResource(t *testing.T) {
@@ -0,0 +1,385 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mapgl-app/pkg/database"
+	"mapgl-app/pkg/resp"
+	"mapgl-app/pkg/util"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// startRespServer serves the configured seed file to Redis clients, so that
+// provisioning scripts can write map data with ordinary Redis tooling. The
+// listener requires the RESP token and keeps the seed file leased until
+// stopRespServer.
+func (a *App) startRespServer() error {
+	s := a.MapglSettings
+	if s.RespToken == "" {
+		return errors.New("respToken is not set")
+	}
+	if s.RespFileName == "" {
+		return errors.New("respFileName is not set")
+	}
+
+	store, release, err := a.openStore(a.bgCtx, s.RespFileName)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", s.RespFileName, err)
+	}
+
+	cmds := &seedCommands{
+		store:    store,
+		canWrite: a.canWrite,
+		edgeWritten: func(ctx context.Context, id string, parPath []interface{}, updatedAt float64) error {
+			return a.edgeWritten(ctx, store, s.RespFileName, id, parPath, updatedAt)
+		},
+		idWritten: func(ctx context.Context, tsId, name string, updatedAt float64) error {
+			return a.idWritten(ctx, store, tsId, name, updatedAt)
+		},
+		// RESP clients have no Grafana user, so any edit lock holds them off.
+		guard: func(ctx context.Context, kind, key string, write func() error) error {
+			msg, err := a.guardedWrite(ctx, store, kind, "", []string{key}, write)
+			if msg != "" {
+				return errors.New(msg)
+			}
+			return err
+		},
+	}
+	addr := net.JoinHostPort(s.RespHost, s.RespPort)
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		// Grafana disposes the previous instance, which may still hold the
+		// port, only after creating this one: take the port over once it is
+		// released.
+		a.respMu.Lock()
+		a.respErr = fmt.Errorf("waiting for %s: %w", addr, err)
+		a.respMu.Unlock()
+		log.DefaultLogger.Info("RESP port is busy, retrying", "addr", addr, "error", err)
+		a.goBackground(func(ctx context.Context) {
+			ln, err := util.ListenWhenFree(ctx.Done(), addr)
+			if err != nil {
+				release()
+				return
+			}
+			_ = a.serveResp(ln, cmds, release)
+		})
+		return nil
+	}
+	return a.serveResp(ln, cmds, release)
+}
+
+// serveResp starts the RESP server on ln, unless the instance is being
+// disposed. It hands the seed file back with release when it fails.
+func (a *App) serveResp(ln net.Listener, cmds *seedCommands, release func()) error {
+	a.respMu.Lock()
+	defer a.respMu.Unlock()
+
+	if err := a.bgCtx.Err(); err != nil {
+		ln.Close()
+		release()
+		return err
+	}
+	srv, err := resp.Serve(ln, a.MapglSettings.RespToken, cmds)
+	if err != nil {
+		release()
+		return err
+	}
+
+	a.respServer, a.respRelease, a.respErr = srv, release, nil
+	log.DefaultLogger.Info("RESP server listening", "addr", srv.Addr().String(), "fileName", a.MapglSettings.RespFileName)
+	return nil
+}
+
+// stopRespServer disconnects the RESP clients and releases the seed file.
+func (a *App) stopRespServer() {
+	a.respMu.Lock()
+	defer a.respMu.Unlock()
+
+	if a.respServer == nil {
+		return
+	}
+	if err := a.respServer.Close(); err != nil {
+		log.DefaultLogger.Error("Error stopping RESP server", "error", err)
+	}
+	a.respRelease()
+	a.respServer = nil
+}
+
+// seedCommands implements the Redis commands that make sense on a seed file.
+// Ids are string keys and edges are hashes, as written by the push routes;
+// every write re-scores the key in lastIds or lastEdges with the current
+// time so that replicating clients pull it. Writes run through guard, like
+// pushes, and fail on objects with an edit lock.
+type seedCommands struct {
+	store       database.Store
+	canWrite    bool
+	edgeWritten func(ctx context.Context, id string, parPath []interface{}, updatedAt float64) error
+	idWritten   func(ctx context.Context, tsId, name string, updatedAt float64) error
+	guard       func(ctx context.Context, kind, key string, write func() error) error
+}
+
+func (c *seedCommands) ServeRESP(ctx context.Context, w *resp.Writer, args []string) {
+	cmd := strings.ToUpper(args[0])
+	args = args[1:]
+
+	arity := map[string]int{
+		"GET": 1, "SET": 2, "HGET": 2, "HGETALL": 1, "HSET": 3, "HMSET": 3, "ZRANGEBYSCORE": 3,
+	}
+	n, known := arity[cmd]
+	switch {
+	case !known:
+		w.WriteError(fmt.Sprintf("ERR unknown or unsupported command '%s'", cmd))
+		return
+	case len(args) < n:
+		w.WriteError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(cmd)))
+		return
+	}
+
+	// Every command takes a key first. The metadata keys, such as edit
+	// locks, revisions and recorded push results, are off limits.
+	if reservedKey(args[0]) {
+		w.WriteError(fmt.Sprintf("ERR key %s is reserved for plugin metadata", args[0]))
+		return
+	}
+
+	var err error
+	switch cmd {
+	case "GET":
+		err = c.get(ctx, w, args[0])
+	case "SET":
+		err = c.set(ctx, w, args)
+	case "HGET":
+		err = c.hget(ctx, w, args[0], args[1])
+	case "HGETALL":
+		err = c.hgetall(ctx, w, args[0])
+	case "HSET", "HMSET":
+		err = c.hset(ctx, w, cmd, args)
+	case "ZRANGEBYSCORE":
+		err = c.zrangeByScore(ctx, w, args)
+	}
+	if err != nil {
+		w.WriteError("ERR " + err.Error())
+	}
+}
+
+// checkWrite rejects writes without a power host license and writes to the
+// indexes the plugin maintains itself.
+func (c *seedCommands) checkWrite(key string) error {
+	switch {
+	case !c.canWrite:
+		return errors.New("seed file is read-only: the license has no power host")
+	case key == database.IdsIndex || key == database.EdgesIndex:
+		return fmt.Errorf("%s is maintained by the plugin", key)
+	}
+	return nil
+}
+
+func (c *seedCommands) get(ctx context.Context, w *resp.Writer, key string) error {
+	val, err := c.store.Get(ctx, key)
+	if errors.Is(err, database.ErrNotFound) {
+		w.WriteNull()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	w.WriteBulk(val)
+	return nil
+}
+
+// set writes an id name. Options such as EX or NX are not supported.
+func (c *seedCommands) set(ctx context.Context, w *resp.Writer, args []string) error {
+	if len(args) != 2 {
+		return errors.New("SET options are not supported")
+	}
+	key, name := args[0], args[1]
+	if err := c.checkWrite(key); err != nil {
+		return err
+	}
+
+	err := c.guard(ctx, "id", key, func() error {
+		if err := c.store.Set(ctx, key, name); err != nil {
+			return err
+		}
+		score := nowScore()
+		if err := c.store.ZAdd(ctx, database.IdsIndex, key, score); err != nil {
+			return err
+		}
+		return c.idWritten(ctx, key, name, score)
+	})
+	if err != nil {
+		return err
+	}
+	w.WriteSimple("OK")
+	return nil
+}
+
+func (c *seedCommands) hget(ctx context.Context, w *resp.Writer, key, field string) error {
+	fields, err := c.store.HGetAll(ctx, key)
+	if err != nil {
+		return err
+	}
+	if val, ok := fields[field]; ok {
+		w.WriteBulk(val)
+	} else {
+		w.WriteNull()
+	}
+	return nil
+}
+
+func (c *seedCommands) hgetall(ctx context.Context, w *resp.Writer, key string) error {
+	fields, err := c.store.HGetAll(ctx, key)
+	if err != nil {
+		return err
+	}
+	w.WriteArray(2 * len(fields))
+	for field, val := range fields {
+		w.WriteBulk(field)
+		w.WriteBulk(val)
+	}
+	return nil
+}
+
+// hset writes edge fields. parPath must be a JSON array, deleted a boolean
+// and props a valid properties object, as the pull routes expect; new edges
+// default to not deleted. The fields the server maintains, length, bbox and
+// expiresAt, are not writable.
+func (c *seedCommands) hset(ctx context.Context, w *resp.Writer, cmd string, args []string) error {
+	key := args[0]
+	if len(args)%2 != 1 {
+		return fmt.Errorf("wrong number of arguments for '%s' command", strings.ToLower(cmd))
+	}
+	if err := c.checkWrite(key); err != nil {
+		return err
+	}
+
+	values := make(map[string]any, len(args)/2)
+	var parPath []interface{}
+	for i := 1; i < len(args); i += 2 {
+		field, val := args[i], args[i+1]
+		switch field {
+		case "parPath":
+			if err := json.Unmarshal([]byte(val), &parPath); err != nil {
+				return fmt.Errorf("parPath is not a JSON array: %w", err)
+			}
+		case database.PropsField:
+			var props map[string]interface{}
+			if err := json.Unmarshal([]byte(val), &props); err != nil {
+				return fmt.Errorf("props is not a JSON object: %w", err)
+			}
+			if err := database.ValidateProps(props); err != nil {
+				return err
+			}
+		case database.LengthField, database.BBoxField, database.ExpiresAtField:
+			return fmt.Errorf("%s is maintained by the server and cannot be written", field)
+		case "deleted", "isEph":
+			b, err := strconv.ParseBool(val)
+			if err != nil {
+				return fmt.Errorf("%s is not a boolean: %q", field, val)
+			}
+			values[field] = b
+			continue
+		}
+		values[field] = val
+	}
+
+	if parPath != nil {
+		for field, val := range database.EdgeMetrics(parPath) {
+			values[field] = val
+		}
+	}
+
+	added := 0
+	err := c.guard(ctx, "edge", key, func() error {
+		existing, err := c.store.HGetAll(ctx, key)
+		if err != nil {
+			return err
+		}
+		if _, ok := values["deleted"]; !ok && existing["deleted"] == "" {
+			values["deleted"] = false
+		}
+		for i := 1; i < len(args); i += 2 {
+			if _, ok := existing[args[i]]; !ok {
+				added++
+			}
+		}
+
+		if err := c.store.HSet(ctx, key, values); err != nil {
+			return err
+		}
+		score := nowScore()
+		if err := c.edgeWritten(ctx, key, parPath, score); err != nil {
+			return err
+		}
+		return c.store.ZAdd(ctx, database.EdgesIndex, key, score)
+	})
+	if err != nil {
+		return err
+	}
+
+	if cmd == "HMSET" {
+		w.WriteSimple("OK")
+	} else {
+		w.WriteInt(int64(added))
+	}
+	return nil
+}
+
+// zrangeByScore reads the replication indexes, e.g. to see what changed.
+func (c *seedCommands) zrangeByScore(ctx context.Context, w *resp.Writer, args []string) error {
+	min, err := parseScoreBound(args[1])
+	if err != nil {
+		return err
+	}
+	max, err := parseScoreBound(args[2])
+	if err != nil {
+		return err
+	}
+	withScores := len(args) > 3 && strings.EqualFold(args[3], "WITHSCORES")
+
+	members, err := c.store.ZRangeByScore(ctx, args[0], min, max)
+	if err != nil {
+		return err
+	}
+	if withScores {
+		w.WriteArray(2 * len(members))
+	} else {
+		w.WriteArray(len(members))
+	}
+	for _, m := range members {
+		w.WriteBulk(m.Member)
+		if withScores {
+			w.WriteBulk(strconv.FormatFloat(m.Score, 'f', -1, 64))
+		}
+	}
+	return nil
+}
+
+// parseScoreBound reads an inclusive ZRANGEBYSCORE bound; exclusive "(" bounds
+// are not supported.
+func parseScoreBound(s string) (float64, error) {
+	switch strings.ToLower(s) {
+	case "-inf":
+		return math.Inf(-1), nil
+	case "+inf", "inf":
+		return math.Inf(1), nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, errors.New("min or max is not a float")
+	}
+	return f, nil
+}
+
+// nowScore is the replication score of a server-side write.
+func nowScore() float64 {
+	return float64(time.Now().UnixMilli())
+}
@@ -0,0 +1,132 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mapgl-app/pkg/resp"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// TestRespServer writes through the RESP listener with a Redis client and
+// checks that the writes replicate through pullIds/pullEdges, that the
+// listener requires the token, and that Dispose unbinds it.
+func TestRespServer(t *testing.T) {
+	port := freePort(t)
+	inst, err := NewApp(context.Background(), backend.AppInstanceSettings{
+		JSONData: []byte(fmt.Sprintf(`{"seedDir":%q,"respEnabled":true,"respPort":%q,"respFileName":"resp"}`,
+			t.TempDir(), port)),
+		DecryptedSecureJSONData: map[string]string{"respToken": "s3cret"},
+	})
+	if err != nil {
+		t.Fatalf("new app: %s", err)
+	}
+	app := inst.(*App)
+	if app.respErr != nil {
+		t.Fatalf("RESP server did not start: %s", app.respErr)
+	}
+	// The test token has no power host; allow writes as a licensed host would.
+	app.respServer.Close()
+	app.respRelease()
+	app.canWrite = true
+	if err := app.startRespServer(); err != nil {
+		t.Fatalf("restart RESP server: %s", err)
+	}
+
+	ctx := context.Background()
+	addr := net.JoinHostPort("127.0.0.1", port)
+
+	anon := resp.NewClient(resp.ClientOptions{Addr: addr})
+	defer anon.Close()
+	if _, err := anon.Do(ctx, "GET", "ts1"); err == nil {
+		t.Error("commands without AUTH should be rejected")
+	}
+
+	// Oversized values are refused before they are read, and end the
+	// connection.
+	for _, header := range []string{"*1\r\n$2000000\r\n", "*100000000\r\n", "*1\r\n*1\r\n$4\r\nPING\r\n"} {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("dial: %s", err)
+		}
+		conn.Write([]byte(header))
+		reply, _ := io.ReadAll(conn)
+		conn.Close()
+		if !strings.HasPrefix(string(reply), "-ERR Protocol error") {
+			t.Errorf("%q should be refused with a protocol error, got %q", header, reply)
+		}
+	}
+
+	client := resp.NewClient(resp.ClientOptions{Addr: addr, Password: "s3cret"})
+	defer client.Close()
+
+	for _, args := range [][]string{
+		{"SET", "ts1", "Router 1"},
+		{"HSET", "e1", "parPath", `["Router 1",[30.1,60.2],"Router 2"]`},
+	} {
+		if _, err := client.Do(ctx, args...); err != nil {
+			t.Fatalf("%v: %s", args, err)
+		}
+	}
+	for _, args := range [][]string{
+		{"HSET", "e2", "parPath", "not json"},
+		{"HSET", "e2", "deleted", "maybe"},
+		{"SET", "lastIds", "x"},
+		{"SET", "_schemaVersion", "9"},
+		{"DEL", "ts1"},
+	} {
+		if _, err := client.Do(ctx, args...); err == nil {
+			t.Errorf("%v should be rejected", args)
+		}
+	}
+	if v, err := client.Do(ctx, "HGET", "e1", "deleted"); err != nil || string(v.([]byte)) != "0" {
+		t.Errorf("new edges should default to deleted=0, got %v, %v", v, err)
+	}
+	for _, args := range [][]string{
+		{"HGETALL", "_revs"},
+		{"GET", "_schemaVersion"},
+		{"ZRANGEBYSCORE", "_ephemeralExpiry", "-inf", "+inf"},
+	} {
+		if _, err := client.Do(ctx, args...); err == nil {
+			t.Errorf("%v should not read plugin metadata", args)
+		}
+	}
+
+	// Writes respect edit locks like pushes do.
+	store, release, err := app.openStore(ctx, "resp")
+	if err != nil {
+		t.Fatalf("open: %s", err)
+	}
+	lock, _ := json.Marshal(EditLock{Kind: "edge", Key: "e1", User: "alice", ExpiresAt: nowScore() + 60000})
+	err = store.HSet(ctx, locksKey, map[string]any{objectField("edge", "e1"): string(lock)})
+	release()
+	if err != nil {
+		t.Fatalf("lock: %s", err)
+	}
+	if _, err := client.Do(ctx, "HSET", "e1", "deleted", "1"); err == nil || !strings.Contains(err.Error(), "locked by alice") {
+		t.Errorf("HSET of a locked edge should be refused, got %v", err)
+	}
+
+	var ids []RedisIdItem
+	callJSON(t, app, "pullIds", `{"fileName":"resp","minTimestamp":1}`, &ids)
+	if len(ids) != 1 || ids[0].ID != "ts1" || ids[0].Name != "Router 1" || ids[0].Score <= 1 {
+		t.Errorf("SET should be indexed in lastIds, got %+v", ids)
+	}
+	var edges []NewEdgeDoc
+	callJSON(t, app, "pullEdges", `{"fileName":"resp","minTimestamp":1}`, &edges)
+	if len(edges) != 1 || edges[0].Id != "e1" || len(edges[0].ParPath) != 3 || edges[0].Deleted {
+		t.Errorf("HSET should be indexed in lastEdges, got %+v", edges)
+	}
+
+	app.Dispose()
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("RESP port still bound after Dispose: %s", err)
+	}
+	ln.Close()
+}
@@ -0,0 +1,160 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mapgl-app/pkg/database"
+	"strconv"
+)
+
+// revsKey is the hash of the revisions of the ids and edges of a seed file,
+// by objectField. A revision counts the server-side writes of an object,
+// whatever their updatedAt; objects never written have revision 0.
+const revsKey = "_revs"
+
+// bumpRev increments the revision of an object after a write.
+func bumpRev(ctx context.Context, store database.Store, kind, key string) error {
+	_, err := store.HIncrBy(ctx, revsKey, objectField(kind, key), 1)
+	return err
+}
+
+// loadRevs returns the revisions of all the objects of a seed file, by
+// objectField.
+func loadRevs(ctx context.Context, store database.Store) (map[string]int64, error) {
+	return readRevs(ctx, store, revsKey)
+}
+
+// objectRevs returns the revisions of the objects of kind with keys, by
+// objectField. Pulls and pushes only read those of the objects they return.
+func objectRevs(ctx context.Context, store database.Store, kind string, keys []string) (map[string]int64, error) {
+	fields, err := store.HMGet(ctx, revsKey, objectFields(kind, keys)...)
+	if err != nil {
+		return nil, err
+	}
+	return parseRevs(fields), nil
+}
+
+// readRevs returns a hash of revisions by objectField, such as revsKey.
+func readRevs(ctx context.Context, store database.Store, key string) (map[string]int64, error) {
+	fields, err := store.HGetAll(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return parseRevs(fields), nil
+}
+
+func parseRevs(fields map[string]string) map[string]int64 {
+	revs := make(map[string]int64, len(fields))
+	for field, val := range fields {
+		revs[field], _ = strconv.ParseInt(val, 10, 64)
+	}
+	return revs
+}
+
+// conditionalRedisMsg refuses pushes with an expected _rev on the Redis
+// backend: replicas on other hosts write the same keys, so pushMu can't make
+// the check and the write atomic there.
+const conditionalRedisMsg = "conditional pushes (_rev) need the seed database backend"
+
+// RevConflict is the 409 answer of a push whose expected _rev doesn't match:
+// the current documents of the mismatched objects, in the pull format.
+type RevConflict struct {
+	Error     string        `json:"error"`
+	Conflicts []interface{} `json:"conflicts"`
+}
+
+// checkIdRevs returns the conflict of a push of ids whose expected _rev is
+// not the current one, or nil.
+func checkIdRevs(ctx context.Context, store database.Store, docs []NewIdDoc) (*RevConflict, error) {
+	if !idRevs(docs) {
+		return nil, nil
+	}
+	revs, err := objectRevs(ctx, store, "id", idKeys(docs))
+	if err != nil {
+		return nil, err
+	}
+	var conflict RevConflict
+	for _, doc := range docs {
+		rev := revs[objectField("id", doc.TsId)]
+		if doc.Rev == nil || *doc.Rev == rev {
+			continue
+		}
+		current := RedisIdItem{ID: doc.TsId, Rev: rev}
+		if current.Name, err = store.Get(ctx, doc.TsId); err != nil && !errors.Is(err, database.ErrNotFound) {
+			return nil, err
+		}
+		if current.Score, err = store.ZScore(ctx, database.IdsIndex, doc.TsId); err != nil && !errors.Is(err, database.ErrNotFound) {
+			return nil, err
+		}
+		conflict.add(current, fmt.Sprintf("id %s is at _rev %d, not %d", doc.TsId, rev, *doc.Rev))
+	}
+	return conflict.orNil(), nil
+}
+
+// checkEdgeRevs returns the conflict of a push of edges whose expected _rev
+// is not the current one, or nil.
+func checkEdgeRevs(ctx context.Context, store database.Store, docs []NewEdgeDoc) (*RevConflict, error) {
+	if !edgeRevs(docs) {
+		return nil, nil
+	}
+	revs, err := objectRevs(ctx, store, "edge", edgeKeys(docs))
+	if err != nil {
+		return nil, err
+	}
+	var conflict RevConflict
+	for _, doc := range docs {
+		rev := revs[objectField("edge", doc.Id)]
+		if doc.Rev == nil || *doc.Rev == rev {
+			continue
+		}
+		fields, err := store.HGetAll(ctx, doc.Id)
+		if err != nil {
+			return nil, err
+		}
+		current := edgeDoc(fields)
+		current["id"], current["_rev"] = doc.Id, rev
+		score, err := store.ZScore(ctx, database.EdgesIndex, doc.Id)
+		if err != nil && !errors.Is(err, database.ErrNotFound) {
+			return nil, err
+		}
+		current["updatedAt"] = score
+		conflict.add(current, fmt.Sprintf("edge %s is at _rev %d, not %d", doc.Id, rev, *doc.Rev))
+	}
+	return conflict.orNil(), nil
+}
+
+// idRevs tells whether a push of ids is conditional: one of docs has an
+// expected _rev.
+func idRevs(docs []NewIdDoc) bool {
+	for _, doc := range docs {
+		if doc.Rev != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// edgeRevs tells whether a push of edges is conditional.
+func edgeRevs(docs []NewEdgeDoc) bool {
+	for _, doc := range docs {
+		if doc.Rev != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *RevConflict) add(current interface{}, msg string) {
+	if c.Error == "" {
+		c.Error = msg
+	}
+	c.Conflicts = append(c.Conflicts, current)
+}
+
+func (c *RevConflict) orNil() *RevConflict {
+	if len(c.Conflicts) == 0 {
+		return nil
+	}
+	return c
+}
@@ -0,0 +1,76 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestRevisions pushes ids and edges with and without expected revisions.
+func TestRevisions(t *testing.T) {
+	app := newTestApp(t, "")
+	edge := func(rev string, lon int) string {
+		return fmt.Sprintf(`{"fileName":"revs","newDocs":[{"id":"e1","parPath":["a",[%d,1],"b"],"updatedAt":1%s}]}`, lon, rev)
+	}
+
+	status, body := call(t, app, "", "pushEdges", edge(`,"_rev":0`, 1))
+	if status != http.StatusOK || !strings.Contains(body, `"_rev":1`) {
+		t.Fatalf("creating e1 at _rev 0 should answer _rev 1, got %d %s", status, body)
+	}
+	if status, body := call(t, app, "", "pushEdges", edge(`,"_rev":1`, 2)); status != http.StatusOK || !strings.Contains(body, `"_rev":2`) {
+		t.Fatalf("push at the current _rev should pass, got %d %s", status, body)
+	}
+
+	// Same timestamp, stale revision: refused with the current document.
+	status, body = call(t, app, "", "pushEdges", edge(`,"_rev":1`, 3))
+	var conflict RevConflict
+	if status != http.StatusConflict || json.Unmarshal([]byte(body), &conflict) != nil || len(conflict.Conflicts) != 1 {
+		t.Fatalf("stale push should conflict, got %d %s", status, body)
+	}
+	current, _ := conflict.Conflicts[0].(map[string]interface{})
+	if current["_rev"] != 2.0 || fmt.Sprint(current["parPath"]) != "[a [2 1] b]" {
+		t.Errorf("conflict should carry the current e1, got %v", current)
+	}
+	if status, _ := call(t, app, "", "pushEdges", edge(`,"_rev":0`, 3)); status != http.StatusConflict {
+		t.Errorf("_rev 0 should only create, got %d", status)
+	}
+	if status, body := call(t, app, "", "pushEdges", edge("", 4)); status != http.StatusOK || !strings.Contains(body, `"_rev":3`) {
+		t.Errorf("push without _rev should always pass, got %d %s", status, body)
+	}
+
+	var items []map[string]interface{}
+	callJSON(t, app, "pullEdges", `{"fileName":"revs"}`, &items)
+	if len(items) != 1 || items[0]["_rev"] != 3.0 {
+		t.Errorf("pull should return _rev 3, got %v", items)
+	}
+
+	ids := `{"fileName":"revs","newDocs":[{"tsId":"a","name":"A","updatedAt":1,"_rev":%d}]}`
+	if status, body := call(t, app, "", "pushIds", fmt.Sprintf(ids, 0)); status != http.StatusOK || !strings.Contains(body, `"_rev":1`) {
+		t.Fatalf("id push should answer _rev 1, got %d %s", status, body)
+	}
+	if status, body := call(t, app, "", "pushIds", fmt.Sprintf(ids, 0)); status != http.StatusConflict || !strings.Contains(body, `"name":"A"`) {
+		t.Errorf("stale id push should conflict with the current id, got %d %s", status, body)
+	}
+	var pulled []RedisIdItem
+	callJSON(t, app, "pullIds", `{"fileName":"revs"}`, &pulled)
+	if len(pulled) != 1 || pulled[0].Rev != 1 {
+		t.Errorf("pull should return id a at _rev 1, got %+v", pulled)
+	}
+}
+
+// TestRevisionsRedis checks that the Redis backend refuses conditional
+// pushes before touching the server.
+func TestRevisionsRedis(t *testing.T) {
+	app := newTestApp(t, `"storageBackend":"redis","redisAddr":"127.0.0.1:1"`)
+
+	for route, push := range map[string]string{
+		"pushIds":   `{"fileName":"revs","newDocs":[{"tsId":"a","name":"A","updatedAt":1,"_rev":0}]}`,
+		"pushEdges": `{"fileName":"revs","newDocs":[{"id":"e1","parPath":["a",[1,1],"b"],"updatedAt":1,"_rev":0}]}`,
+	} {
+		if status, body := call(t, app, "", route, push); status != http.StatusBadRequest || !strings.Contains(body, "_rev") {
+			t.Errorf("%s with _rev should be refused on Redis, got %d %s", route, status, body)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package plugin
+
+import (
+	"mapgl-app/pkg/database"
+	"net/http"
+	"strconv"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Result limits of /search.
+const (
+	searchLimit    = 20
+	maxSearchLimit = 100
+)
+
+// handleSearch finds ids and edges by name, edge id or property value:
+// GET /search?fileName=<seed>&q=<words>&limit=<n>. Words match as prefixes
+// first, then fuzzily; hits carry a bbox for the panel to zoom to.
+func (a *App) handleSearch(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := req.URL.Query()
+	fileName, q := query.Get("fileName"), query.Get("q")
+	if fileName == "" || q == "" {
+		http.Error(w, "fileName and q are required", http.StatusBadRequest)
+		return
+	}
+	limit := searchLimit
+	if s := query.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxSearchLimit {
+			http.Error(w, "limit must be between 1 and "+strconv.Itoa(maxSearchLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	ctx, span := startSpan(req.Context(), "search",
+		attribute.String("fileName", fileName),
+		attribute.Int("limit", limit),
+	)
+	defer span.End()
+
+	store, release, err := a.openStore(ctx, fileName)
+	if err != nil {
+		log.DefaultLogger.Error("Failed to get database connection:", "filename", fileName, "error", err)
+		http.Error(w, "failed to get database connection", http.StatusInternalServerError)
+		return
+	}
+	defer release()
+
+	searcher, ok := store.(database.Searcher)
+	if !ok {
+		http.Error(w, "search needs the sqlite storage backend", http.StatusNotImplemented)
+		return
+	}
+	hits, err := searcher.Search(ctx, q, limit)
+	if err != nil {
+		tracing.Error(span, err)
+		log.DefaultLogger.Error("Failed to search", "filename", fileName, "error", err)
+		http.Error(w, "failed to search", http.StatusInternalServerError)
+		return
+	}
+	if hits == nil {
+		hits = []database.SearchHit{}
+	}
+	span.SetAttributes(attribute.Int("hits", len(hits)))
+
+	writeJSON(ctx, w, hits)
+}
@@ -0,0 +1,50 @@
+package plugin
+
+import (
+	"encoding/json"
+	"mapgl-app/pkg/database"
+	"net/http"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// TestSearchRoute pushes ids and edges and finds them through /search.
+func TestSearchRoute(t *testing.T) {
+	app := newTestApp(t, "")
+
+	for path, body := range map[string]string{
+		"pushIds":   `{"fileName":"find","newDocs":[{"tsId":"ts1","name":"Riverside POP","updatedAt":5}]}`,
+		"pushEdges": `{"fileName":"find","newDocs":[{"id":"e1","parPath":["ts1",[1,2],[3,4],"x"],"updatedAt":7,"props":{"vendor":"Nordfiber"}}]}`,
+	} {
+		callJSON(t, app, path, body, nil)
+	}
+
+	search := func(q string) (int, []database.SearchHit) {
+		res := callResource(t, app, &backend.CallResourceRequest{
+			Method: http.MethodGet,
+			Path:   "search",
+			URL:    "search?fileName=find&q=" + q,
+		})
+		var hits []database.SearchHit
+		if res.Status == http.StatusOK {
+			if err := json.Unmarshal(res.Body, &hits); err != nil {
+				t.Fatalf("decode: %s", err)
+			}
+		}
+		return res.Status, hits
+	}
+
+	if _, hits := search("river"); len(hits) != 1 || hits[0].Key != "ts1" || hits[0].BBox == nil || hits[0].BBox.MinLon != 1 {
+		t.Errorf("river should find ts1 at 1,2, got %+v", hits)
+	}
+	if _, hits := search("nordfibre"); len(hits) != 1 || hits[0].Key != "e1" || hits[0].Match != "fuzzy" {
+		t.Errorf("nordfibre should find e1 fuzzily, got %+v", hits)
+	}
+	if status, hits := search("nothing+like+it"); status != http.StatusOK || hits == nil || len(hits) != 0 {
+		t.Errorf("want an empty list, got %d %+v", status, hits)
+	}
+	if status, _ := search(""); status != http.StatusBadRequest {
+		t.Errorf("empty query should be a bad request, got %d", status)
+	}
+}
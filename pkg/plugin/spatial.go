@@ -0,0 +1,213 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"mapgl-app/pkg/database"
+	"mapgl-app/pkg/geo"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// queryEdges returns the edges intersecting a bounding box, in the pullEdges
+// format: GET /queryEdges?fileName=<seed>&bbox=minLon,minLat,maxLon,maxLat
+// with an optional minTimestamp to pull only the recent changes of an area,
+// tolerance or zoom to simplify the paths and a props JSON object to filter
+// them as pullEdges does.
+func (a *App) queryEdges(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := req.URL.Query()
+	fileName := query.Get("fileName")
+	if fileName == "" {
+		http.Error(w, "fileName is required", http.StatusBadRequest)
+		return
+	}
+	bbox, err := geo.ParseBBox(query.Get("bbox"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var minTimestamp int64
+	if s := query.Get("minTimestamp"); s != "" {
+		if minTimestamp, err = strconv.ParseInt(s, 10, 64); err != nil {
+			http.Error(w, "invalid minTimestamp: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var tolerance, zoom *float64
+	for name, dst := range map[string]**float64{"tolerance": &tolerance, "zoom": &zoom} {
+		if s := query.Get(name); s != "" {
+			f, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				http.Error(w, "invalid "+name+": "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			*dst = &f
+		}
+	}
+	tol, err := pullTolerance(tolerance, zoom)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var props map[string]interface{}
+	if s := query.Get("props"); s != "" {
+		if err := json.Unmarshal([]byte(s), &props); err != nil {
+			http.Error(w, "invalid props: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx, span := startSpan(req.Context(), "queryEdges",
+		attribute.String("fileName", fileName),
+		attribute.Int64("minTimestamp", minTimestamp),
+		attribute.Float64Slice("bbox", []float64{bbox.MinLon, bbox.MinLat, bbox.MaxLon, bbox.MaxLat}),
+	)
+	defer span.End()
+
+	store, release, err := a.openStore(ctx, fileName)
+	if err != nil {
+		log.DefaultLogger.Error("Failed to get database connection:", "filename", fileName, "error", err)
+		http.Error(w, "failed to get database connection", http.StatusInternalServerError)
+		return
+	}
+	defer release()
+
+	edges, err := loadEdges(ctx, store, float64(minTimestamp), &bbox)
+	if err != nil {
+		log.DefaultLogger.Error("Failed to query edges", "filename", fileName, "error", err)
+		http.Error(w, "failed to read edges", http.StatusInternalServerError)
+		return
+	}
+	edges = filterProps(edges, props)
+	simplifyEdges(ctx, edges, tol)
+	span.SetAttributes(attribute.Int("items", len(edges)))
+
+	writeJSON(ctx, w, edges)
+}
+
+// filterProps keeps the edges whose props match filter, see
+// database.MatchProps. Tombstones are always kept: their props may have
+// been cleared, and clients must still pull the removal.
+func filterProps(items []map[string]interface{}, filter map[string]interface{}) []map[string]interface{} {
+	if len(filter) == 0 {
+		return items
+	}
+	kept := items[:0]
+	for _, item := range items {
+		props, _ := item["props"].(map[string]interface{})
+		if deleted, _ := item["_deleted"].(bool); deleted || database.MatchProps(props, filter) {
+			kept = append(kept, item)
+		}
+	}
+	return kept
+}
+
+// pullTolerance resolves the optional simplification parameters of a pull.
+// An explicit tolerance in degrees wins over a zoom level; zero means full
+// resolution.
+func pullTolerance(tolerance, zoom *float64) (float64, error) {
+	switch {
+	case tolerance != nil:
+		if *tolerance < 0 || math.IsNaN(*tolerance) {
+			return 0, errors.New("tolerance must not be negative")
+		}
+		return *tolerance, nil
+	case zoom != nil:
+		if *zoom < 0 || *zoom > 30 || math.IsNaN(*zoom) {
+			return 0, errors.New("zoom must be between 0 and 30")
+		}
+		return geo.ZoomTolerance(*zoom), nil
+	}
+	return 0, nil
+}
+
+// simplifyEdges replaces the parPath of every pulled edge by a simplified
+// copy, and marks the edges that lost points as simplified so that clients
+// don't push them back. Stored paths are never touched.
+func simplifyEdges(ctx context.Context, items []map[string]interface{}, tolerance float64) {
+	if tolerance <= 0 {
+		return
+	}
+	_, span := startSpan(ctx, "geo.SimplifyPath", attribute.Int("items", len(items)))
+	defer span.End()
+
+	for _, item := range items {
+		parPath, ok := item["parPath"].([]interface{})
+		if !ok {
+			continue
+		}
+		simplified := geo.SimplifyPath(parPath, tolerance)
+		item["parPath"] = simplified
+		if len(simplified) < len(parPath) {
+			item["simplified"] = true
+		}
+	}
+}
+
+// edgeWritten updates what the plugin derives from an edge after its hash
+// in fileName was written, by a push or through the RESP server. updatedAt
+// is the score the edge gets in lastEdges.
+func (a *App) edgeWritten(ctx context.Context, store database.Store, fileName, id string, parPath []interface{}, updatedAt float64) error {
+	if err := bumpRev(ctx, store, "edge", id); err != nil {
+		return err
+	}
+	if err := indexEdge(ctx, store, id, parPath); err != nil {
+		return err
+	}
+	fields, err := store.HGetAll(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := a.scheduleExpiry(ctx, store, fileName, id, fields); err != nil {
+		return err
+	}
+	if rel, ok := store.(database.Relational); ok {
+		if err := rel.SyncEdge(ctx, database.NewEdgeRow(id, fields, updatedAt)); err != nil {
+			return err
+		}
+	}
+
+	var bbox *geo.BBox
+	if b, ok := geo.Bounds(geo.PathPoints(parPath)); ok {
+		bbox = &b
+	}
+	a.tiles.invalidateEdge(fileName, id, bbox)
+	return nil
+}
+
+// idWritten updates what the plugin derives from an id after it was set.
+func (a *App) idWritten(ctx context.Context, store database.Store, tsId, name string, updatedAt float64) error {
+	if err := bumpRev(ctx, store, "id", tsId); err != nil {
+		return err
+	}
+	if rel, ok := store.(database.Relational); ok {
+		return rel.SyncId(ctx, database.IdRow{TsId: tsId, Name: name, UpdatedAt: updatedAt})
+	}
+	return nil
+}
+
+// indexEdge updates the bounding box of a pushed edge when the store keeps
+// a spatial index. Paths without coordinates, as sent for deleted edges,
+// keep the previous box so that the tombstone still matches its area.
+func indexEdge(ctx context.Context, store database.Store, id string, parPath []interface{}) error {
+	idx, ok := store.(database.SpatialIndex)
+	if !ok {
+		return nil
+	}
+	bbox, ok := geo.Bounds(geo.PathPoints(parPath))
+	if !ok {
+		return nil
+	}
+	return idx.IndexEdge(ctx, id, bbox)
+}
@@ -0,0 +1,161 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"mapgl-app/pkg/database"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// TestQueryEdges checks that /queryEdges and the bbox of pullEdges return
+// only the edges of the area, including the tombstones of edges that were
+// there.
+func TestQueryEdges(t *testing.T) {
+	app := newTestApp(t, "")
+
+	ctx := context.Background()
+	db, err := app.openDB(ctx, "bbox")
+	if err != nil {
+		t.Fatalf("open: %s", err)
+	}
+	for id, parPath := range map[string][]interface{}{
+		"paris":  {"a", []interface{}{2.35, 48.85}, []interface{}{2.29, 48.86}, "b"},
+		"berlin": {"c", []interface{}{13.40, 52.52}, "d"},
+		"lyon":   {"e", []interface{}{4.83, 45.76}, "f"},
+	} {
+		if err := db.HSet(ctx, id, map[string]any{"deleted": false, "parPath": mustJSON(t, parPath)}); err != nil {
+			t.Fatalf("seed: %s", err)
+		}
+		if err := indexEdge(ctx, db, id, parPath); err != nil {
+			t.Fatalf("index: %s", err)
+		}
+		if err := db.ZAdd(ctx, database.EdgesIndex, id, 10); err != nil {
+			t.Fatalf("seed: %s", err)
+		}
+	}
+	// lyon is deleted without coordinates and keeps its box.
+	if err := db.HSet(ctx, "lyon", map[string]any{"deleted": true, "parPath": "[]"}); err != nil {
+		t.Fatalf("delete: %s", err)
+	}
+	if err := indexEdge(ctx, db, "lyon", nil); err != nil {
+		t.Fatalf("index: %s", err)
+	}
+	db.Release()
+
+	res := callResource(t, app, &backend.CallResourceRequest{
+		Method: http.MethodGet,
+		Path:   "queryEdges",
+		URL:    "queryEdges?fileName=bbox&bbox=-5,42,8,51",
+	})
+	if res.Status != http.StatusOK {
+		t.Fatalf("queryEdges: %+v", res)
+	}
+	var edges []NewEdgeDoc
+	if err := json.Unmarshal(res.Body, &edges); err != nil {
+		t.Fatalf("decode: %s", err)
+	}
+	ids := map[string]bool{}
+	for _, e := range edges {
+		ids[e.Id] = true
+	}
+	if len(edges) != 2 || !ids["paris"] || !ids["lyon"] {
+		t.Errorf("queryEdges should return paris and the lyon tombstone, got %+v", edges)
+	}
+
+	edges = nil
+	callJSON(t, app, "pullEdges", `{"fileName":"bbox","minTimestamp":0,"bbox":[13,52,14,53]}`, &edges)
+	if len(edges) != 1 || edges[0].Id != "berlin" {
+		t.Errorf("pullEdges with bbox should return berlin only, got %+v", edges)
+	}
+
+	res = callResource(t, app, &backend.CallResourceRequest{
+		Method: http.MethodGet,
+		Path:   "queryEdges",
+		URL:    "queryEdges?fileName=bbox&bbox=8,42,-5,51",
+	})
+	if res.Status != http.StatusBadRequest {
+		t.Errorf("inverted bbox should be a bad request, got %d", res.Status)
+	}
+}
+
+func mustJSON(t *testing.T, v interface{}) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("marshal: %s", err)
+	}
+	return string(b)
+}
+
+// TestPullEdgesSimplify checks that zoom and tolerance simplify pulled paths
+// while the stored path stays at full resolution, and that simplified paths
+// can't be pushed back.
+func TestPullEdgesSimplify(t *testing.T) {
+	app := newTestApp(t, "")
+
+	ctx := context.Background()
+	parPath := []interface{}{"a"}
+	for i := 0; i <= 100; i++ {
+		// A gentle wiggle of about 10 m along a 10 km line.
+		parPath = append(parPath, []interface{}{2.0 + float64(i)*0.001, 48.0 + 0.0001*float64(i%2)})
+	}
+	parPath = append(parPath, "b")
+
+	db, err := app.openDB(ctx, "simplify")
+	if err != nil {
+		t.Fatalf("open: %s", err)
+	}
+	if err := db.HSet(ctx, "e1", map[string]any{"deleted": false, "parPath": mustJSON(t, parPath)}); err != nil {
+		t.Fatalf("seed: %s", err)
+	}
+	if err := db.ZAdd(ctx, database.EdgesIndex, "e1", 1); err != nil {
+		t.Fatalf("seed: %s", err)
+	}
+	db.Release()
+
+	var edges []NewEdgeDoc
+	pathLen := func(body string) int {
+		t.Helper()
+		edges = nil
+		callJSON(t, app, "pullEdges", body, &edges)
+		if len(edges) != 1 {
+			t.Fatalf("%s: want 1 edge, got %d", body, len(edges))
+		}
+		p := edges[0].ParPath
+		if p[0] != "a" || p[len(p)-1] != "b" {
+			t.Errorf("%s: node names must be kept, got %v ... %v", body, p[0], p[len(p)-1])
+		}
+		if simplified := len(p) < 103; edges[0].Simplified != simplified {
+			t.Errorf("%s: simplified should be %v, got %v", body, simplified, edges[0].Simplified)
+		}
+		return len(p)
+	}
+
+	if n := pathLen(`{"fileName":"simplify"}`); n != 103 {
+		t.Errorf("plain pull should return the full path, got %d elements", n)
+	}
+	if n := pathLen(`{"fileName":"simplify","zoom":8}`); n != 4 {
+		t.Errorf("overview zoom should keep only the end points, got %d elements", n)
+	}
+	status, body := call(t, app, "alice", "pushEdges", `{"fileName":"simplify","newDocs":`+mustJSON(t, edges)+`}`)
+	if status != http.StatusBadRequest || !strings.Contains(body, "simplified") {
+		t.Errorf("push of a simplified edge should be refused, got %d %s", status, body)
+	}
+	if n := pathLen(`{"fileName":"simplify","zoom":20}`); n != 103 {
+		t.Errorf("detail zoom should keep the wiggle, got %d elements", n)
+	}
+	if n := pathLen(`{"fileName":"simplify","zoom":8,"tolerance":0}`); n != 103 {
+		t.Errorf("explicit tolerance should win over zoom, got %d elements", n)
+	}
+	if n := pathLen(`{"fileName":"simplify"}`); n != 103 {
+		t.Errorf("stored path should stay at full resolution, got %d elements", n)
+	}
+
+	if status, _ := call(t, app, "", "pullEdges", `{"fileName":"simplify","tolerance":-1}`); status != http.StatusBadRequest {
+		t.Errorf("negative tolerance should be a bad request, got %d", status)
+	}
+}
@@ -0,0 +1,258 @@
+package plugin
+
+import (
+	"container/list"
+	"context"
+	"mapgl-app/pkg/database"
+	"mapgl-app/pkg/geo"
+	"mapgl-app/pkg/mvt"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	// tileBuffer is how far, in tile units, geometry reaches past the tile
+	// edge so that lines and node symbols join seamlessly.
+	tileBuffer = 64
+	// tileTolerance is the simplification tolerance in tile units. Paths are
+	// simplified in tile space, so overview zooms drop the most points.
+	tileTolerance = 1.0
+	// tileCacheSize caps the number of cached tiles per app instance.
+	tileCacheSize = 2048
+)
+
+// handleTile renders the live edges of a seed file, and the nodes at their
+// ends, as a Mapbox Vector Tile with an "edges" and a "nodes" layer:
+// GET /tiles/{file}/{z}/{x}/{y}.mvt
+func (a *App) handleTile(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	vars := mux.Vars(req)
+	fileName := vars["file"]
+	var tile geo.Tile
+	tile.Z, _ = strconv.Atoi(vars["z"])
+	tile.X, _ = strconv.Atoi(vars["x"])
+	tile.Y, _ = strconv.Atoi(vars["y"])
+	if !tile.Valid() {
+		http.Error(w, "tile does not exist", http.StatusNotFound)
+		return
+	}
+
+	ctx, span := startSpan(req.Context(), "tile",
+		attribute.String("fileName", fileName),
+		attribute.IntSlice("tile", []int{tile.Z, tile.X, tile.Y}),
+	)
+	defer span.End()
+
+	key := tileKey{file: fileName, tile: tile}
+	data, gen, ok := a.tiles.get(key)
+	span.SetAttributes(attribute.Bool("cached", ok))
+	if !ok {
+		store, release, err := a.openStore(ctx, fileName)
+		if err != nil {
+			log.DefaultLogger.Error("Failed to get database connection:", "filename", fileName, "error", err)
+			http.Error(w, "failed to get database connection", http.StatusInternalServerError)
+			return
+		}
+		defer release()
+
+		var edges map[string]bool
+		data, edges, err = renderTile(ctx, store, tile)
+		if err != nil {
+			tracing.Error(span, err)
+			log.DefaultLogger.Error("Failed to render tile", "filename", fileName, "tile", tile, "error", err)
+			http.Error(w, "failed to render tile", http.StatusInternalServerError)
+			return
+		}
+		// Tiles of a shared Redis can change through other replicas.
+		if a.redis == nil {
+			a.tiles.put(key, gen, data, edges)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.mapbox-vector-tile")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// renderTile encodes a tile and returns the ids of the edges that were
+// candidates for it, so that pushes to them invalidate the tile.
+func renderTile(ctx context.Context, store database.Store, tile geo.Tile) ([]byte, map[string]bool, error) {
+	bounds := tile.Bounds(float64(tileBuffer) / mvt.DefaultExtent)
+	items, err := loadEdges(ctx, store, 0, &bounds)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	_, span := startSpan(ctx, "mvt.Encode", attribute.Int("items", len(items)))
+	defer span.End()
+
+	edgeLayer := mvt.Layer{Name: "edges", Extent: mvt.DefaultExtent}
+	nodeLayer := mvt.Layer{Name: "nodes", Extent: mvt.DefaultExtent}
+	nodes := map[string]bool{}
+	candidates := make(map[string]bool, len(items))
+	lo, hi := -float64(tileBuffer), float64(mvt.DefaultExtent+tileBuffer)
+
+	for _, item := range items {
+		id, _ := item["id"].(string)
+		candidates[id] = true
+		if deleted, _ := item["_deleted"].(bool); deleted {
+			continue
+		}
+		parPath, _ := item["parPath"].([]interface{})
+		points := geo.PathPoints(parPath)
+		if len(points) == 0 {
+			continue
+		}
+
+		line := make([][2]float64, len(points))
+		for i, p := range points {
+			line[i] = tile.Project(p, mvt.DefaultExtent)
+		}
+		var parts [][][2]float64
+		for _, piece := range geo.ClipLine(line, lo, hi) {
+			parts = append(parts, geo.SimplifyXY(piece, tileTolerance))
+		}
+		target, _ := parPath[0].(string)
+		source, _ := parPath[len(parPath)-1].(string)
+		if len(parts) > 0 {
+			props := map[string]interface{}{"id": id, "target": target, "source": source}
+			if isEph, ok := item["isEph"].(bool); ok {
+				props["isEph"] = isEph
+			}
+			edgeLayer.Features = append(edgeLayer.Features, mvt.Feature{Type: mvt.LineString, Parts: parts, Properties: props})
+		}
+
+		// Named ends sit at the first and last point of the path.
+		for _, end := range []struct {
+			name string
+			at   [2]float64
+		}{{target, line[0]}, {source, line[len(line)-1]}} {
+			if end.name == "" || nodes[end.name] || !inSquare(end.at, lo, hi) {
+				continue
+			}
+			nodes[end.name] = true
+			nodeLayer.Features = append(nodeLayer.Features, mvt.Feature{
+				Type:       mvt.Point,
+				Parts:      [][][2]float64{{end.at}},
+				Properties: map[string]interface{}{"name": end.name},
+			})
+		}
+	}
+
+	span.SetAttributes(attribute.Int("edges", len(edgeLayer.Features)), attribute.Int("nodes", len(nodeLayer.Features)))
+	data, err := mvt.Encode([]mvt.Layer{edgeLayer, nodeLayer})
+	if err != nil {
+		return nil, nil, tracing.Error(span, err)
+	}
+	return data, candidates, nil
+}
+
+func inSquare(p [2]float64, lo, hi float64) bool {
+	return p[0] >= lo && p[0] <= hi && p[1] >= lo && p[1] <= hi
+}
+
+type tileKey struct {
+	file string
+	tile geo.Tile
+}
+
+type tileEntry struct {
+	key    tileKey
+	data   []byte
+	bounds geo.BBox
+	edges  map[string]bool
+}
+
+// tileCache keeps rendered tiles until a write touches them. Every
+// invalidation bumps the generation of the file, and a tile rendered under
+// an older generation is not stored, so a push racing with a render can't
+// leave a stale tile behind.
+type tileCache struct {
+	mu      sync.Mutex
+	max     int
+	entries map[tileKey]*list.Element
+	lru     *list.List
+	gens    map[string]uint64
+}
+
+func newTileCache(max int) *tileCache {
+	return &tileCache{
+		max:     max,
+		entries: make(map[tileKey]*list.Element),
+		lru:     list.New(),
+		gens:    make(map[string]uint64),
+	}
+}
+
+// get returns a cached tile, or the generation to pass to put.
+func (c *tileCache) get(key tileKey) ([]byte, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(el)
+		return el.Value.(*tileEntry).data, 0, true
+	}
+	return nil, c.gens[key.file], false
+}
+
+func (c *tileCache) put(key tileKey, gen uint64, data []byte, edges map[string]bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.gens[key.file] != gen {
+		return
+	}
+	if el, ok := c.entries[key]; ok {
+		c.lru.Remove(el)
+	}
+	e := &tileEntry{key: key, data: data, bounds: key.tile.Bounds(float64(tileBuffer) / mvt.DefaultExtent), edges: edges}
+	c.entries[key] = c.lru.PushFront(e)
+
+	for c.lru.Len() > c.max {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*tileEntry).key)
+	}
+}
+
+// invalidateEdge drops the tiles of file that showed the edge or that its
+// new bounding box reaches into.
+func (c *tileCache) invalidateEdge(file, id string, bbox *geo.BBox) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gens[file]++
+	for key, el := range c.entries {
+		e := el.Value.(*tileEntry)
+		if key.file == file && (e.edges[id] || bbox != nil && e.bounds.Intersects(*bbox)) {
+			c.lru.Remove(el)
+			delete(c.entries, key)
+		}
+	}
+}
+
+// invalidateFile drops every tile of file.
+func (c *tileCache) invalidateFile(file string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gens[file]++
+	for key, el := range c.entries {
+		if key.file == file {
+			c.lru.Remove(el)
+			delete(c.entries, key)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mapgl-app/pkg/database"
+	"mapgl-app/pkg/geo"
+	"math"
+	"net/http"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// TestTiles renders a tile, serves it again from the cache and checks that
+// a write to one of its edges invalidates it.
+func TestTiles(t *testing.T) {
+	app := newTestApp(t, "")
+
+	ctx := context.Background()
+	paris := []interface{}{"Louvre", []interface{}{2.3376, 48.8606}, []interface{}{2.2945, 48.8584}, "Eiffel"}
+	db, err := app.openDB(ctx, "tiles")
+	if err != nil {
+		t.Fatalf("open: %s", err)
+	}
+	writeEdge := func(parPath []interface{}) {
+		t.Helper()
+		if err := db.HSet(ctx, "e1", map[string]any{"deleted": false, "parPath": mustJSON(t, parPath)}); err != nil {
+			t.Fatalf("seed: %s", err)
+		}
+		if err := db.ZAdd(ctx, database.EdgesIndex, "e1", 1); err != nil {
+			t.Fatalf("seed: %s", err)
+		}
+		if err := app.edgeWritten(ctx, db, "tiles", "e1", parPath, 10); err != nil {
+			t.Fatalf("edgeWritten: %s", err)
+		}
+	}
+	writeEdge(paris)
+	defer db.Release()
+
+	world := geo.Tile{Z: 12}.Project(geo.Point{Lon: 2.3, Lat: 48.86}, 1)
+	tile := geo.Tile{Z: 12, X: int(math.Floor(world[0])), Y: int(math.Floor(world[1]))}
+	path := fmt.Sprintf("tiles/tiles/%d/%d/%d.mvt", tile.Z, tile.X, tile.Y)
+
+	getTile := func() []byte {
+		t.Helper()
+		res := callResource(t, app, &backend.CallResourceRequest{Method: http.MethodGet, Path: path, URL: path})
+		if res.Status != http.StatusOK {
+			t.Fatalf("GET %s: %+v", path, res)
+		}
+		return res.Body
+	}
+
+	first := getTile()
+	for _, s := range []string{"edges", "nodes", "Louvre", "Eiffel", "e1"} {
+		if !bytes.Contains(first, []byte(s)) {
+			t.Errorf("tile should contain %q", s)
+		}
+	}
+	if _, _, ok := app.tiles.get(tileKey{"tiles", tile}); !ok {
+		t.Fatal("rendered tile should be cached")
+	}
+	if again := getTile(); !bytes.Equal(first, again) {
+		t.Error("cached tile differs from the rendered one")
+	}
+
+	// Moving the edge to Berlin invalidates the Paris tile, which is empty now.
+	writeEdge([]interface{}{"a", []interface{}{13.40, 52.52}, []interface{}{13.37, 52.51}, "b"})
+	if _, _, ok := app.tiles.get(tileKey{"tiles", tile}); ok {
+		t.Error("write to a cached edge should invalidate its tile")
+	}
+	if moved := getTile(); len(moved) != 0 {
+		t.Errorf("tile should be empty after the edge moved, got %d bytes", len(moved))
+	}
+
+	res := callResource(t, app, &backend.CallResourceRequest{Method: http.MethodGet, Path: "tiles/tiles/1/5/0.mvt", URL: "tiles/tiles/1/5/0.mvt"})
+	if res.Status != http.StatusNotFound {
+		t.Errorf("tile outside the zoom level should be 404, got %d", res.Status)
+	}
+}
@@ -0,0 +1,203 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mapgl-app/pkg/database"
+	"mapgl-app/pkg/geo"
+	"mapgl-app/pkg/graph"
+	"net/http"
+	"strconv"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// edgeEnds returns the nodes an edge connects. parPath starts with the
+// target and ends with the source; named ends are nodes by name, unnamed
+// ones are identified by their coordinates as "lon,lat".
+func edgeEnds(parPath []interface{}) (from, to string, ok bool) {
+	if len(parPath) == 0 {
+		return "", "", false
+	}
+	points := geo.PathPoints(parPath)
+	end := func(el interface{}, fallback int) string {
+		if name, ok := el.(string); ok && name != "" {
+			return name
+		}
+		if len(points) == 0 {
+			return ""
+		}
+		p := points[fallback]
+		return strconv.FormatFloat(p.Lon, 'f', 6, 64) + "," + strconv.FormatFloat(p.Lat, 'f', 6, 64)
+	}
+	to = end(parPath[0], 0)
+	from = end(parPath[len(parPath)-1], len(points)-1)
+	return from, to, from != "" && to != ""
+}
+
+// loadGraph builds the network of the live edges of a seed file, weighted
+// by geodesic length.
+func loadGraph(ctx context.Context, store database.Store) (*graph.Graph, error) {
+	items, err := loadEdges(ctx, store, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	_, span := startSpan(ctx, "graph.New", attribute.Int("items", len(items)))
+	defer span.End()
+
+	edges := make([]graph.Edge, 0, len(items))
+	for _, item := range items {
+		if deleted, _ := item["_deleted"].(bool); deleted {
+			continue
+		}
+		parPath, _ := item["parPath"].([]interface{})
+		from, to, ok := edgeEnds(parPath)
+		if !ok {
+			continue
+		}
+		id, _ := item["id"].(string)
+		length, _ := item["length"].(float64)
+		edges = append(edges, graph.Edge{ID: id, From: from, To: to, Length: length})
+	}
+	g := graph.New(edges)
+	span.SetAttributes(attribute.Int("nodes", g.NodeCount()), attribute.Int("edges", len(edges)))
+	return g, nil
+}
+
+// fileGraph loads the graph of a seed file for a topology route and writes
+// the error response if that fails.
+func (a *App) fileGraph(ctx context.Context, w http.ResponseWriter, fileName string) (*graph.Graph, bool) {
+	store, release, err := a.openStore(ctx, fileName)
+	if err != nil {
+		log.DefaultLogger.Error("Failed to get database connection:", "filename", fileName, "error", err)
+		http.Error(w, "failed to get database connection", http.StatusInternalServerError)
+		return nil, false
+	}
+	defer release()
+
+	g, err := loadGraph(ctx, store)
+	if err != nil {
+		log.DefaultLogger.Error("Failed to load graph", "filename", fileName, "error", err)
+		http.Error(w, "failed to read edges", http.StatusInternalServerError)
+		return nil, false
+	}
+	return g, true
+}
+
+// decodePost decodes the JSON body of a POST route into v and writes the
+// error response if the request is not one.
+func decodePost(w http.ResponseWriter, req *http.Request, v interface{}) bool {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	if err := json.NewDecoder(req.Body).Decode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// handleComponents lists the connected components of a seed file's network,
+// largest first.
+func (a *App) handleComponents(w http.ResponseWriter, req *http.Request) {
+	var body struct {
+		FileName string `json:"fileName"`
+	}
+	if !decodePost(w, req, &body) {
+		return
+	}
+	ctx, span := startSpan(req.Context(), "topology.components", attribute.String("fileName", body.FileName))
+	defer span.End()
+
+	g, ok := a.fileGraph(ctx, w, body.FileName)
+	if !ok {
+		return
+	}
+	comps := g.Components()
+	span.SetAttributes(attribute.Int("components", len(comps)))
+	writeJSON(ctx, w, map[string]interface{}{
+		"count":      len(comps),
+		"components": comps,
+	})
+}
+
+// handleShortestPath finds the shortest route between two nodes, by
+// geodesic length (the default) or by hop count with "weight": "hops".
+// Nodes that exist but are not connected give found: false.
+func (a *App) handleShortestPath(w http.ResponseWriter, req *http.Request) {
+	var body struct {
+		FileName string `json:"fileName"`
+		From     string `json:"from"`
+		To       string `json:"to"`
+		Weight   string `json:"weight"`
+	}
+	if !decodePost(w, req, &body) {
+		return
+	}
+	if body.Weight != "" && body.Weight != "length" && body.Weight != "hops" {
+		http.Error(w, `weight must be "length" or "hops"`, http.StatusBadRequest)
+		return
+	}
+	ctx, span := startSpan(req.Context(), "topology.shortestPath",
+		attribute.String("fileName", body.FileName),
+		attribute.String("from", body.From),
+		attribute.String("to", body.To),
+	)
+	defer span.End()
+
+	g, ok := a.fileGraph(ctx, w, body.FileName)
+	if !ok {
+		return
+	}
+	for _, n := range []string{body.From, body.To} {
+		if !g.HasNode(n) {
+			http.Error(w, fmt.Sprintf("node %q not found", n), http.StatusNotFound)
+			return
+		}
+	}
+
+	path, found := g.ShortestPath(body.From, body.To, body.Weight == "hops")
+	writeJSON(ctx, w, struct {
+		Found bool `json:"found"`
+		graph.Path
+	}{found, path})
+}
+
+// handleReachability answers what is cut off if an edge fails: the nodes
+// that lose their connection to root, or without a root, the smaller side
+// of the network the edge held together.
+func (a *App) handleReachability(w http.ResponseWriter, req *http.Request) {
+	var body struct {
+		FileName string `json:"fileName"`
+		EdgeID   string `json:"edgeId"`
+		Root     string `json:"root"`
+	}
+	if !decodePost(w, req, &body) {
+		return
+	}
+	ctx, span := startSpan(req.Context(), "topology.reachability",
+		attribute.String("fileName", body.FileName),
+		attribute.String("edgeId", body.EdgeID),
+	)
+	defer span.End()
+
+	g, ok := a.fileGraph(ctx, w, body.FileName)
+	if !ok {
+		return
+	}
+	if body.Root != "" && !g.HasNode(body.Root) {
+		http.Error(w, fmt.Sprintf("node %q not found", body.Root), http.StatusNotFound)
+		return
+	}
+	failure, ok := g.Fail(body.EdgeID, body.Root)
+	if !ok {
+		http.Error(w, fmt.Sprintf("edge %q not found or deleted", body.EdgeID), http.StatusNotFound)
+		return
+	}
+	span.SetAttributes(attribute.Bool("bridge", failure.Bridge), attribute.Int("cutOff", len(failure.CutOff)))
+	writeJSON(ctx, w, failure)
+}
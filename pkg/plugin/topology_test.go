@@ -0,0 +1,99 @@
+package plugin
+
+import (
+	"context"
+	"mapgl-app/pkg/database"
+	"mapgl-app/pkg/graph"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// TestTopology checks the topology routes on a small network: a chain
+// a-b-c with a long detour a-c, and a separate edge d-e.
+func TestTopology(t *testing.T) {
+	app := newTestApp(t, "")
+
+	ctx := context.Background()
+	db, err := app.openDB(ctx, "net")
+	if err != nil {
+		t.Fatalf("open: %s", err)
+	}
+	for id, parPath := range map[string][]interface{}{
+		"ab":     {"b", []interface{}{0.0, 0.0}, []interface{}{0.1, 0.0}, "a"},
+		"bc":     {"c", []interface{}{0.1, 0.0}, []interface{}{0.2, 0.0}, "b"},
+		"ac":     {"c", []interface{}{0.0, 0.0}, []interface{}{0.1, 1.0}, []interface{}{0.2, 0.0}, "a"},
+		"de":     {"e", []interface{}{5.0, 5.0}, []interface{}{5.1, 5.0}, "d"},
+		"gone":   {"x", []interface{}{9.0, 9.0}, "y"},
+		"noname": {"", []interface{}{5.1, 5.0}, []interface{}{5.2, 5.0}, "e"},
+	} {
+		deleted := id == "gone"
+		if err := db.HSet(ctx, id, map[string]any{"deleted": deleted, "parPath": mustJSON(t, parPath)}); err != nil {
+			t.Fatalf("seed: %s", err)
+		}
+		if err := db.ZAdd(ctx, database.EdgesIndex, id, 10); err != nil {
+			t.Fatalf("seed: %s", err)
+		}
+	}
+	db.Release()
+
+	var comps struct {
+		Count      int               `json:"count"`
+		Components []graph.Component `json:"components"`
+	}
+	callJSON(t, app, "topology/components", `{"fileName":"net"}`, &comps)
+	if comps.Count != 2 {
+		t.Fatalf("want 2 components, got %+v", comps)
+	}
+	nodes := [][]string{comps.Components[0].Nodes, comps.Components[1].Nodes}
+	// An unnamed target is keyed by the first coordinate of the path.
+	for _, want := range [][]string{{"a", "b", "c"}, {"5.100000,5.000000", "d", "e"}} {
+		if !reflect.DeepEqual(nodes[0], want) && !reflect.DeepEqual(nodes[1], want) {
+			t.Errorf("want a component with nodes %v, got %v", want, nodes)
+		}
+	}
+
+	var path struct {
+		Found bool `json:"found"`
+		graph.Path
+	}
+	callJSON(t, app, "topology/shortestPath", `{"fileName":"net","from":"a","to":"c"}`, &path)
+	if !path.Found || !reflect.DeepEqual(path.Edges, []string{"ab", "bc"}) {
+		t.Errorf("shortest path by length should go through b, got %+v", path)
+	}
+	if path.Length < 22000 || path.Length > 23000 {
+		t.Errorf("path length should be about 22.2 km, got %f", path.Length)
+	}
+	path.Edges = nil
+	callJSON(t, app, "topology/shortestPath", `{"fileName":"net","from":"a","to":"c","weight":"hops"}`, &path)
+	if !path.Found || !reflect.DeepEqual(path.Edges, []string{"ac"}) {
+		t.Errorf("shortest path by hops should be the detour, got %+v", path)
+	}
+	path = struct {
+		Found bool `json:"found"`
+		graph.Path
+	}{}
+	callJSON(t, app, "topology/shortestPath", `{"fileName":"net","from":"a","to":"d"}`, &path)
+	if path.Found {
+		t.Errorf("a and d are not connected, got %+v", path)
+	}
+
+	var failure graph.Failure
+	callJSON(t, app, "topology/reachability", `{"fileName":"net","edgeId":"de","root":"e"}`, &failure)
+	if !failure.Bridge || !reflect.DeepEqual(failure.CutOff, []string{"d"}) {
+		t.Errorf("failing de should cut off d from e, got %+v", failure)
+	}
+	callJSON(t, app, "topology/reachability", `{"fileName":"net","edgeId":"ab"}`, &failure)
+	if failure.Bridge || len(failure.CutOff) != 0 {
+		t.Errorf("ab has a detour and cuts nothing off, got %+v", failure)
+	}
+
+	for _, tc := range []struct{ path, body string }{
+		{"topology/shortestPath", `{"fileName":"net","from":"a","to":"x"}`},
+		{"topology/reachability", `{"fileName":"net","edgeId":"gone"}`},
+	} {
+		if status, body := call(t, app, "", tc.path, tc.body); status != http.StatusNotFound {
+			t.Errorf("%s %s should be not found, got %d %s", tc.path, tc.body, status, body)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package resp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrClientClosed is returned by Do once the client has been closed.
+var ErrClientClosed = errors.New("resp: client is closed")
+
+// ClientOptions configures a Client.
+type ClientOptions struct {
+	// Addr is the host:port of the server.
+	Addr string
+	// Username and Password are sent with AUTH when Password is set.
+	// An empty Username authenticates as the default user.
+	Username string
+	Password string
+	// DB is selected on every new connection when non-zero.
+	DB int
+	// PoolSize is the number of idle connections kept for reuse.
+	// Zero means 8.
+	PoolSize int
+	// DialTimeout bounds connecting and authenticating. Zero means 5s.
+	DialTimeout time.Duration
+}
+
+// Client sends commands to a Redis-compatible server over a small pool of
+// connections. It is safe for concurrent use.
+type Client struct {
+	opts ClientOptions
+
+	mu     sync.Mutex
+	idle   []*conn
+	closed bool
+}
+
+type conn struct {
+	nc net.Conn
+	r  *Reader
+	w  *Writer
+}
+
+// NewClient creates a client. Connections are opened on first use.
+func NewClient(opts ClientOptions) *Client {
+	if opts.PoolSize <= 0 {
+		opts.PoolSize = 8
+	}
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = 5 * time.Second
+	}
+	return &Client{opts: opts}
+}
+
+// Do sends one command and returns its reply. Error replies are returned
+// as an Error; the connection stays usable after them.
+func (c *Client) Do(ctx context.Context, args ...string) (interface{}, error) {
+	cn, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := cn.do(ctx, args)
+	if err != nil {
+		// The stream may be out of sync now; don't reuse the connection.
+		cn.nc.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	c.put(cn)
+
+	if e, ok := v.(Error); ok {
+		return nil, e
+	}
+	return v, nil
+}
+
+// Ping checks that the server answers.
+func (c *Client) Ping(ctx context.Context) error {
+	_, err := c.Do(ctx, "PING")
+	return err
+}
+
+// Close closes the idle connections and makes further calls fail.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	var errs []error
+	for _, cn := range c.idle {
+		if err := cn.nc.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	c.idle = nil
+	return errors.Join(errs...)
+}
+
+func (c *Client) get(ctx context.Context) (*conn, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, ErrClientClosed
+	}
+	if n := len(c.idle); n > 0 {
+		cn := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mu.Unlock()
+		return cn, nil
+	}
+	c.mu.Unlock()
+
+	return c.dial(ctx)
+}
+
+func (c *Client) put(cn *conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed || len(c.idle) >= c.opts.PoolSize {
+		cn.nc.Close()
+		return
+	}
+	c.idle = append(c.idle, cn)
+}
+
+func (c *Client) dial(ctx context.Context) (*conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.opts.DialTimeout)
+	defer cancel()
+
+	var d net.Dialer
+	nc, err := d.DialContext(ctx, "tcp", c.opts.Addr)
+	if err != nil {
+		return nil, err
+	}
+	cn := &conn{nc: nc, r: NewReader(nc), w: NewWriter(nc)}
+
+	var setup [][]string
+	if c.opts.Password != "" {
+		if c.opts.Username != "" {
+			setup = append(setup, []string{"AUTH", c.opts.Username, c.opts.Password})
+		} else {
+			setup = append(setup, []string{"AUTH", c.opts.Password})
+		}
+	}
+	if c.opts.DB != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(c.opts.DB)})
+	}
+	for _, args := range setup {
+		v, err := cn.do(ctx, args)
+		if err == nil {
+			if e, ok := v.(Error); ok {
+				err = e
+			}
+		}
+		if err != nil {
+			nc.Close()
+			return nil, fmt.Errorf("resp: %s: %w", args[0], err)
+		}
+	}
+	return cn, nil
+}
+
+func (cn *conn) do(ctx context.Context, args []string) (interface{}, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Time{}
+	}
+	if err := cn.nc.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	// Unblock the read when the request is cancelled without a deadline.
+	stop := context.AfterFunc(ctx, func() {
+		cn.nc.SetDeadline(time.Unix(1, 0))
+	})
+	defer stop()
+
+	if err := cn.w.WriteCommand(args...); err != nil {
+		return nil, err
+	}
+	return cn.r.ReadValue()
+}
@@ -0,0 +1,248 @@
+// Package resp reads and writes the Redis serialization protocol (RESP2),
+// as far as the plugin needs it to talk to Redis and to serve seed files to
+// Redis clients.
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Error is an error reply ("-ERR ...") sent by the other side.
+type Error string
+
+func (e Error) Error() string { return string(e) }
+
+// SimpleString is a status reply such as "+OK".
+type SimpleString string
+
+// ErrTooLarge is wrapped by the errors of values that exceed the Limits of
+// a Reader. The stream can't be read any further after one.
+var ErrTooLarge = errors.New("resp: value exceeds the reader limits")
+
+// Limits bound the values a Reader accepts, so that a corrupt stream or a
+// hostile peer can't make it allocate without bound.
+type Limits struct {
+	MaxBulkLen  int // bytes of a bulk string
+	MaxArrayLen int // elements of an array
+	MaxDepth    int // nesting of arrays, 1 for flat arrays
+}
+
+// DefaultLimits are the limits of new readers, for the replies of a Redis
+// server.
+var DefaultLimits = Limits{MaxBulkLen: 512 << 20, MaxArrayLen: 1 << 24, MaxDepth: 8}
+
+// maxPrealloc caps the elements allocated ahead for an array, so that its
+// header alone can't claim memory its elements never fill.
+const maxPrealloc = 1024
+
+// Reader decodes RESP values.
+type Reader struct {
+	r      *bufio.Reader
+	limits Limits
+}
+
+// NewReader creates a reader on top of r with the DefaultLimits.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r), limits: DefaultLimits}
+}
+
+// SetLimits replaces the limits of the values read next.
+func (r *Reader) SetLimits(l Limits) {
+	r.limits = l
+}
+
+// ReadValue reads one value. Bulk strings are returned as []byte, simple
+// strings as SimpleString, integers as int64, arrays as []interface{},
+// error replies as Error and nulls as nil.
+func (r *Reader) ReadValue() (interface{}, error) {
+	return r.readValue(0)
+}
+
+func (r *Reader) readValue(depth int) (interface{}, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errors.New("resp: empty line")
+	}
+
+	switch line[0] {
+	case '+':
+		return SimpleString(line[1:]), nil
+	case '-':
+		return Error(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(string(line[1:]), 10, 64)
+	case '$':
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil {
+			return nil, fmt.Errorf("resp: bad bulk length: %w", err)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		if n > r.limits.MaxBulkLen {
+			return nil, fmt.Errorf("%w: bulk string of %d bytes", ErrTooLarge, n)
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r.r, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil {
+			return nil, fmt.Errorf("resp: bad array length: %w", err)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		if n > r.limits.MaxArrayLen {
+			return nil, fmt.Errorf("%w: array of %d elements", ErrTooLarge, n)
+		}
+		if depth >= r.limits.MaxDepth {
+			return nil, fmt.Errorf("%w: arrays nested deeper than %d", ErrTooLarge, r.limits.MaxDepth)
+		}
+		arr := make([]interface{}, 0, min(n, maxPrealloc))
+		for i := 0; i < n; i++ {
+			v, err := r.readValue(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		return arr, nil
+	default:
+		// Inline commands, as typed into telnet or redis-cli --pipe.
+		return splitInline(line), nil
+	}
+}
+
+// ReadCommand reads a client command as a list of arguments.
+func (r *Reader) ReadCommand() ([]string, error) {
+	v, err := r.ReadValue()
+	if err != nil {
+		return nil, err
+	}
+	arr, ok := v.([]interface{})
+	if !ok {
+		return nil, errors.New("resp: command is not an array")
+	}
+	args := make([]string, len(arr))
+	for i, a := range arr {
+		switch a := a.(type) {
+		case []byte:
+			args[i] = string(a)
+		case SimpleString:
+			args[i] = string(a)
+		default:
+			return nil, fmt.Errorf("resp: unexpected %T in command", a)
+		}
+	}
+	return args, nil
+}
+
+func (r *Reader) readLine() ([]byte, error) {
+	line, err := r.r.ReadSlice('\n')
+	if err != nil {
+		return nil, err
+	}
+	n := len(line) - 1
+	if n > 0 && line[n-1] == '\r' {
+		n--
+	}
+	return line[:n], nil
+}
+
+func splitInline(line []byte) []interface{} {
+	var args []interface{}
+	start := -1
+	for i, c := range line {
+		if c == ' ' || c == '\t' {
+			if start >= 0 {
+				args = append(args, line[start:i])
+				start = -1
+			}
+		} else if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		args = append(args, line[start:])
+	}
+	return args
+}
+
+// Writer encodes RESP values. Call Flush to send them.
+type Writer struct {
+	w *bufio.Writer
+}
+
+// NewWriter creates a writer on top of w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+// WriteCommand writes args as an array of bulk strings.
+func (w *Writer) WriteCommand(args ...string) error {
+	w.writeHeader('*', len(args))
+	for _, a := range args {
+		w.WriteBulk(a)
+	}
+	return w.Flush()
+}
+
+// WriteSimple writes a status reply.
+func (w *Writer) WriteSimple(s string) {
+	w.w.WriteByte('+')
+	w.w.WriteString(s)
+	w.w.WriteString("\r\n")
+}
+
+// WriteError writes an error reply.
+func (w *Writer) WriteError(msg string) {
+	w.w.WriteByte('-')
+	w.w.WriteString(msg)
+	w.w.WriteString("\r\n")
+}
+
+// WriteInt writes an integer reply.
+func (w *Writer) WriteInt(n int64) {
+	w.w.WriteByte(':')
+	w.w.WriteString(strconv.FormatInt(n, 10))
+	w.w.WriteString("\r\n")
+}
+
+// WriteBulk writes a bulk string.
+func (w *Writer) WriteBulk(s string) {
+	w.writeHeader('$', len(s))
+	w.w.WriteString(s)
+	w.w.WriteString("\r\n")
+}
+
+// WriteNull writes a null bulk string.
+func (w *Writer) WriteNull() {
+	w.w.WriteString("$-1\r\n")
+}
+
+// WriteArray writes the header of an array of n values; the caller writes
+// the values next.
+func (w *Writer) WriteArray(n int) {
+	w.writeHeader('*', n)
+}
+
+// Flush sends the buffered values.
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+func (w *Writer) writeHeader(kind byte, n int) {
+	w.w.WriteByte(kind)
+	w.w.WriteString(strconv.Itoa(n))
+	w.w.WriteString("\r\n")
+}
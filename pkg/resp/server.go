@@ -0,0 +1,197 @@
+package resp
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// Handler executes the commands of authenticated clients. It writes exactly
+// one reply per command; the server flushes it.
+type Handler interface {
+	ServeRESP(ctx context.Context, w *Writer, args []string)
+}
+
+// HandlerFunc adapts a function to Handler.
+type HandlerFunc func(ctx context.Context, w *Writer, args []string)
+
+// ServeRESP calls f.
+func (f HandlerFunc) ServeRESP(ctx context.Context, w *Writer, args []string) {
+	f(ctx, w, args)
+}
+
+// Server accepts RESP connections and passes their commands to a Handler.
+// AUTH, PING and QUIT are answered by the server itself; every other
+// command needs a successful AUTH first. Clients whose
+// commands exceed the Limits of the server are disconnected.
+type Server struct {
+	ln       net.Listener
+	handler  Handler
+	password string
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+// Commands are flat arrays of bulk strings. Until a client authenticates,
+// they only need to carry AUTH.
+var (
+	preAuthLimits = Limits{MaxBulkLen: 1 << 20, MaxArrayLen: 16, MaxDepth: 1}
+	commandLimits = Limits{MaxBulkLen: 64 << 20, MaxArrayLen: 1 << 20, MaxDepth: 1}
+)
+
+// Listen binds addr and starts serving in the background to clients that
+// AUTH with password, which must not be empty.
+func Listen(addr, password string, handler Handler) (*Server, error) {
+	if password == "" {
+		return nil, errors.New("resp: a password is required")
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return Serve(ln, password, handler)
+}
+
+// Serve is Listen on a bound listener, which the server owns from then on.
+func Serve(ln net.Listener, password string, handler Handler) (*Server, error) {
+	if password == "" {
+		ln.Close()
+		return nil, errors.New("resp: a password is required")
+	}
+
+	s := &Server{
+		ln:       ln,
+		handler:  handler,
+		password: password,
+		conns:    make(map[net.Conn]struct{}),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+
+	s.wg.Add(1)
+	go s.accept()
+	return s, nil
+}
+
+// Addr returns the address the server listens on.
+func (s *Server) Addr() net.Addr {
+	return s.ln.Addr()
+}
+
+// Close stops accepting, disconnects every client and waits for the
+// running commands to finish. It is safe to call more than once.
+func (s *Server) Close() error {
+	s.cancel()
+	err := s.ln.Close()
+	if errors.Is(err, net.ErrClosed) {
+		err = nil
+	}
+
+	s.mu.Lock()
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+	for {
+		c, err := s.ln.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.DefaultLogger.Error("RESP accept failed", "error", err)
+			}
+			return
+		}
+
+		s.mu.Lock()
+		if s.ctx.Err() != nil {
+			s.mu.Unlock()
+			c.Close()
+			return
+		}
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go s.serve(c)
+	}
+}
+
+func (s *Server) serve(c net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		c.Close()
+	}()
+
+	r, w := NewReader(c), NewWriter(c)
+	authed := false
+
+	for {
+		if authed {
+			r.SetLimits(commandLimits)
+		} else {
+			r.SetLimits(preAuthLimits)
+		}
+		args, err := r.ReadCommand()
+		if errors.Is(err, ErrTooLarge) {
+			w.WriteError("ERR Protocol error: " + err.Error())
+			w.Flush()
+		}
+		if err != nil {
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		switch cmd := strings.ToUpper(args[0]); {
+		case cmd == "QUIT":
+			w.WriteSimple("OK")
+			w.Flush()
+			return
+		case cmd == "AUTH":
+			authed = s.checkAuth(args[1:])
+			if authed {
+				w.WriteSimple("OK")
+			} else {
+				w.WriteError("WRONGPASS invalid username-password pair or user is disabled.")
+			}
+		case cmd == "PING" && len(args) == 1:
+			w.WriteSimple("PONG")
+		case !authed:
+			w.WriteError("NOAUTH Authentication required.")
+		default:
+			s.handler.ServeRESP(s.ctx, w, args)
+		}
+
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// checkAuth accepts "AUTH password" and "AUTH <any user> password".
+func (s *Server) checkAuth(args []string) bool {
+	if len(args) == 0 || len(args) > 2 {
+		return false
+	}
+	given := args[len(args)-1]
+	return subtle.ConstantTimeCompare([]byte(given), []byte(s.password)) == 1
+}
@@ -1,18 +1,113 @@
 package settings
 
+import "time"
+
 const (
-	ApiToken = "token"
-	ApiPort  = "8089"
+	ApiToken      = "token"
+	ApiPort       = "8089"
+	SeedDir       = "./public/seed"
+	DbIdleTimeout = 10 * time.Minute
+	DbMaxOpen     = 64
+
+	SqliteJournalMode = "wal"
+	SqliteBusyTimeout = 5 * time.Second
+	SqliteSynchronous = "normal"
+	SqliteWriters     = 1
+
+	StorageSQLite  = "sqlite"
+	StorageRedis   = "redis"
+	RedisAddr      = "localhost:6379"
+	RedisKeyPrefix = "mapgl:"
+
+	RespHost = "127.0.0.1"
+	RespPort = "6380"
+
+	EphemeralTtl = 24 * time.Hour
+
+	EditLockTtl = 5 * time.Minute
+
+	IdempotencyTtl = 24 * time.Hour
 )
 
 // ZabbixDatasourceSettingsDTO model
 type MapglAppSettingsDTO struct {
-	ApiToken string `json:"apiToken"`
-	ApiPort  string `json:"apiPort"`
+	ApiToken         string `json:"apiToken"`
+	ApiPort          string `json:"apiPort"`
+	SeedDir          string `json:"seedDir"`
+	SignalingEnabled bool   `json:"signalingEnabled"`
+	DbIdleTimeout    string `json:"dbIdleTimeout"`
+	DbMaxOpen        int    `json:"dbMaxOpen"`
+
+	SqliteJournalMode string `json:"sqliteJournalMode"`
+	SqliteBusyTimeout string `json:"sqliteBusyTimeout"`
+	SqliteSynchronous string `json:"sqliteSynchronous"`
+	SqliteCacheSize   int    `json:"sqliteCacheSize"`
+	SqliteReaders     int    `json:"sqliteReaders"`
+	SqliteWriters     int    `json:"sqliteWriters"`
+
+	StorageBackend string `json:"storageBackend"`
+	RedisAddr      string `json:"redisAddr"`
+	RedisUsername  string `json:"redisUsername"`
+	RedisPassword  string `json:"-"`
+	RedisDb        int    `json:"redisDb"`
+	RedisKeyPrefix string `json:"redisKeyPrefix"`
+
+	RespEnabled  bool   `json:"respEnabled"`
+	RespHost     string `json:"respHost"`
+	RespPort     string `json:"respPort"`
+	RespFileName string `json:"respFileName"`
+	RespToken    string `json:"-"`
+
+	EphemeralTtl string `json:"ephemeralTtl"`
+
+	EditLockTtl string `json:"editLockTtl"`
+
+	IdempotencyTtl string `json:"idempotencyTtl"`
 }
 
 // ZabbixDatasourceSettings model
 type MapglAppSettings struct {
-	ApiToken string
-	ApiPort  string
+	ApiToken         string
+	ApiPort          string
+	SeedDir          string
+	SignalingEnabled bool
+	DbIdleTimeout    time.Duration
+	DbMaxOpen        int
+
+	SqliteJournalMode string
+	SqliteBusyTimeout time.Duration
+	SqliteSynchronous string
+	SqliteCacheSize   int
+	SqliteReaders     int
+	SqliteWriters     int
+
+	// StorageBackend is StorageSQLite (seed files in SeedDir) or
+	// StorageRedis (an external server shared by Grafana replicas).
+	StorageBackend string
+	RedisAddr      string
+	RedisUsername  string
+	RedisPassword  string
+	RedisDb        int
+	RedisKeyPrefix string
+
+	// RespEnabled starts a RESP listener on RespHost:RespPort serving the
+	// seed file RespFileName to Redis clients that AUTH with RespToken.
+	// RespHost defaults to the loopback interface.
+	RespEnabled  bool
+	RespHost     string
+	RespPort     string
+	RespFileName string
+	RespToken    string
+
+	// EphemeralTtl is how long edges pushed with isEph live before the
+	// server deletes them; zero keeps them forever.
+	EphemeralTtl time.Duration
+
+	// EditLockTtl is how long an edit lock on an edge or id lasts unless
+	// its holder renews it.
+	EditLockTtl time.Duration
+
+	// IdempotencyTtl is how long the result of a push with an
+	// Idempotency-Key is replayed for repeats of the key.
+	IdempotencyTtl time.Duration
 }
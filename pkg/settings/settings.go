@@ -2,32 +2,161 @@ package settings
 
 import (
 	"encoding/json"
+	"fmt"
+	"time"
+
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 )
 
 func ReadMapglSettings(dsInstanceSettings *backend.AppInstanceSettings) (*MapglAppSettings, error) {
 	mapglSettingsDTO := &MapglAppSettingsDTO{}
 
-	 err := json.Unmarshal(dsInstanceSettings.JSONData, &mapglSettingsDTO)
-        if err != nil {
-            return nil, err
-        }
-
-    if apiToken, exists := dsInstanceSettings.DecryptedSecureJSONData["apiToken"]; exists {
-        mapglSettingsDTO.ApiToken = apiToken
-      }
+	// Freshly installed apps have no jsonData yet; keep the defaults below.
+	if len(dsInstanceSettings.JSONData) > 0 {
+		if err := json.Unmarshal(dsInstanceSettings.JSONData, &mapglSettingsDTO); err != nil {
+			return nil, err
+		}
+	}
 
+	if apiToken, exists := dsInstanceSettings.DecryptedSecureJSONData["apiToken"]; exists {
+		mapglSettingsDTO.ApiToken = apiToken
+	}
+	if redisPassword, exists := dsInstanceSettings.DecryptedSecureJSONData["redisPassword"]; exists {
+		mapglSettingsDTO.RedisPassword = redisPassword
+	}
+	if respToken, exists := dsInstanceSettings.DecryptedSecureJSONData["respToken"]; exists {
+		mapglSettingsDTO.RespToken = respToken
+	}
 
 	if mapglSettingsDTO.ApiToken == "" {
 		mapglSettingsDTO.ApiToken = ApiToken
 	}
 	if mapglSettingsDTO.ApiPort == "" {
-    		mapglSettingsDTO.ApiPort = ApiPort
-    	}
+		mapglSettingsDTO.ApiPort = ApiPort
+	}
+	if mapglSettingsDTO.SeedDir == "" {
+		mapglSettingsDTO.SeedDir = SeedDir
+	}
+	if mapglSettingsDTO.DbMaxOpen == 0 {
+		mapglSettingsDTO.DbMaxOpen = DbMaxOpen
+	}
+
+	dbIdleTimeout := DbIdleTimeout
+	if mapglSettingsDTO.DbIdleTimeout != "" {
+		d, err := time.ParseDuration(mapglSettingsDTO.DbIdleTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dbIdleTimeout: %w", err)
+		}
+		dbIdleTimeout = d
+	}
+
+	if mapglSettingsDTO.SqliteJournalMode == "" {
+		mapglSettingsDTO.SqliteJournalMode = SqliteJournalMode
+	}
+	if mapglSettingsDTO.SqliteSynchronous == "" {
+		mapglSettingsDTO.SqliteSynchronous = SqliteSynchronous
+	}
+	if mapglSettingsDTO.SqliteWriters == 0 {
+		mapglSettingsDTO.SqliteWriters = SqliteWriters
+	}
+
+	sqliteBusyTimeout := SqliteBusyTimeout
+	if mapglSettingsDTO.SqliteBusyTimeout != "" {
+		d, err := time.ParseDuration(mapglSettingsDTO.SqliteBusyTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sqliteBusyTimeout: %w", err)
+		}
+		sqliteBusyTimeout = d
+	}
+
+	ephemeralTtl := EphemeralTtl
+	if mapglSettingsDTO.EphemeralTtl != "" {
+		d, err := time.ParseDuration(mapglSettingsDTO.EphemeralTtl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ephemeralTtl: %w", err)
+		}
+		if d < 0 {
+			return nil, fmt.Errorf("invalid ephemeralTtl: %s is negative", d)
+		}
+		ephemeralTtl = d
+	}
+
+	editLockTtl := EditLockTtl
+	if mapglSettingsDTO.EditLockTtl != "" {
+		d, err := time.ParseDuration(mapglSettingsDTO.EditLockTtl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid editLockTtl: %w", err)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("invalid editLockTtl: %s is not positive", d)
+		}
+		editLockTtl = d
+	}
+
+	idempotencyTtl := IdempotencyTtl
+	if mapglSettingsDTO.IdempotencyTtl != "" {
+		d, err := time.ParseDuration(mapglSettingsDTO.IdempotencyTtl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid idempotencyTtl: %w", err)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("invalid idempotencyTtl: %s is not positive", d)
+		}
+		idempotencyTtl = d
+	}
+
+	switch mapglSettingsDTO.StorageBackend {
+	case "":
+		mapglSettingsDTO.StorageBackend = StorageSQLite
+	case StorageSQLite, StorageRedis:
+	default:
+		return nil, fmt.Errorf("invalid storageBackend %q", mapglSettingsDTO.StorageBackend)
+	}
+	if mapglSettingsDTO.RedisAddr == "" {
+		mapglSettingsDTO.RedisAddr = RedisAddr
+	}
+	if mapglSettingsDTO.RedisKeyPrefix == "" {
+		mapglSettingsDTO.RedisKeyPrefix = RedisKeyPrefix
+	}
+	if mapglSettingsDTO.RespHost == "" {
+		mapglSettingsDTO.RespHost = RespHost
+	}
+	if mapglSettingsDTO.RespPort == "" {
+		mapglSettingsDTO.RespPort = RespPort
+	}
 
 	mapglSettings := &MapglAppSettings{
-		ApiToken: mapglSettingsDTO.ApiToken,
-		ApiPort:  mapglSettingsDTO.ApiPort,
+		ApiToken:         mapglSettingsDTO.ApiToken,
+		ApiPort:          mapglSettingsDTO.ApiPort,
+		SeedDir:          mapglSettingsDTO.SeedDir,
+		SignalingEnabled: mapglSettingsDTO.SignalingEnabled,
+		DbIdleTimeout:    dbIdleTimeout,
+		DbMaxOpen:        mapglSettingsDTO.DbMaxOpen,
+
+		SqliteJournalMode: mapglSettingsDTO.SqliteJournalMode,
+		SqliteBusyTimeout: sqliteBusyTimeout,
+		SqliteSynchronous: mapglSettingsDTO.SqliteSynchronous,
+		SqliteCacheSize:   mapglSettingsDTO.SqliteCacheSize,
+		SqliteReaders:     mapglSettingsDTO.SqliteReaders,
+		SqliteWriters:     mapglSettingsDTO.SqliteWriters,
+
+		StorageBackend: mapglSettingsDTO.StorageBackend,
+		RedisAddr:      mapglSettingsDTO.RedisAddr,
+		RedisUsername:  mapglSettingsDTO.RedisUsername,
+		RedisPassword:  mapglSettingsDTO.RedisPassword,
+		RedisDb:        mapglSettingsDTO.RedisDb,
+		RedisKeyPrefix: mapglSettingsDTO.RedisKeyPrefix,
+
+		RespEnabled:  mapglSettingsDTO.RespEnabled,
+		RespHost:     mapglSettingsDTO.RespHost,
+		RespPort:     mapglSettingsDTO.RespPort,
+		RespFileName: mapglSettingsDTO.RespFileName,
+		RespToken:    mapglSettingsDTO.RespToken,
+
+		EphemeralTtl: ephemeralTtl,
+
+		EditLockTtl:    editLockTtl,
+		IdempotencyTtl: idempotencyTtl,
 	}
 
 	return mapglSettings, nil
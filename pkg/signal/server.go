@@ -1,20 +1,23 @@
 package signal
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"mapgl-app/pkg/util"
 	"math/rand"
 	"net/http"
 	"strings"
 	"time"
 )
 
-func StartSignalingServerSimplePeer(serverOptions ServerOptions, r *mux.Router) *signalingServer { //, r *mux.Router
+func StartSignalingServerSimplePeer(serverOptions ServerOptions, r *mux.Router) *SignalingServer { //, r *mux.Router
 	log.DefaultLogger.Info("Starting Signaling Server111111111111111111111")
-	server := &signalingServer{
+	server := &SignalingServer{
 		peersByID:   make(map[string]*serverPeer),
 		peersByRoom: make(map[string]map[string]struct{}),
 		serverOpts:  serverOptions,
@@ -28,21 +31,35 @@ func StartSignalingServerSimplePeer(serverOptions ServerOptions, r *mux.Router)
 		},
 	}
 
-	http.HandleFunc("/", server.handleWebSocket)
+	// Every app instance gets its own mux: registering on the default one
+	// panics once Grafana recreates the instance after a settings change.
+	handler := http.NewServeMux()
+	handler.HandleFunc("/", server.handleWebSocket)
+	server.httpServer = &http.Server{
+		Addr:    fmt.Sprintf(":%s", serverOptions.Port),
+		Handler: handler,
+	}
 
 	go server.startCleanupRoutine()
 
 	go func() {
 
-		addr := fmt.Sprintf(":%s", serverOptions.Port)
+		addr := server.httpServer.Addr
 		token := serverOptions.ApiToken //fmt.Sprintf(":%s", serverOptions.ApiToken)
 
 		log.DefaultLogger.Info("WebSocket server listening on ws://localhost " + addr)
 		log.DefaultLogger.Info("SERVER API_TOKEN: " + token)
 
-		err := http.ListenAndServe(addr, nil)
-
+		// The previous instance releases the port only when Grafana disposes
+		// it, after this one was created.
+		ln, err := util.ListenWhenFree(server.serverDone, addr)
 		if err != nil {
+			// Stopped before the port was released.
+			return
+		}
+		err = server.httpServer.Serve(ln)
+
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			log.DefaultLogger.Error("ListenAndServe: ", err)
 		}
 	}()
@@ -50,7 +67,7 @@ func StartSignalingServerSimplePeer(serverOptions ServerOptions, r *mux.Router)
 	return server
 }
 
-func (s *signalingServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+func (s *SignalingServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 	conHeader := strings.ToLower(r.Header.Get("Connection"))
 	upg := strings.ToLower(r.Header.Get("Upgrade"))
 	log.DefaultLogger.Info(fmt.Sprintf("con i web %v %v", conHeader, upg))
@@ -101,7 +118,7 @@ func (s *signalingServer) handleWebSocket(w http.ResponseWriter, r *http.Request
 		for {
 			_, msg, err := conn.ReadMessage()
 			if err != nil {
-				log.DefaultLogger.Error(fmt.Sprintf("Error reading message:", err))
+				log.DefaultLogger.Error(fmt.Sprintf("Error reading message: %v", err))
 				s.disconnectSocket(peerID, "socket disconnected")
 				return
 			}
@@ -189,7 +206,7 @@ func (s *signalingServer) handleWebSocket(w http.ResponseWriter, r *http.Request
 	})
 }
 
-func (s *signalingServer) disconnectSocket(peerID, reason string) {
+func (s *SignalingServer) disconnectSocket(peerID, reason string) {
 	s.peersMutex.Lock()
 	defer s.peersMutex.Unlock()
 
@@ -217,19 +234,45 @@ func (s *signalingServer) disconnectSocket(peerID, reason string) {
 	delete(s.peersByID, peerID)
 }
 
-func (s *signalingServer) startCleanupRoutine() {
+// Stop closes the listener and all peer sockets and ends the cleanup
+// routine. It is safe to call more than once.
+func (s *SignalingServer) Stop() error {
+	s.stopOnce.Do(func() {
+		close(s.serverDone)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := s.httpServer.Shutdown(ctx)
+
+	// Hijacked websocket connections are not tracked by http.Server.
+	s.peersMutex.Lock()
+	defer s.peersMutex.Unlock()
+	for peerID, peer := range s.peersByID {
+		peer.socket.Close()
+		delete(s.peersByID, peerID)
+	}
+	s.peersByRoom = make(map[string]map[string]struct{})
+
+	log.DefaultLogger.Info("Signaling server stopped", "addr", s.httpServer.Addr)
+	return err
+}
+
+func (s *SignalingServer) startCleanupRoutine() {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
 	for {
 		select {
 		case <-s.serverDone:
 			return
-		default:
-			time.Sleep(5 * time.Second)
+		case <-ticker.C:
 			s.cleanupPeers()
 		}
 	}
 }
 
-func (s *signalingServer) cleanupPeers() {
+func (s *SignalingServer) cleanupPeers() {
 	s.peersMutex.Lock()
 	defer s.peersMutex.Unlock()
 
@@ -278,7 +321,7 @@ func getFromMapOrCreate[K comparable, V any](
 	return value
 }
 
-func (s *signalingServer) sendMessage(conn *websocket.Conn, msg message) {
+func (s *SignalingServer) sendMessage(conn *websocket.Conn, msg message) {
 	s.peersMutex.Lock()
 	defer s.peersMutex.Unlock()
 
@@ -294,7 +337,7 @@ func (s *signalingServer) sendMessage(conn *websocket.Conn, msg message) {
 	}
 }
 
-func (s *signalingServer) nSendMessage(conn *websocket.Conn, data []byte) {
+func (s *SignalingServer) nSendMessage(conn *websocket.Conn, data []byte) {
 	s.peersMutex.Lock()
 	defer s.peersMutex.Unlock()
 
@@ -2,6 +2,7 @@ package signal
 
 import (
 	"github.com/gorilla/websocket"
+	"net/http"
 	"sync"
 )
 
@@ -26,13 +27,18 @@ type serverPeer struct {
 	lastPing int64
 }
 
-type signalingServer struct {
+// SignalingServer relays simple-peer signaling messages between the browsers
+// replicating a seed file. Start it with StartSignalingServerSimplePeer and
+// shut it down with Stop.
+type SignalingServer struct {
 	peersMutex  sync.Mutex
 	peersByID   map[string]*serverPeer
 	peersByRoom map[string]map[string]struct{}
 	upgrader    websocket.Upgrader
 	serverOpts  ServerOptions
 	serverDone  chan struct{}
+	stopOnce    sync.Once
+	httpServer  *http.Server
 }
 
 type ServerOptions struct {
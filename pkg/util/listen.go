@@ -0,0 +1,27 @@
+package util
+
+import (
+	"net"
+	"time"
+)
+
+// bindRetryInterval is how often ListenWhenFree retries a failed bind.
+const bindRetryInterval = 250 * time.Millisecond
+
+// ListenWhenFree binds a TCP listener on addr, retrying while the bind
+// fails until done is closed. Grafana creates a new plugin
+// instance before it disposes the previous one, so the ports of the new
+// instance are only free once the previous one is gone.
+func ListenWhenFree(done <-chan struct{}, addr string) (net.Listener, error) {
+	for {
+		ln, err := net.Listen("tcp", addr)
+		if err == nil {
+			return ln, nil
+		}
+		select {
+		case <-done:
+			return nil, err
+		case <-time.After(bindRetryInterval):
+		}
+	}
+}